@@ -29,3 +29,103 @@ const (
 	// TODO: test why it can not be tested in non default namespace
 	DefaultTestNamespace string = "default"
 )
+
+// Default values for TangServer spec fields, applied by the defaulting webhook and by the controller
+const (
+	DefaultImage                 = "registry.redhat.io/rhel9/tang"
+	DefaultVersion               = "latest"
+	DefaultKeyPath               = "/var/db/tang"
+	DefaultPersistentVolumeClaim = "tangserver-pvc"
+	DefaultPodListenPort         = 8080
+	DefaultServiceListenPort     = 7500
+)
+
+// KeyPathPattern restricts key paths to characters that are safe to use as arguments of the commands run in tang pods
+const KeyPathPattern = `^/[A-Za-z0-9._/-]*$`
+
+// HiddenKeysEmptyAnnotation records in v1beta1 objects that spec.hiddenKeys was set to an empty list in this version,
+// as the empty list is dropped when the object is stored
+const HiddenKeysEmptyAnnotation = "nbde.openshift.io/hidden-keys-empty"
+
+// States of the key pairs reported in TangServer status
+const (
+	KeyPairStateActive string = "Active"
+	KeyPairStateHidden string = "Hidden"
+)
+
+// Actions requested for the keys of spec.hiddenKeys
+const (
+	// HiddenKeyActionHide rotates the key if it is active, and keeps it hidden
+	HiddenKeyActionHide string = "Hide"
+	// HiddenKeyActionKeepHidden keeps the key if it is hidden, without rotating it if it is active
+	HiddenKeyActionKeepHidden string = "KeepHidden"
+	// HiddenKeyActionDelete deletes the key pair if it is hidden, once confirmed with its thumbprint
+	HiddenKeyActionDelete string = "Delete"
+)
+
+// Condition types reported in TangServer status
+const (
+	// ConditionAvailable is true when the Tang Server deployment has all its replicas ready
+	ConditionAvailable string = "Available"
+	// ConditionProgressing is true while the deployment is being created or rolled out
+	ConditionProgressing string = "Progressing"
+	// ConditionDegraded is true when the last reconciliation hit an error
+	ConditionDegraded string = "Degraded"
+	// ConditionKeysReady is true when the Tang Server advertises at least one active key, and the required active
+	// key pairs when specified
+	ConditionKeysReady string = "KeysReady"
+	// ConditionServiceReady is true when the Tang Server service is reachable
+	ConditionServiceReady string = "ServiceReady"
+	// ConditionRotationInProgress is true while a key rotation is being performed
+	ConditionRotationInProgress string = "RotationInProgress"
+	// ConditionKeysPaired is true when every signing key is reliably paired with its exchange key
+	ConditionKeysPaired string = "KeysPaired"
+	// ConditionKeysLocked is true while the key directory lock is held by another holder, delaying key management
+	ConditionKeysLocked string = "KeysLocked"
+	// ConditionHiddenKeysMigrationRequired is true when spec.hiddenKeys relies on hidden keys omitted from it being
+	// deleted, which previous operator versions did
+	ConditionHiddenKeysMigrationRequired string = "HiddenKeysMigrationRequired"
+	// ConditionKeysDiverged is true when the key files of a ready replica differ from the authoritative replica
+	ConditionKeysDiverged string = "KeysDiverged"
+	// ConditionPodsCrashLooping is true when a container of a tang pod is in CrashLoopBackOff
+	ConditionPodsCrashLooping string = "PodsCrashLooping"
+)
+
+// Condition reasons reported in TangServer status
+const (
+	ReasonAsExpected               string = "AsExpected"
+	ReasonDeploymentCreated        string = "DeploymentCreated"
+	ReasonDeploymentCreateFailed   string = "DeploymentCreateFailed"
+	ReasonDeploymentUpdateFailed   string = "DeploymentUpdateFailed"
+	ReasonDeploymentNotReady       string = "DeploymentNotReady"
+	ReasonDeploymentReady          string = "DeploymentReady"
+	ReasonPodListFailed            string = "PodListFailed"
+	ReasonServiceCreated           string = "ServiceCreated"
+	ReasonServiceReconcileFailed   string = "ServiceReconcileFailed"
+	ReasonServiceReady             string = "ServiceReady"
+	ReasonLoadBalancerPending      string = "LoadBalancerPending"
+	ReasonActiveKeysAvailable      string = "ActiveKeysAvailable"
+	ReasonNoActiveKeys             string = "NoActiveKeys"
+	ReasonActiveKeysConverging     string = "ActiveKeysConverging"
+	ReasonKeyRotationStarted       string = "KeyRotationStarted"
+	ReasonKeyRotationSucceeded     string = "KeyRotationSucceeded"
+	ReasonKeyRotationFailed        string = "KeyRotationFailed"
+	ReasonKeyRotationRolledBack    string = "KeyRotationRolledBack"
+	ReasonHiddenKeysDeletionFailed string = "HiddenKeysDeletionFailed"
+	ReasonKeyManagementTimeout     string = "KeyManagementTimeout"
+	ReasonInvalidKeyPath           string = "InvalidKeyPath"
+	ReasonKeysPaired               string = "KeysPaired"
+	ReasonAmbiguousKeyPairs        string = "AmbiguousKeyPairs"
+	ReasonUnpairedKeys             string = "UnpairedKeys"
+	ReasonKeyLockAvailable         string = "KeyLockAvailable"
+	ReasonKeyLockHeld              string = "KeyLockHeld"
+	ReasonKeyLockRecovered         string = "KeyLockRecovered"
+	ReasonKeyLockFailed            string = "KeyLockFailed"
+	ReasonInvalidKeyRotationPolicy string = "InvalidKeyRotationPolicy"
+	ReasonImplicitDeletionRemoved  string = "ImplicitDeletionRemoved"
+	ReasonReplicaKeysConsistent    string = "ReplicaKeysConsistent"
+	ReasonReplicaKeysDiverged      string = "ReplicaKeysDiverged"
+	ReasonReplicaKeysUnknown       string = "ReplicaKeysUnknown"
+	ReasonPodsCrashLooping         string = "PodsCrashLooping"
+	ReasonNoPodsCrashLooping       string = "NoPodsCrashLooping"
+)
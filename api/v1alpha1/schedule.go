@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum duration of a maintenance window, a longer one would always be open
+const MaxMaintenanceWindowDuration = 24 * time.Hour
+
+// Time searched for the next activation of a cron schedule, so that impossible dates (e.g. 31st of February) end
+const cronScheduleSearchLimit = 5 * 365 * 24 * time.Hour
+
+// cronField describes the range of values of a cron schedule field
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+// Fields of a cron schedule, in order. Sunday is both 0 and 7 in day of week
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// CronSchedule is a parsed cron expression, evaluated in UTC
+// +kubebuilder:object:generate=false
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// Restricted days: when both day of month and day of week are restricted, either of them matches
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCronSchedule parses a standard 5 field cron expression: minute hour day-of-month month day-of-week.
+// Fields accept '*', values, ranges ('1-5'), steps ('*/15', '0-30/10') and lists of them ('1,15')
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("expected %d fields (minute hour day-of-month month day-of-week), found %d", len(cronFields), len(fields))
+	}
+	var values [5]uint64
+	for i, f := range fields {
+		v, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+	return &CronSchedule{
+		minute:        values[0],
+		hour:          values[1],
+		dayOfMonth:    values[2],
+		month:         values[3],
+		dayOfWeek:     values[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField returns the bit set of the values of a cron schedule field
+func parseCronField(value string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		rangeValue, stepValue, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepValue)
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field %q", stepValue, f.name, value)
+			}
+			step = s
+		}
+		low, high := f.min, f.max
+		if rangeValue != "*" {
+			lowValue, highValue, isRange := strings.Cut(rangeValue, "-")
+			var err error
+			if low, err = strconv.Atoi(lowValue); err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field %q", lowValue, f.name, value)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highValue); err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s field %q", highValue, f.name, value)
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if low < f.min || high > f.max || low > high {
+				return 0, fmt.Errorf("%s field %q out of range %d-%d", f.name, value, f.min, f.max)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay returns true if the schedule is active on the day of the time provided
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first activation of the schedule strictly after the time provided, in UTC.
+// It returns the zero time if the schedule never activates (e.g. 30th of February)
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronScheduleSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// StartOffset returns the time of the day the maintenance window opens, as the time elapsed since midnight
+func (w *MaintenanceWindow) StartOffset() (time.Duration, error) {
+	hours, minutes, found := strings.Cut(w.Start, ":")
+	h, errHours := strconv.Atoi(hours)
+	m, errMinutes := strconv.Atoi(minutes)
+	if !found || len(hours) != 2 || len(minutes) != 2 || errHours != nil || errMinutes != nil || h > 23 || m > 59 || h < 0 || m < 0 {
+		return 0, fmt.Errorf("invalid maintenance window start %q, expected HH:MM", w.Start)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// NextOpen returns the time provided if the maintenance window is open then, or the time it opens next otherwise
+func (w *MaintenanceWindow) NextOpen(t time.Time) (time.Time, error) {
+	offset, err := w.StartOffset()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if w.Duration.Duration >= MaxMaintenanceWindowDuration {
+		return t, nil
+	}
+	t = t.UTC()
+	// The window opened the day before may still be open after midnight
+	for day := -1; day <= 1; day++ {
+		start := time.Date(t.Year(), t.Month(), t.Day()+day, 0, 0, 0, 0, time.UTC).Add(offset)
+		if start.After(t) {
+			return start, nil
+		}
+		if t.Before(start.Add(w.Duration.Duration)) {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to find maintenance window after %s", t)
+}
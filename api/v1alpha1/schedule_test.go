@@ -0,0 +1,88 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Key rotation schedule", func() {
+	// Sunday
+	base := time.Date(2023, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	Context("When parsing cron schedules", func() {
+		It("Should reject malformed expressions", func() {
+			for _, expression := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *",
+				"* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+				_, err := ParseCronSchedule(expression)
+				Expect(err).To(HaveOccurred(), expression)
+			}
+		})
+
+		It("Should return the next activation after the time provided", func() {
+			for expression, next := range map[string]time.Time{
+				"* * * * *":        base.Add(time.Minute),
+				"30 10 * * *":      base.AddDate(0, 0, 1),
+				"*/15 * * * *":     base.Add(15 * time.Minute),
+				"0 2 * * 1-5":      time.Date(2023, 1, 2, 2, 0, 0, 0, time.UTC),
+				"0 0 1 */3 *":      time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+				"0 3 15 * 7":       time.Date(2023, 1, 8, 3, 0, 0, 0, time.UTC),
+				"0,45 10,12 * * *": base.Add(15 * time.Minute),
+				"0 0 29 2 *":       time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			} {
+				schedule, err := ParseCronSchedule(expression)
+				Expect(err).ToNot(HaveOccurred(), expression)
+				Expect(schedule.Next(base)).To(Equal(next), expression)
+			}
+		})
+
+		It("Should never activate on impossible dates", func() {
+			schedule, err := ParseCronSchedule("0 0 30 2 *")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(schedule.Next(base).IsZero()).To(BeTrue())
+		})
+	})
+
+	Context("When checking maintenance windows", func() {
+		window := &MaintenanceWindow{Start: "23:00", Duration: metav1.Duration{Duration: 3 * time.Hour}}
+
+		It("Should return the time provided while the window is open", func() {
+			for _, t := range []time.Time{
+				time.Date(2023, 1, 1, 23, 30, 0, 0, time.UTC),
+				time.Date(2023, 1, 2, 1, 59, 0, 0, time.UTC),
+			} {
+				Expect(window.NextOpen(t)).To(Equal(t))
+			}
+		})
+
+		It("Should return the next opening while the window is closed", func() {
+			Expect(window.NextOpen(base)).To(Equal(time.Date(2023, 1, 1, 23, 0, 0, 0, time.UTC)))
+			Expect(window.NextOpen(time.Date(2023, 1, 2, 2, 0, 0, 0, time.UTC))).To(Equal(time.Date(2023, 1, 2, 23, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should reject malformed start times", func() {
+			for _, start := range []string{"", "2:00", "24:00", "12:60", "12-00"} {
+				_, err := (&MaintenanceWindow{Start: start, Duration: window.Duration}).NextOpen(base)
+				Expect(err).To(HaveOccurred(), start)
+			}
+		})
+	})
+})
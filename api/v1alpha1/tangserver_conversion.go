@@ -0,0 +1,308 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/openshift/nbde-tang-server/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConvertTo converts this TangServer to the Hub version (v1beta1)
+func (src *TangServer) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.TangServer)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub type %T", dstRaw)
+	}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Replicas = src.Spec.Replicas
+	dst.Spec.Storage = v1beta1.StorageSpec{
+		KeyPath:               src.Spec.KeyPath,
+		PersistentVolumeClaim: src.Spec.PersistentVolumeClaim,
+	}
+	dst.Spec.Exposure = v1beta1.ExposureSpec{
+		ServiceType:       src.Spec.ServiceType,
+		ClusterIP:         src.Spec.ClusterIP,
+		ServiceListenPort: src.Spec.ServiceListenPort,
+		PodListenPort:     src.Spec.PodListenPort,
+		NodePort:          src.Spec.NodePort,
+	}
+	dst.Spec.Workload = v1beta1.WorkloadSpec{
+		Image:        src.Spec.Image,
+		Version:      src.Spec.Version,
+		Secret:       src.Spec.Secret,
+		HealthScript: src.Spec.HealthScript,
+		Resources: v1beta1.WorkloadResources{
+			Requests: v1beta1.ResourceValues{Cpu: src.Spec.ResourcesRequest.Cpu, Memory: src.Spec.ResourcesRequest.Memory},
+			Limits:   v1beta1.ResourceValues{Cpu: src.Spec.ResourcesLimit.Cpu, Memory: src.Spec.ResourcesLimit.Memory},
+		},
+	}
+	dst.Spec.KeyPolicy = v1beta1.KeyPolicySpec{
+		HiddenKeys:             hiddenKeysToHub(src.Spec.HiddenKeys),
+		RequiredActiveKeyPairs: src.Spec.RequiredActiveKeyPairs,
+		KeyRefreshInterval:     src.Spec.KeyRefreshInterval,
+		Rotation:               keyRotationToHub(src.Spec.KeyRotation),
+		HiddenKeyRetention:     src.Spec.HiddenKeyRetention.DeepCopy(),
+		MinHiddenKeyPairs:      src.Spec.MinHiddenKeyPairs,
+		RepairDivergedReplicas: src.Spec.RepairDivergedReplicas,
+	}
+
+	setHiddenKeysEmpty(&dst.ObjectMeta, src.Spec.HiddenKeys != nil && len(src.Spec.HiddenKeys) == 0)
+
+	dst.Status = v1beta1.TangServerStatus{
+		TangServerError:    v1beta1.TangServerStatusError(src.Status.TangServerError),
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         copyConditions(src.Status.Conditions),
+		KeyPairs:           keyPairsToHub(src.Status.KeyPairs),
+		HiddenKeys:         hiddenKeysToHub(src.Status.HiddenKeys),
+		ReplicaKeys:        replicaKeysToHub(src.Status.ReplicaKeys),
+		Pods:               podsToHub(src.Status.Pods),
+		NextKeyRotation:    src.Status.NextKeyRotation.DeepCopy(),
+		Running:            src.Status.Running,
+		Ready:              src.Status.Ready,
+		Selector:           src.Status.Selector,
+		ServiceExternalURL: src.Status.ServiceExternalURL,
+	}
+	for _, k := range src.Status.ActiveKeys {
+		dst.Status.ActiveKeys = append(dst.Status.ActiveKeys, v1beta1.TangServerActiveKeys(k))
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version
+func (dst *TangServer) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.TangServer)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub type %T", srcRaw)
+	}
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec = TangServerSpec{
+		Replicas:               src.Spec.Replicas,
+		KeyPath:                src.Spec.Storage.KeyPath,
+		PersistentVolumeClaim:  src.Spec.Storage.PersistentVolumeClaim,
+		ServiceType:            src.Spec.Exposure.ServiceType,
+		ClusterIP:              src.Spec.Exposure.ClusterIP,
+		ServiceListenPort:      src.Spec.Exposure.ServiceListenPort,
+		PodListenPort:          src.Spec.Exposure.PodListenPort,
+		NodePort:               src.Spec.Exposure.NodePort,
+		Image:                  src.Spec.Workload.Image,
+		Version:                src.Spec.Workload.Version,
+		Secret:                 src.Spec.Workload.Secret,
+		HealthScript:           src.Spec.Workload.HealthScript,
+		ResourcesRequest:       ResourcesRequest{Cpu: src.Spec.Workload.Resources.Requests.Cpu, Memory: src.Spec.Workload.Resources.Requests.Memory},
+		ResourcesLimit:         ResourcesLimit{Cpu: src.Spec.Workload.Resources.Limits.Cpu, Memory: src.Spec.Workload.Resources.Limits.Memory},
+		HiddenKeys:             hiddenKeysFromHub(src.Spec.KeyPolicy.HiddenKeys),
+		RequiredActiveKeyPairs: src.Spec.KeyPolicy.RequiredActiveKeyPairs,
+		KeyRefreshInterval:     src.Spec.KeyPolicy.KeyRefreshInterval,
+		KeyRotation:            keyRotationFromHub(src.Spec.KeyPolicy.Rotation),
+		HiddenKeyRetention:     src.Spec.KeyPolicy.HiddenKeyRetention.DeepCopy(),
+		MinHiddenKeyPairs:      src.Spec.KeyPolicy.MinHiddenKeyPairs,
+		RepairDivergedReplicas: src.Spec.KeyPolicy.RepairDivergedReplicas,
+	}
+
+	if dst.Annotations[HiddenKeysEmptyAnnotation] == "true" && len(dst.Spec.HiddenKeys) == 0 {
+		dst.Spec.HiddenKeys = []TangServerHiddenKeys{}
+	}
+	setHiddenKeysEmpty(&dst.ObjectMeta, false)
+
+	dst.Status = TangServerStatus{
+		TangServerError:    TangServerStatusError(src.Status.TangServerError),
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Conditions:         copyConditions(src.Status.Conditions),
+		KeyPairs:           keyPairsFromHub(src.Status.KeyPairs),
+		HiddenKeys:         hiddenKeysFromHub(src.Status.HiddenKeys),
+		ReplicaKeys:        replicaKeysFromHub(src.Status.ReplicaKeys),
+		Pods:               podsFromHub(src.Status.Pods),
+		NextKeyRotation:    src.Status.NextKeyRotation.DeepCopy(),
+		Running:            src.Status.Running,
+		Ready:              src.Status.Ready,
+		Selector:           src.Status.Selector,
+		ServiceExternalURL: src.Status.ServiceExternalURL,
+	}
+	for _, k := range src.Status.ActiveKeys {
+		dst.Status.ActiveKeys = append(dst.Status.ActiveKeys, TangServerActiveKeys(k))
+	}
+	return nil
+}
+
+// hiddenKeysToHub converts a list of hidden keys to v1beta1
+func hiddenKeysToHub(keys []TangServerHiddenKeys) []v1beta1.TangServerHiddenKeys {
+	if keys == nil {
+		return nil
+	}
+	hubKeys := make([]v1beta1.TangServerHiddenKeys, 0, len(keys))
+	for _, k := range keys {
+		hubKeys = append(hubKeys, v1beta1.TangServerHiddenKeys(k))
+	}
+	return hubKeys
+}
+
+// setHiddenKeysEmpty sets or removes the annotation recording an empty spec.hiddenKeys, which tells apart an empty
+// list from an unset one once stored, as the previous operator versions deleted all hidden keys for an empty list
+func setHiddenKeysEmpty(meta *metav1.ObjectMeta, empty bool) {
+	if empty {
+		if meta.Annotations == nil {
+			meta.Annotations = map[string]string{}
+		}
+		meta.Annotations[HiddenKeysEmptyAnnotation] = "true"
+		return
+	}
+	delete(meta.Annotations, HiddenKeysEmptyAnnotation)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+}
+
+// hiddenKeysFromHub converts a list of v1beta1 hidden keys to this version
+func hiddenKeysFromHub(hubKeys []v1beta1.TangServerHiddenKeys) []TangServerHiddenKeys {
+	if hubKeys == nil {
+		return nil
+	}
+	keys := make([]TangServerHiddenKeys, 0, len(hubKeys))
+	for _, k := range hubKeys {
+		keys = append(keys, TangServerHiddenKeys(k))
+	}
+	return keys
+}
+
+// keyRotationToHub converts a key rotation policy to v1beta1
+func keyRotationToHub(policy *KeyRotationPolicy) *v1beta1.KeyRotationPolicy {
+	if policy == nil {
+		return nil
+	}
+	hubPolicy := &v1beta1.KeyRotationPolicy{MaxKeyAge: policy.MaxKeyAge, Schedule: policy.Schedule}
+	if policy.MaintenanceWindow != nil {
+		window := v1beta1.MaintenanceWindow(*policy.MaintenanceWindow)
+		hubPolicy.MaintenanceWindow = &window
+	}
+	return hubPolicy
+}
+
+// keyRotationFromHub converts a v1beta1 key rotation policy to this version
+func keyRotationFromHub(hubPolicy *v1beta1.KeyRotationPolicy) *KeyRotationPolicy {
+	if hubPolicy == nil {
+		return nil
+	}
+	policy := &KeyRotationPolicy{MaxKeyAge: hubPolicy.MaxKeyAge, Schedule: hubPolicy.Schedule}
+	if hubPolicy.MaintenanceWindow != nil {
+		window := MaintenanceWindow(*hubPolicy.MaintenanceWindow)
+		policy.MaintenanceWindow = &window
+	}
+	return policy
+}
+
+// keyPairsToHub converts a list of key pairs to v1beta1
+func keyPairsToHub(pairs []TangServerKeyPair) []v1beta1.TangServerKeyPair {
+	if pairs == nil {
+		return nil
+	}
+	hubPairs := make([]v1beta1.TangServerKeyPair, 0, len(pairs))
+	for _, p := range pairs {
+		hubPairs = append(hubPairs, v1beta1.TangServerKeyPair{
+			State:     p.State,
+			Signing:   v1beta1.TangServerKey(p.Signing),
+			Exchange:  v1beta1.TangServerKey(p.Exchange),
+			Generated: p.Generated.DeepCopy(),
+			Hidden:    p.Hidden.DeepCopy(),
+			Purge:     p.Purge.DeepCopy(),
+		})
+	}
+	return hubPairs
+}
+
+// keyPairsFromHub converts a list of v1beta1 key pairs to this version
+func keyPairsFromHub(hubPairs []v1beta1.TangServerKeyPair) []TangServerKeyPair {
+	if hubPairs == nil {
+		return nil
+	}
+	pairs := make([]TangServerKeyPair, 0, len(hubPairs))
+	for _, p := range hubPairs {
+		pairs = append(pairs, TangServerKeyPair{
+			State:     p.State,
+			Signing:   TangServerKey(p.Signing),
+			Exchange:  TangServerKey(p.Exchange),
+			Generated: p.Generated.DeepCopy(),
+			Hidden:    p.Hidden.DeepCopy(),
+			Purge:     p.Purge.DeepCopy(),
+		})
+	}
+	return pairs
+}
+
+// replicaKeysToHub converts a list of replica keys to v1beta1
+func replicaKeysToHub(replicas []TangServerReplicaKeys) []v1beta1.TangServerReplicaKeys {
+	if replicas == nil {
+		return nil
+	}
+	hubReplicas := make([]v1beta1.TangServerReplicaKeys, 0, len(replicas))
+	for _, r := range replicas {
+		hubReplicas = append(hubReplicas, v1beta1.TangServerReplicaKeys(*r.DeepCopy()))
+	}
+	return hubReplicas
+}
+
+// replicaKeysFromHub converts a list of v1beta1 replica keys to this version
+func replicaKeysFromHub(hubReplicas []v1beta1.TangServerReplicaKeys) []TangServerReplicaKeys {
+	if hubReplicas == nil {
+		return nil
+	}
+	replicas := make([]TangServerReplicaKeys, 0, len(hubReplicas))
+	for _, r := range hubReplicas {
+		replicas = append(replicas, TangServerReplicaKeys(*r.DeepCopy()))
+	}
+	return replicas
+}
+
+// podsToHub converts a list of pod statuses to v1beta1
+func podsToHub(pods []TangServerPodStatus) []v1beta1.TangServerPodStatus {
+	if pods == nil {
+		return nil
+	}
+	hubPods := make([]v1beta1.TangServerPodStatus, 0, len(pods))
+	for _, p := range pods {
+		hubPods = append(hubPods, v1beta1.TangServerPodStatus(*p.DeepCopy()))
+	}
+	return hubPods
+}
+
+// podsFromHub converts a list of v1beta1 pod statuses to this version
+func podsFromHub(hubPods []v1beta1.TangServerPodStatus) []TangServerPodStatus {
+	if hubPods == nil {
+		return nil
+	}
+	pods := make([]TangServerPodStatus, 0, len(hubPods))
+	for _, p := range hubPods {
+		pods = append(pods, TangServerPodStatus(*p.DeepCopy()))
+	}
+	return pods
+}
+
+// copyConditions returns a deep copy of the conditions provided
+func copyConditions(conditions []metav1.Condition) []metav1.Condition {
+	if conditions == nil {
+		return nil
+	}
+	copied := make([]metav1.Condition, len(conditions))
+	for i := range conditions {
+		conditions[i].DeepCopyInto(&copied[i])
+	}
+	return copied
+}
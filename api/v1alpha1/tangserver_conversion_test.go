@@ -0,0 +1,178 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/openshift/nbde-tang-server/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("TangServer Conversion", func() {
+	var tangServer *TangServer
+
+	BeforeEach(func() {
+		tangServer = &TangServer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "test-tang-conversion",
+				Namespace:  "default",
+				Generation: 2,
+				Labels:     map[string]string{"app": "tang"},
+			},
+			Spec: TangServerSpec{
+				Replicas:               3,
+				KeyPath:                "/var/db/tang",
+				PersistentVolumeClaim:  "tang-pvc",
+				Image:                  "registry.redhat.io/rhel9/tang",
+				Version:                "latest",
+				HealthScript:           "/usr/bin/tangd-health-check",
+				PodListenPort:          8080,
+				Secret:                 "tang-secret",
+				ServiceListenPort:      7500,
+				ResourcesRequest:       ResourcesRequest{Cpu: "50m", Memory: "32Mi"},
+				ResourcesLimit:         ResourcesLimit{Cpu: "1", Memory: "1Gi"},
+				KeyRefreshInterval:     30,
+				HiddenKeys:             []TangServerHiddenKeys{{Sha1: validSha1, Hold: true}, {Sha256: validSha256, Action: HiddenKeyActionDelete, ConfirmDelete: validSha256}},
+				HiddenKeyRetention:     &metav1.Duration{Duration: 365 * 24 * time.Hour},
+				MinHiddenKeyPairs:      2,
+				RequiredActiveKeyPairs: 2,
+				RepairDivergedReplicas: true,
+				KeyRotation: &KeyRotationPolicy{
+					MaxKeyAge:         metav1.Duration{Duration: 90 * 24 * time.Hour},
+					Schedule:          "0 2 * * 0",
+					MaintenanceWindow: &MaintenanceWindow{Start: "01:00", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+				},
+				ServiceType: "NodePort",
+				ClusterIP:   "172.30.0.10",
+				NodePort:    30080,
+			},
+			Status: TangServerStatus{
+				TangServerError:    NoError,
+				ObservedGeneration: 2,
+				Conditions: []metav1.Condition{{
+					Type:   ConditionAvailable,
+					Status: metav1.ConditionTrue,
+					Reason: ReasonDeploymentReady,
+				}},
+				KeyPairs: []TangServerKeyPair{{
+					State:     KeyPairStateHidden,
+					Signing:   TangServerKey{Sha1: validSha1, Sha256: validSha256, Alg: "ES512", FileName: ".key.jwk"},
+					Exchange:  TangServerKey{Sha1: validSha1, Sha256: validSha256, Alg: "ECMR", FileName: ".exc.jwk"},
+					Generated: &metav1.Time{Time: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+					Hidden:    &metav1.Time{Time: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+					Purge:     &metav1.Time{Time: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+				}},
+				ActiveKeys: []TangServerActiveKeys{{Sha1: validSha1, Sha256: validSha256, FileName: "key.jwk"}},
+				HiddenKeys: []TangServerHiddenKeys{{Sha1: validSha1, Hidden: "now", FileName: ".key.jwk"}},
+				ReplicaKeys: []TangServerReplicaKeys{
+					{PodName: "tang-0", Authoritative: true, ActiveKeys: []string{validSha256}},
+					{PodName: "tang-1", MissingKeyFiles: []string{"key.jwk"}, UnexpectedKeyFiles: []string{"old.jwk"}},
+				},
+				Pods: []TangServerPodStatus{{
+					Name: "tang-0", NodeName: "node-0", Phase: "Running", Ready: true, Restarts: 1,
+					ImageID: "quay.io/tang/tang@sha256:0123", ServedKeys: []string{validSha256},
+				}},
+				NextKeyRotation:    &metav1.Time{Time: time.Date(2023, 9, 3, 2, 0, 0, 0, time.UTC)},
+				Running:            3,
+				Ready:              3,
+				Selector:           "app=test-tang-conversion",
+				ServiceExternalURL: "http://tang:7500/adv",
+			},
+		}
+	})
+
+	Context("When converting to v1beta1", func() {
+		It("Should place fields in their sections", func() {
+			hub := &v1beta1.TangServer{}
+			Expect(tangServer.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Name).To(Equal(tangServer.Name))
+			Expect(hub.Spec.Replicas).To(Equal(int32(3)))
+			Expect(hub.Spec.Storage.KeyPath).To(Equal("/var/db/tang"))
+			Expect(hub.Spec.Storage.PersistentVolumeClaim).To(Equal("tang-pvc"))
+			Expect(hub.Spec.Exposure.ServiceType).To(Equal("NodePort"))
+			Expect(hub.Spec.Exposure.NodePort).To(Equal(int32(30080)))
+			Expect(hub.Spec.Workload.Image).To(Equal("registry.redhat.io/rhel9/tang"))
+			Expect(hub.Spec.Workload.Resources.Limits.Memory).To(Equal("1Gi"))
+			Expect(hub.Spec.KeyPolicy.RequiredActiveKeyPairs).To(Equal(uint32(2)))
+			Expect(hub.Spec.KeyPolicy.HiddenKeys).To(HaveLen(2))
+			Expect(hub.Spec.KeyPolicy.Rotation.MaintenanceWindow.Start).To(Equal("01:00"))
+			Expect(hub.Spec.KeyPolicy.RepairDivergedReplicas).To(BeTrue())
+			Expect(hub.Status.Conditions).To(HaveLen(1))
+			Expect(hub.Status.ActiveKeys[0].FileName).To(Equal("key.jwk"))
+			Expect(hub.Status.KeyPairs[0].Exchange.Alg).To(Equal("ECMR"))
+		})
+	})
+
+	Context("When converting back and forth", func() {
+		It("Should round-trip from v1alpha1", func() {
+			hub := &v1beta1.TangServer{}
+			Expect(tangServer.ConvertTo(hub)).To(Succeed())
+			converted := &TangServer{}
+			Expect(converted.ConvertFrom(hub)).To(Succeed())
+			Expect(converted).To(Equal(tangServer))
+		})
+
+		It("Should round-trip an empty TangServer", func() {
+			empty := &TangServer{}
+			hub := &v1beta1.TangServer{}
+			Expect(empty.ConvertTo(hub)).To(Succeed())
+			converted := &TangServer{}
+			Expect(converted.ConvertFrom(hub)).To(Succeed())
+			Expect(converted).To(Equal(empty))
+		})
+
+		It("Should keep an empty hiddenKeys list once stored as v1beta1", func() {
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{}
+			hub := &v1beta1.TangServer{}
+			Expect(tangServer.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Annotations).To(HaveKeyWithValue(HiddenKeysEmptyAnnotation, "true"))
+			// The empty list is dropped when the object is encoded for storage
+			data, err := json.Marshal(hub)
+			Expect(err).ToNot(HaveOccurred())
+			stored := &v1beta1.TangServer{}
+			Expect(json.Unmarshal(data, stored)).To(Succeed())
+			Expect(stored.Spec.KeyPolicy.HiddenKeys).To(BeNil())
+			converted := &TangServer{}
+			Expect(converted.ConvertFrom(stored)).To(Succeed())
+			Expect(converted.Spec.HiddenKeys).ToNot(BeNil())
+			Expect(converted.Spec.HiddenKeys).To(BeEmpty())
+			Expect(converted.Annotations).ToNot(HaveKey(HiddenKeysEmptyAnnotation))
+
+			// Unsetting or listing hidden keys removes the annotation
+			converted.Spec.HiddenKeys = nil
+			Expect(converted.ConvertTo(hub)).To(Succeed())
+			Expect(hub.Annotations).ToNot(HaveKey(HiddenKeysEmptyAnnotation))
+			unset := &TangServer{}
+			Expect(unset.ConvertFrom(hub)).To(Succeed())
+			Expect(unset.Spec.HiddenKeys).To(BeNil())
+		})
+
+		It("Should round-trip from v1beta1", func() {
+			hub := &v1beta1.TangServer{}
+			Expect(tangServer.ConvertTo(hub)).To(Succeed())
+			spoke := &TangServer{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+			converted := &v1beta1.TangServer{}
+			Expect(spoke.ConvertTo(converted)).To(Succeed())
+			Expect(converted).To(Equal(hub))
+		})
+	})
+})
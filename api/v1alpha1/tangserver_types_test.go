@@ -116,6 +116,23 @@ var _ = Describe("TangServer Types", func() {
 			Expect(emptyStatus.Ready).To(Equal(int32(0)))
 			Expect(emptyStatus.Running).To(Equal(int32(0)))
 			Expect(emptyStatus.ServiceExternalURL).To(Equal(""))
+			Expect(emptyStatus.Conditions).To(BeEmpty())
+			Expect(emptyStatus.ObservedGeneration).To(Equal(int64(0)))
+		})
+
+		It("should deep copy conditions", func() {
+			status.ObservedGeneration = 2
+			status.Conditions = []metav1.Condition{
+				{
+					Type:   ConditionAvailable,
+					Status: metav1.ConditionTrue,
+					Reason: ReasonDeploymentReady,
+				},
+			}
+			copied := status.DeepCopy()
+			copied.Conditions[0].Status = metav1.ConditionFalse
+			Expect(status.Conditions[0].Status).To(Equal(metav1.ConditionTrue))
+			Expect(copied.ObservedGeneration).To(Equal(int64(2)))
 		})
 	})
 
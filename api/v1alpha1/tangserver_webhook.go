@@ -0,0 +1,372 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var tangserverlog = logf.Log.WithName("tangserver-resource")
+
+// Thumbprints are reported by jose (and tang) base64url encoded, without padding.
+// SHA1 thumbprints are 20 bytes long (27 characters) and SHA256 ones are 32 bytes long (43 characters)
+var (
+	sha1ThumbprintRegexp   = regexp.MustCompile(`^[A-Za-z0-9_-]{27}$`)
+	sha256ThumbprintRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+	keyPathRegexp          = regexp.MustCompile(KeyPathPattern)
+)
+
+// supportedServiceTypes contains the service types that can be specified in the spec
+var supportedServiceTypes = []string{
+	string(corev1.ServiceTypeClusterIP),
+	string(corev1.ServiceTypeNodePort),
+	string(corev1.ServiceTypeLoadBalancer),
+	string(corev1.ServiceTypeExternalName),
+}
+
+// supportedHiddenKeyActions contains the actions that can be requested for the hidden keys in the spec
+var supportedHiddenKeyActions = []string{HiddenKeyActionHide, HiddenKeyActionKeepHidden, HiddenKeyActionDelete}
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks for TangServer
+func (r *TangServer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, r).
+		WithDefaulter(&TangServerCustomDefaulter{}).
+		WithValidator(&TangServerCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-nbde-openshift-io-v1alpha1-tangserver,mutating=true,failurePolicy=fail,sideEffects=None,groups=nbde.openshift.io,resources=tangservers,verbs=create;update,versions=v1alpha1,name=mtangserver-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// TangServerCustomDefaulter sets default values on TangServer objects when they are created or updated
+// +kubebuilder:object:generate=false
+type TangServerCustomDefaulter struct{}
+
+// Default implements admission.Defaulter
+func (d *TangServerCustomDefaulter) Default(_ context.Context, r *TangServer) error {
+	tangserverlog.Info("default", "name", r.Name)
+	r.Spec.Default()
+	return nil
+}
+
+// Default fills the spec fields that are empty with the values the controller would use
+func (s *TangServerSpec) Default() {
+	if s.Image == "" {
+		s.Image = DefaultImage
+	}
+	if s.Version == "" {
+		s.Version = DefaultVersion
+	}
+	if s.PodListenPort == 0 {
+		s.PodListenPort = DefaultPodListenPort
+	}
+	if s.ServiceListenPort == 0 {
+		s.ServiceListenPort = DefaultServiceListenPort
+	}
+	if s.KeyPath == "" {
+		s.KeyPath = DefaultKeyPath
+	}
+	if s.PersistentVolumeClaim == "" {
+		s.PersistentVolumeClaim = DefaultPersistentVolumeClaim
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-nbde-openshift-io-v1alpha1-tangserver,mutating=false,failurePolicy=fail,sideEffects=None,groups=nbde.openshift.io,resources=tangservers,verbs=create;update,versions=v1alpha1,name=vtangserver-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// TangServerCustomValidator validates TangServer objects when they are created or updated.
+// When Client is set, TangServers are also checked against the rest of TangServers in the cluster
+// +kubebuilder:object:generate=false
+type TangServerCustomValidator struct {
+	Client client.Reader
+}
+
+// ValidateCreate implements admission.Validator
+func (v *TangServerCustomValidator) ValidateCreate(ctx context.Context, r *TangServer) (admission.Warnings, error) {
+	tangserverlog.Info("validate create", "name", r.Name)
+	return v.validate(ctx, nil, r)
+}
+
+// ValidateUpdate implements admission.Validator
+func (v *TangServerCustomValidator) ValidateUpdate(ctx context.Context, old *TangServer, r *TangServer) (admission.Warnings, error) {
+	tangserverlog.Info("validate update", "name", r.Name)
+	warnings, err := v.validate(ctx, old, r)
+	return append(updateWarnings(old, r), warnings...), err
+}
+
+// ValidateDelete implements admission.Validator
+func (v *TangServerCustomValidator) ValidateDelete(_ context.Context, r *TangServer) (admission.Warnings, error) {
+	tangserverlog.Info("validate delete", "name", r.Name)
+	return nil, nil
+}
+
+// validate returns an Invalid error with all the problems found in the spec, or nil if spec is correct.
+// On update, old is the TangServer being replaced, nil on creation
+func (v *TangServerCustomValidator) validate(ctx context.Context, old *TangServer, r *TangServer) (admission.Warnings, error) {
+	specPath := field.NewPath("spec")
+	errs := r.Spec.validate(specPath)
+	var warnings admission.Warnings
+	if v.Client != nil {
+		conflicts, conflictWarnings, err := v.validateConflicts(ctx, old, r, specPath)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		errs = append(errs, conflicts...)
+		warnings = conflictWarnings
+	}
+	if len(errs) == 0 {
+		return warnings, nil
+	}
+	return warnings, apierrors.NewInvalid(GroupVersion.WithKind("TangServer").GroupKind(), r.Name, errs)
+}
+
+// validateConflicts checks the TangServer does not share resources with other TangServers:
+// the PVC (keys are stored on its root, so sharing it means sharing the key directory and
+// key_status.txt), the ClusterIP and the NodePort (both are allocated cluster wide).
+// On update, conflicts on fields whose defaulted value is unchanged already existed, so they are
+// only reported as warnings and do not block updates of TangServers created before this check
+func (v *TangServerCustomValidator) validateConflicts(ctx context.Context, old *TangServer, r *TangServer, path *field.Path) (field.ErrorList, admission.Warnings, error) {
+	tangServers := &TangServerList{}
+	if err := v.Client.List(ctx, tangServers); err != nil {
+		return nil, nil, err
+	}
+	spec := r.Spec.DeepCopy()
+	spec.Default()
+	var oldSpec *TangServerSpec
+	if old != nil {
+		oldSpec = old.Spec.DeepCopy()
+		oldSpec.Default()
+	}
+	var errs field.ErrorList
+	var warnings admission.Warnings
+	conflict := func(fieldPath *field.Path, value interface{}, unchanged bool, detail string) {
+		if unchanged {
+			warnings = append(warnings, fmt.Sprintf("%s: %v %s", fieldPath, value, detail))
+			return
+		}
+		errs = append(errs, field.Invalid(fieldPath, value, detail))
+	}
+	for i := range tangServers.Items {
+		other := &tangServers.Items[i]
+		if other.Namespace == r.Namespace && other.Name == r.Name {
+			continue
+		}
+		otherSpec := other.Spec.DeepCopy()
+		otherSpec.Default()
+		otherName := other.Namespace + "/" + other.Name
+		if other.Namespace == r.Namespace && otherSpec.PersistentVolumeClaim == spec.PersistentVolumeClaim {
+			conflict(path.Child("persistentVolumeClaim"), spec.PersistentVolumeClaim,
+				oldSpec != nil && oldSpec.PersistentVolumeClaim == spec.PersistentVolumeClaim,
+				fmt.Sprintf("already used by TangServer %s, keys would be shared in the same key directory", otherName))
+		}
+		if spec.ClusterIP != "" && spec.ClusterIP != corev1.ClusterIPNone && otherSpec.ClusterIP == spec.ClusterIP {
+			conflict(path.Child("clusterIP"), spec.ClusterIP, oldSpec != nil && oldSpec.ClusterIP == spec.ClusterIP,
+				fmt.Sprintf("already used by TangServer %s", otherName))
+		}
+		if spec.NodePort != 0 && otherSpec.NodePort == spec.NodePort {
+			conflict(path.Child("nodePort"), spec.NodePort, oldSpec != nil && oldSpec.NodePort == spec.NodePort,
+				fmt.Sprintf("already used by TangServer %s", otherName))
+		}
+	}
+	return errs, warnings, nil
+}
+
+// updateWarnings returns warnings for changes on fields that can not be changed safely once keys exist
+func updateWarnings(old *TangServer, r *TangServer) admission.Warnings {
+	oldSpec := old.Spec.DeepCopy()
+	oldSpec.Default()
+	spec := r.Spec.DeepCopy()
+	spec.Default()
+	var warnings admission.Warnings
+	if oldSpec.KeyPath != spec.KeyPath {
+		warnings = append(warnings, fmt.Sprintf("spec.keyPath changed from %s to %s: "+
+			"existing keys will not be moved, and clients bound to them may fail to decrypt", oldSpec.KeyPath, spec.KeyPath))
+	}
+	if oldSpec.PersistentVolumeClaim != spec.PersistentVolumeClaim {
+		warnings = append(warnings, fmt.Sprintf("spec.persistentVolumeClaim changed from %s to %s: "+
+			"existing keys will not be available, and clients bound to them may fail to decrypt",
+			oldSpec.PersistentVolumeClaim, spec.PersistentVolumeClaim))
+	}
+	return warnings
+}
+
+// validate checks the spec fields that are silently ignored by the controller when wrong
+func (s *TangServerSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateQuantity(path.Child("resourcesRequest", "cpu"), s.ResourcesRequest.Cpu)...)
+	errs = append(errs, validateQuantity(path.Child("resourcesRequest", "memory"), s.ResourcesRequest.Memory)...)
+	errs = append(errs, validateQuantity(path.Child("resourcesLimit", "cpu"), s.ResourcesLimit.Cpu)...)
+	errs = append(errs, validateQuantity(path.Child("resourcesLimit", "memory"), s.ResourcesLimit.Memory)...)
+	errs = append(errs, validatePort(path.Child("podListenPort"), s.PodListenPort)...)
+	errs = append(errs, validatePort(path.Child("serviceListenPort"), s.ServiceListenPort)...)
+	errs = append(errs, validatePort(path.Child("nodePort"), s.NodePort)...)
+	if s.NodePort != 0 && (s.ServiceType == string(corev1.ServiceTypeClusterIP) || s.ServiceType == string(corev1.ServiceTypeExternalName)) {
+		errs = append(errs, field.Invalid(path.Child("nodePort"), s.NodePort, "can only be set for NodePort and LoadBalancer service types"))
+	}
+	if s.ClusterIP != "" && s.ServiceType != string(corev1.ServiceTypeClusterIP) {
+		errs = append(errs, field.Invalid(path.Child("clusterIP"), s.ClusterIP, "can only be set for ClusterIP service type"))
+	}
+	errs = append(errs, validateKeyPath(path.Child("keyPath"), s.KeyPath)...)
+	if s.ServiceType != "" && !containsString(supportedServiceTypes, s.ServiceType) {
+		errs = append(errs, field.NotSupported(path.Child("serviceType"), s.ServiceType, supportedServiceTypes))
+	}
+	for i, hk := range s.HiddenKeys {
+		errs = append(errs, validateThumbprints(path.Child("hiddenKeys").Index(i), hk.Sha1, hk.Sha256)...)
+		errs = append(errs, validateHiddenKeyAction(path.Child("hiddenKeys").Index(i), hk)...)
+	}
+	if s.HiddenKeyRetention != nil && s.HiddenKeyRetention.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("hiddenKeyRetention"), s.HiddenKeyRetention.Duration.String(), "must be greater than zero"))
+	}
+	if s.KeyRotation != nil {
+		errs = append(errs, validateKeyRotation(path.Child("keyRotation"), s.KeyRotation)...)
+	}
+	return errs
+}
+
+// validateQuantity checks an optional resource quantity can be parsed and is not negative
+func validateQuantity(path *field.Path, value string) field.ErrorList {
+	if value == "" {
+		return nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, "must be a valid quantity, e.g. 100m or 128Mi")}
+	}
+	if q.Sign() < 0 {
+		return field.ErrorList{field.Invalid(path, value, "must not be negative")}
+	}
+	return nil
+}
+
+// validateKeyPath checks an optional key path is absolute, only contains safe characters and does not escape its directory
+func validateKeyPath(path *field.Path, keyPath string) field.ErrorList {
+	if keyPath == "" {
+		return nil
+	}
+	var errs field.ErrorList
+	if !strings.HasPrefix(keyPath, "/") {
+		errs = append(errs, field.Invalid(path, keyPath, "must be an absolute path"))
+	} else if !keyPathRegexp.MatchString(keyPath) {
+		errs = append(errs, field.Invalid(path, keyPath,
+			fmt.Sprintf("must only contain letters, digits, '.', '_', '-' and '/' (%s)", KeyPathPattern)))
+	}
+	for _, elem := range strings.Split(keyPath, "/") {
+		if elem == ".." {
+			errs = append(errs, field.Invalid(path, keyPath, "must not contain '..'"))
+			break
+		}
+	}
+	return errs
+}
+
+// validatePort checks an optional port is in the valid TCP port range
+func validatePort(path *field.Path, port int32) field.ErrorList {
+	if port == 0 {
+		return nil
+	}
+	if port < 1 || port > 65535 {
+		return field.ErrorList{field.Invalid(path, port, "must be between 1 and 65535")}
+	}
+	return nil
+}
+
+// validateThumbprints checks at least one thumbprint is provided and the provided ones are well formed, without
+// surrounding spaces, as thumbprints are compared as provided
+func validateThumbprints(path *field.Path, sha1 string, sha256 string) field.ErrorList {
+	var errs field.ErrorList
+	if sha1 == "" && sha256 == "" {
+		return append(errs, field.Required(path, "either sha1 or sha256 thumbprint must be specified"))
+	}
+	if sha1 != "" && !sha1ThumbprintRegexp.MatchString(sha1) {
+		errs = append(errs, field.Invalid(path.Child("sha1"), sha1,
+			fmt.Sprintf("must be a base64url encoded SHA1 thumbprint (%s)", sha1ThumbprintRegexp.String())))
+	}
+	if sha256 != "" && !sha256ThumbprintRegexp.MatchString(sha256) {
+		errs = append(errs, field.Invalid(path.Child("sha256"), sha256,
+			fmt.Sprintf("must be a base64url encoded SHA256 thumbprint (%s)", sha256ThumbprintRegexp.String())))
+	}
+	return errs
+}
+
+// validateHiddenKeyAction checks the action requested for a hidden key is supported, and that deletions are
+// confirmed with the thumbprint of the key and do not conflict with a hold
+func validateHiddenKeyAction(path *field.Path, hk TangServerHiddenKeys) field.ErrorList {
+	var errs field.ErrorList
+	if hk.Action != "" && !containsString(supportedHiddenKeyActions, hk.Action) {
+		errs = append(errs, field.NotSupported(path.Child("action"), hk.Action, supportedHiddenKeyActions))
+	}
+	if hk.Action != HiddenKeyActionDelete {
+		if hk.ConfirmDelete != "" {
+			errs = append(errs, field.Invalid(path.Child("confirmDelete"), hk.ConfirmDelete, "can only be set for the Delete action"))
+		}
+		return errs
+	}
+	// Compared as provided, as the CRD validation rule does
+	if hk.ConfirmDelete == "" || (hk.ConfirmDelete != hk.Sha1 && hk.ConfirmDelete != hk.Sha256) {
+		errs = append(errs, field.Invalid(path.Child("confirmDelete"), hk.ConfirmDelete,
+			"must repeat the sha1 or sha256 thumbprint of the key to delete"))
+	}
+	if hk.Hold {
+		errs = append(errs, field.Invalid(path.Child("hold"), hk.Hold, "can not be set for the Delete action"))
+	}
+	return errs
+}
+
+// validateKeyRotation checks the key rotation policy has a maximum key age, a valid cron schedule and a
+// maintenance window that opens and closes every day
+func validateKeyRotation(path *field.Path, policy *KeyRotationPolicy) field.ErrorList {
+	var errs field.ErrorList
+	if policy.MaxKeyAge.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("maxKeyAge"), policy.MaxKeyAge.Duration.String(), "must be greater than zero"))
+	}
+	if policy.Schedule != "" {
+		if _, err := ParseCronSchedule(policy.Schedule); err != nil {
+			errs = append(errs, field.Invalid(path.Child("schedule"), policy.Schedule, err.Error()))
+		}
+	}
+	if w := policy.MaintenanceWindow; w != nil {
+		if _, err := w.StartOffset(); err != nil {
+			errs = append(errs, field.Invalid(path.Child("maintenanceWindow", "start"), w.Start, err.Error()))
+		}
+		if w.Duration.Duration <= 0 || w.Duration.Duration > MaxMaintenanceWindowDuration {
+			errs = append(errs, field.Invalid(path.Child("maintenanceWindow", "duration"), w.Duration.Duration.String(),
+				fmt.Sprintf("must be greater than zero and up to %s", MaxMaintenanceWindowDuration)))
+		}
+	}
+	return errs
+}
+
+// containsString returns true if a string is found on a slice
+func containsString(haystack []string, needle string) bool {
+	for _, n := range haystack {
+		if n == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	validSha1   = "Ax0ok1Hd9pNlDpkdwxHsvZQGyB8"
+	validSha256 = "wGhU8KV5GrF5nSBhfJ6kmHnMFm6mLxFVJX2f9RagL3E"
+)
+
+var _ = Describe("TangServer Webhook", func() {
+	var tangServer *TangServer
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		tangServer = &TangServer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-tang-webhook",
+				Namespace: "default",
+			},
+		}
+	})
+
+	Context("When defaulting a TangServer", func() {
+		It("Should fill empty fields with default values", func() {
+			Expect((&TangServerCustomDefaulter{}).Default(ctx, tangServer)).To(Succeed())
+			Expect(tangServer.Spec.Image).To(Equal(DefaultImage))
+			Expect(tangServer.Spec.Version).To(Equal(DefaultVersion))
+			Expect(tangServer.Spec.PodListenPort).To(Equal(int32(DefaultPodListenPort)))
+			Expect(tangServer.Spec.ServiceListenPort).To(Equal(int32(DefaultServiceListenPort)))
+			Expect(tangServer.Spec.KeyPath).To(Equal(DefaultKeyPath))
+			Expect(tangServer.Spec.PersistentVolumeClaim).To(Equal(DefaultPersistentVolumeClaim))
+		})
+
+		It("Should not overwrite fields already set", func() {
+			tangServer.Spec.Image = "quay.io/example/tang"
+			tangServer.Spec.Version = "v1.0"
+			tangServer.Spec.PodListenPort = 9090
+			tangServer.Spec.KeyPath = "/srv/tang"
+			Expect((&TangServerCustomDefaulter{}).Default(ctx, tangServer)).To(Succeed())
+			Expect(tangServer.Spec.Image).To(Equal("quay.io/example/tang"))
+			Expect(tangServer.Spec.Version).To(Equal("v1.0"))
+			Expect(tangServer.Spec.PodListenPort).To(Equal(int32(9090)))
+			Expect(tangServer.Spec.KeyPath).To(Equal("/srv/tang"))
+		})
+	})
+
+	Context("When validating a TangServer", func() {
+		var validator *TangServerCustomValidator
+
+		BeforeEach(func() {
+			validator = &TangServerCustomValidator{}
+		})
+
+		It("Should accept an empty spec", func() {
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should accept a valid spec", func() {
+			tangServer.Spec.ResourcesRequest = ResourcesRequest{Cpu: "50m", Memory: "32Mi"}
+			tangServer.Spec.ResourcesLimit = ResourcesLimit{Cpu: "1", Memory: "1Gi"}
+			tangServer.Spec.ServiceType = "NodePort"
+			tangServer.Spec.PodListenPort = 8080
+			tangServer.Spec.ServiceListenPort = 7500
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{{Sha1: validSha1}, {Sha256: validSha256}}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should reject unparseable or negative quantities", func() {
+			tangServer.Spec.ResourcesRequest = ResourcesRequest{Cpu: "a lot"}
+			tangServer.Spec.ResourcesLimit = ResourcesLimit{Memory: "-1Gi"}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.resourcesRequest.cpu"))
+			Expect(err.Error()).To(ContainSubstring("spec.resourcesLimit.memory"))
+		})
+
+		It("Should reject unknown service types", func() {
+			tangServer.Spec.ServiceType = "Ingress"
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.serviceType"))
+		})
+
+		It("Should reject out of range ports", func() {
+			tangServer.Spec.PodListenPort = -1
+			tangServer.Spec.ServiceListenPort = 70000
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.podListenPort"))
+			Expect(err.Error()).To(ContainSubstring("spec.serviceListenPort"))
+		})
+
+		It("Should reject malformed hidden key thumbprints on update", func() {
+			old := tangServer.DeepCopy()
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{{}, {Sha1: validSha256}, {Sha256: "not+base64url"}}
+			_, err := validator.ValidateUpdate(ctx, old, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[0]"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[1].sha1"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[2].sha256"))
+		})
+
+		It("Should only accept hidden key deletions confirmed with the key thumbprint", func() {
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{
+				{Sha256: validSha256, Action: HiddenKeyActionDelete, ConfirmDelete: validSha256},
+				{Sha1: validSha1, Action: HiddenKeyActionKeepHidden},
+			}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{
+				{Sha256: validSha256, Action: HiddenKeyActionDelete},
+				{Sha1: validSha1, Action: HiddenKeyActionHide, ConfirmDelete: validSha1},
+				{Sha1: validSha1, Action: HiddenKeyActionDelete, ConfirmDelete: validSha1, Hold: true},
+				{Sha1: validSha1, Action: "Purge"},
+			}
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[0].confirmDelete"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[1].confirmDelete"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[2].hold"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[3].action"))
+		})
+
+		It("Should reject hidden key thumbprints and confirmations with surrounding spaces", func() {
+			tangServer.Spec.HiddenKeys = []TangServerHiddenKeys{
+				{Sha1: " " + validSha1},
+				{Sha256: validSha256 + "\n"},
+				{Sha256: validSha256, Action: HiddenKeyActionDelete, ConfirmDelete: validSha256 + " "},
+			}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[0].sha1"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[1].sha256"))
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeys[2].confirmDelete"))
+		})
+
+		It("Should reject hidden key retentions not greater than zero", func() {
+			tangServer.Spec.HiddenKeyRetention = &metav1.Duration{}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.hiddenKeyRetention"))
+
+			tangServer.Spec.HiddenKeyRetention = &metav1.Duration{Duration: 90 * 24 * time.Hour}
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should reject key rotation policies without age, with invalid schedules or maintenance windows", func() {
+			tangServer.Spec.KeyRotation = &KeyRotationPolicy{
+				Schedule:          "0 2 * *",
+				MaintenanceWindow: &MaintenanceWindow{Start: "25:00", Duration: metav1.Duration{Duration: 48 * time.Hour}},
+			}
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.keyRotation.maxKeyAge"))
+			Expect(err.Error()).To(ContainSubstring("spec.keyRotation.schedule"))
+			Expect(err.Error()).To(ContainSubstring("spec.keyRotation.maintenanceWindow.start"))
+			Expect(err.Error()).To(ContainSubstring("spec.keyRotation.maintenanceWindow.duration"))
+
+			tangServer.Spec.KeyRotation = &KeyRotationPolicy{
+				MaxKeyAge:         metav1.Duration{Duration: 30 * 24 * time.Hour},
+				Schedule:          "30 2 1-7 * 0",
+				MaintenanceWindow: &MaintenanceWindow{Start: "23:00", Duration: metav1.Duration{Duration: 3 * time.Hour}},
+			}
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should reject ClusterIP and NodePort with service types not using them", func() {
+			tangServer.Spec.ServiceType = "ClusterIP"
+			tangServer.Spec.NodePort = 30080
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.nodePort"))
+
+			tangServer.Spec.ServiceType = "LoadBalancer"
+			tangServer.Spec.NodePort = 0
+			tangServer.Spec.ClusterIP = "172.30.0.10"
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.clusterIP"))
+		})
+
+		It("Should reject relative key paths or key paths with ..", func() {
+			tangServer.Spec.KeyPath = "var/db/tang"
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("must be an absolute path"))
+
+			tangServer.Spec.KeyPath = "/var/db/../../etc"
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("must not contain '..'"))
+
+			tangServer.Spec.KeyPath = "/var/db/tang..keys"
+			_, err = validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should reject key paths with characters unsafe for pod commands", func() {
+			for _, keyPath := range []string{"/var/db/tang keys", "/var/db/tang;rm", "/var/db/'tang'", "/var/db/$(tang)"} {
+				tangServer.Spec.KeyPath = keyPath
+				_, err := validator.ValidateCreate(ctx, tangServer)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("must only contain letters"))
+			}
+		})
+
+		It("Should always allow deletion", func() {
+			tangServer.Spec.ServiceType = "Ingress"
+			_, err := validator.ValidateDelete(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("When validating a TangServer against other TangServers", func() {
+		var validator *TangServerCustomValidator
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(AddToScheme(scheme)).To(Succeed())
+			existing := &TangServer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "existing-tang",
+					Namespace: "default",
+				},
+				Spec: TangServerSpec{
+					ServiceType: "ClusterIP",
+					ClusterIP:   "172.30.0.10",
+				},
+			}
+			existingNodePort := &TangServer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "existing-tang-nodeport",
+					Namespace: "nodeport",
+				},
+				Spec: TangServerSpec{
+					ServiceType: "NodePort",
+					NodePort:    30080,
+				},
+			}
+			validator = &TangServerCustomValidator{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing, existingNodePort).Build(),
+			}
+		})
+
+		It("Should reject a TangServer sharing the default PVC in the same namespace", func() {
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.persistentVolumeClaim"))
+			Expect(err.Error()).To(ContainSubstring("default/existing-tang"))
+		})
+
+		It("Should accept the same PVC name in a different namespace", func() {
+			tangServer.Namespace = "other"
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should reject conflicting ClusterIP in any namespace", func() {
+			tangServer.Namespace = "other"
+			tangServer.Spec.ServiceType = "ClusterIP"
+			tangServer.Spec.ClusterIP = "172.30.0.10"
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.clusterIP"))
+			Expect(err.Error()).ToNot(ContainSubstring("spec.persistentVolumeClaim"))
+		})
+
+		It("Should reject conflicting NodePort in any namespace", func() {
+			tangServer.Namespace = "other"
+			tangServer.Spec.NodePort = 30080
+			_, err := validator.ValidateCreate(ctx, tangServer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.nodePort"))
+			Expect(err.Error()).To(ContainSubstring("nodeport/existing-tang-nodeport"))
+		})
+
+		It("Should not report conflicts with the TangServer being updated", func() {
+			existing := &TangServer{}
+			Expect(validator.Client.Get(ctx, client.ObjectKey{Name: "existing-tang", Namespace: "default"}, existing)).To(Succeed())
+			updated := existing.DeepCopy()
+			updated.Spec.Replicas = 2
+			warnings, err := validator.ValidateUpdate(ctx, existing, updated)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should only warn about conflicts that already existed on update", func() {
+			// Both TangServers default to the same PVC, as allowed before conflicts were checked
+			updated := tangServer.DeepCopy()
+			updated.Labels = map[string]string{"app.kubernetes.io/part-of": "nbde"}
+			warnings, err := validator.ValidateUpdate(ctx, tangServer, updated)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.persistentVolumeClaim"))
+			Expect(warnings[0]).To(ContainSubstring("default/existing-tang"))
+		})
+
+		It("Should reject conflicts introduced on update", func() {
+			tangServer.Namespace = "other"
+			updated := tangServer.DeepCopy()
+			updated.Spec.NodePort = 30080
+			_, err := validator.ValidateUpdate(ctx, tangServer, updated)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.nodePort"))
+		})
+
+		It("Should warn when key location changes on update", func() {
+			tangServer.Namespace = "other"
+			updated := tangServer.DeepCopy()
+			updated.Spec.KeyPath = "/srv/tang"
+			updated.Spec.PersistentVolumeClaim = "other-pvc"
+			warnings, err := validator.ValidateUpdate(ctx, tangServer, updated)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(HaveLen(2))
+			Expect(warnings[0]).To(ContainSubstring("spec.keyPath"))
+			Expect(warnings[1]).To(ContainSubstring("spec.persistentVolumeClaim"))
+		})
+	})
+})
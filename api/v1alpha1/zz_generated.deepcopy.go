@@ -21,9 +21,47 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotationPolicy) DeepCopyInto(out *KeyRotationPolicy) {
+	*out = *in
+	out.MaxKeyAge = in.MaxKeyAge
+	if in.MaintenanceWindow != nil {
+		in, out := &in.MaintenanceWindow, &out.MaintenanceWindow
+		*out = new(MaintenanceWindow)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotationPolicy.
+func (in *KeyRotationPolicy) DeepCopy() *KeyRotationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourcesLimit) DeepCopyInto(out *ResourcesLimit) {
 	*out = *in
@@ -111,6 +149,50 @@ func (in *TangServerHiddenKeys) DeepCopy() *TangServerHiddenKeys {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerKey) DeepCopyInto(out *TangServerKey) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerKey.
+func (in *TangServerKey) DeepCopy() *TangServerKey {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerKeyPair) DeepCopyInto(out *TangServerKeyPair) {
+	*out = *in
+	out.Signing = in.Signing
+	out.Exchange = in.Exchange
+	if in.Generated != nil {
+		in, out := &in.Generated, &out.Generated
+		*out = (*in).DeepCopy()
+	}
+	if in.Hidden != nil {
+		in, out := &in.Hidden, &out.Hidden
+		*out = (*in).DeepCopy()
+	}
+	if in.Purge != nil {
+		in, out := &in.Purge, &out.Purge
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerKeyPair.
+func (in *TangServerKeyPair) DeepCopy() *TangServerKeyPair {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerKeyPair)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TangServerList) DeepCopyInto(out *TangServerList) {
 	*out = *in
@@ -143,6 +225,61 @@ func (in *TangServerList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerPodStatus) DeepCopyInto(out *TangServerPodStatus) {
+	*out = *in
+	if in.ServedKeys != nil {
+		in, out := &in.ServedKeys, &out.ServedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerPodStatus.
+func (in *TangServerPodStatus) DeepCopy() *TangServerPodStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerPodStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerReplicaKeys) DeepCopyInto(out *TangServerReplicaKeys) {
+	*out = *in
+	if in.ActiveKeys != nil {
+		in, out := &in.ActiveKeys, &out.ActiveKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HiddenKeys != nil {
+		in, out := &in.HiddenKeys, &out.HiddenKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MissingKeyFiles != nil {
+		in, out := &in.MissingKeyFiles, &out.MissingKeyFiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnexpectedKeyFiles != nil {
+		in, out := &in.UnexpectedKeyFiles, &out.UnexpectedKeyFiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerReplicaKeys.
+func (in *TangServerReplicaKeys) DeepCopy() *TangServerReplicaKeys {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerReplicaKeys)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TangServerSpec) DeepCopyInto(out *TangServerSpec) {
 	*out = *in
@@ -153,6 +290,16 @@ func (in *TangServerSpec) DeepCopyInto(out *TangServerSpec) {
 		*out = make([]TangServerHiddenKeys, len(*in))
 		copy(*out, *in)
 	}
+	if in.KeyRotation != nil {
+		in, out := &in.KeyRotation, &out.KeyRotation
+		*out = new(KeyRotationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HiddenKeyRetention != nil {
+		in, out := &in.HiddenKeyRetention, &out.HiddenKeyRetention
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerSpec.
@@ -168,6 +315,20 @@ func (in *TangServerSpec) DeepCopy() *TangServerSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TangServerStatus) DeepCopyInto(out *TangServerStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KeyPairs != nil {
+		in, out := &in.KeyPairs, &out.KeyPairs
+		*out = make([]TangServerKeyPair, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.ActiveKeys != nil {
 		in, out := &in.ActiveKeys, &out.ActiveKeys
 		*out = make([]TangServerActiveKeys, len(*in))
@@ -178,6 +339,24 @@ func (in *TangServerStatus) DeepCopyInto(out *TangServerStatus) {
 		*out = make([]TangServerHiddenKeys, len(*in))
 		copy(*out, *in)
 	}
+	if in.ReplicaKeys != nil {
+		in, out := &in.ReplicaKeys, &out.ReplicaKeys
+		*out = make([]TangServerReplicaKeys, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]TangServerPodStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NextKeyRotation != nil {
+		in, out := &in.NextKeyRotation, &out.NextKeyRotation
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerStatus.
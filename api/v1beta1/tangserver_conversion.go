@@ -1,5 +1,5 @@
 /*
-Copyright 2023.
+Copyright 2021.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -14,25 +14,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package controllers
+package v1beta1
 
 import (
-	"github.com/go-logr/logr"
-	"sync"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-var lock = &sync.Mutex{}
+// Hub marks v1beta1 as the conversion hub, the rest of versions convert to and from it
+func (*TangServer) Hub() {}
 
-var logInstance logr.Logger
-
-func GetLogInstance() logr.Logger {
-	lock.Lock()
-	defer lock.Unlock()
-	return logInstance
-}
-
-func SetLogInstance(l logr.Logger) {
-	lock.Lock()
-	defer lock.Unlock()
-	logInstance = l
+// SetupWebhookWithManager registers the conversion webhook for TangServer
+func (r *TangServer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, r).Complete()
 }
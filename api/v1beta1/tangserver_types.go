@@ -0,0 +1,486 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TangServerSpec defines the desired state of TangServer
+type TangServerSpec struct {
+	// Replicas is the Tang Server amount to bring up
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Amount of replicas to launch"
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Storage defines where the Tang Server keys are stored
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Key storage"
+	// +optional
+	Storage StorageSpec `json:"storage,omitempty"`
+
+	// Exposure defines how the Tang Server is exposed to its clients
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Service exposure"
+	// +optional
+	Exposure ExposureSpec `json:"exposure,omitempty"`
+
+	// Workload defines the Tang Server containers to run
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Workload"
+	// +optional
+	Workload WorkloadSpec `json:"workload,omitempty"`
+
+	// KeyPolicy defines how the Tang Server keys are managed
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Key policy"
+	// +optional
+	KeyPolicy KeyPolicySpec `json:"keyPolicy,omitempty"`
+}
+
+// StorageSpec defines where the Tang Server keys are stored
+type StorageSpec struct {
+	// KeyPath is the path where keys will be generated
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Key Path"
+	// +optional
+	// +kubebuilder:validation:MaxLength=4096
+	// +kubebuilder:validation:Pattern=`^/[A-Za-z0-9._/-]*$`
+	// +kubebuilder:validation:XValidation:rule="self.startsWith('/')",message="keyPath must be an absolute path"
+	// +kubebuilder:validation:XValidation:rule="!self.matches('(^|/)\\.\\.(/|$)')",message="keyPath must not contain '..'"
+	KeyPath string `json:"keyPath,omitempty"`
+
+	// Persistent Volume Claim to store the keys
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Persistent Volume Claim to attach to (default:tangserver-pvc)"
+	// +optional
+	PersistentVolumeClaim string `json:"persistentVolumeClaim,omitempty"`
+}
+
+// ExposureSpec defines how the Tang Server is exposed to its clients
+// +kubebuilder:validation:XValidation:rule="!has(self.clusterIP) || size(self.clusterIP) == 0 || (has(self.serviceType) && self.serviceType == 'ClusterIP')",message="clusterIP can only be set when serviceType is ClusterIP"
+// +kubebuilder:validation:XValidation:rule="!has(self.nodePort) || !has(self.serviceType) || self.serviceType in ['NodePort', 'LoadBalancer']",message="nodePort can only be set when serviceType is NodePort or LoadBalancer"
+type ExposureSpec struct {
+	// ServiceType
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ServiceType (LoadBalancer by default)"
+	// +optional
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer;ExternalName
+	ServiceType string `json:"serviceType,omitempty"`
+
+	// ClusterIP
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="ClusterIP (empty by default)"
+	// +optional
+	ClusterIP string `json:"clusterIP,omitempty"`
+
+	// ServiceListenPort is the port where service will listen for traffic
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Port where service will listen"
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	ServiceListenPort int32 `json:"serviceListenPort,omitempty"`
+
+	// PodListenPort is the port where pods will listen for traffic
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Port where Pod will listen"
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	PodListenPort int32 `json:"podListenPort,omitempty"`
+
+	// NodePort is the port to expose the service on each node when ServiceType is NodePort or LoadBalancer
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="NodePort (allocated by default)"
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	NodePort int32 `json:"nodePort,omitempty"`
+}
+
+// WorkloadSpec defines the Tang Server containers to run
+type WorkloadSpec struct {
+	// Image is the base container image of the TangServer to use
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Image of Container to deploy"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Version is the version of the TangServer container to use (empty=>latest)
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Image Version of Container to deploy"
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Secret is the secret name to use to download image appropriately
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Secret name to use for container download"
+	// +optional
+	Secret string `json:"secret,omitempty"` // #nosec G117 -- refers to a Kubernetes Secret name, not a credential value
+
+	// HealthScript is the script to run for healthiness/readiness
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Health Script to execute"
+	// +optional
+	HealthScript string `json:"healthScript,omitempty"`
+
+	// Resources are the resources to request and limit for each pod
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Resources for Tang Server"
+	// +optional
+	Resources WorkloadResources `json:"resources,omitempty"`
+}
+
+// WorkloadResources contains the resources requests and limits of Tang Server pods
+type WorkloadResources struct {
+	// Requests is the resource request to perform for each pod
+	// +optional
+	Requests ResourceValues `json:"requests,omitempty"`
+
+	// Limits is the resource limit to perform for each pod
+	// +optional
+	Limits ResourceValues `json:"limits,omitempty"`
+}
+
+// ResourceValues contains the cpu and memory quantities of a resource request or limit
+type ResourceValues struct {
+	Cpu    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+}
+
+// KeyPolicySpec defines how the Tang Server keys are managed
+type KeyPolicySpec struct {
+	// HiddenKeys contains a list with the keys (with sha1 or sha256) to hide
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Hidden Keys contains a list with the keys (with sha1 or sha256) to hide"
+	// +optional
+	// +kubebuilder:validation:MaxItems=256
+	// +kubebuilder:validation:XValidation:rule="self.all(k, has(k.sha1) || has(k.sha256))",message="each hidden key needs a sha1 or sha256 thumbprint"
+	// +kubebuilder:validation:XValidation:rule="self.all(k, !has(k.sha1) || k.sha1.matches('^[A-Za-z0-9_-]{27}$'))",message="sha1 thumbprint must be 27 base64url characters"
+	// +kubebuilder:validation:XValidation:rule="self.all(k, !has(k.sha256) || k.sha256.matches('^[A-Za-z0-9_-]{43}$'))",message="sha256 thumbprint must be 43 base64url characters"
+	// +kubebuilder:validation:XValidation:rule="self.all(k, !has(k.action) || k.action != 'Delete' || (has(k.confirmDelete) && ((has(k.sha1) && k.confirmDelete == k.sha1) || (has(k.sha256) && k.confirmDelete == k.sha256))))",message="Delete action requires confirmDelete with the thumbprint of the key"
+	HiddenKeys []TangServerHiddenKeys `json:"hiddenKeys,omitempty"`
+
+	// RequiredActiveKeyPairs
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Required Active Key Pairs (1 by default)"
+	// +optional
+	RequiredActiveKeyPairs uint32 `json:"requiredActiveKeyPairs,omitempty"`
+
+	// KeyRefreshInterval
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Refresh Interval to update key status"
+	// +optional
+	KeyRefreshInterval uint32 `json:"keyRefreshInterval,omitempty"`
+
+	// Rotation rotates the active key pairs automatically once they are older than a maximum age
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Automatic Key Rotation"
+	// +optional
+	Rotation *KeyRotationPolicy `json:"rotation,omitempty"`
+
+	// HiddenKeyRetention is the time hidden key pairs are kept before being purged. Hidden key pairs are kept
+	// forever if not set
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Retention of the hidden key pairs"
+	// +optional
+	HiddenKeyRetention *metav1.Duration `json:"hiddenKeyRetention,omitempty"`
+
+	// MinHiddenKeyPairs is the amount of most recently hidden key pairs never purged by the hidden key retention
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Minimum hidden key pairs kept by the retention"
+	// +optional
+	MinHiddenKeyPairs uint32 `json:"minHiddenKeyPairs,omitempty"`
+
+	// RepairDivergedReplicas copies the key files missing in a ready replica from the authoritative one, the
+	// replica keys are managed on. Diverged replicas are only reported if not set
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Repair replicas with diverged keys"
+	// +optional
+	RepairDivergedReplicas bool `json:"repairDivergedReplicas,omitempty"`
+}
+
+// KeyRotationPolicy defines when the active key pairs are rotated automatically
+type KeyRotationPolicy struct {
+	// MaxKeyAge is the age after which an active key pair is rotated
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Maximum age of the active key pairs"
+	MaxKeyAge metav1.Duration `json:"maxKeyAge"`
+	// Schedule is a cron expression (minute hour day-of-month month day-of-week, in UTC). When provided,
+	// key pairs older than MaxKeyAge are only rotated at the scheduled times
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Cron schedule of the key rotations (UTC)"
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+	// MaintenanceWindow restricts the key rotations to a daily time window
+	// +operator-sdk:csv:customresourcedefinitions:type=spec,displayName="Maintenance window of the key rotations"
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// MaintenanceWindow defines a daily time window, in UTC
+type MaintenanceWindow struct {
+	// Start is the time of the day the window opens, in HH:MM format (UTC)
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+	// Duration is how long the window stays open, up to 24h
+	Duration metav1.Duration `json:"duration"`
+}
+
+// TangServerActiveKeys defines the active keys in a Tang Server
+type TangServerActiveKeys struct {
+	// Active Key sha1
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Active Key SHA1"
+	// +optional
+	Sha1 string `json:"sha1,omitempty"`
+	// Active Key sha256
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Active Key SHA256"
+	// +optional
+	Sha256 string `json:"sha256,omitempty"`
+	// Active Key Generation Time
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Active Key Generation Time"
+	Generated string `json:"generated,omitempty"`
+	// FileName provides information about the file name corresponding to the key
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Active Key file name"
+	// +optional
+	FileName string `json:"fileName,omitempty"`
+}
+
+// TangServerHiddenKeys defines the hidden keys in a Tang Server
+type TangServerHiddenKeys struct {
+	// Hidden Key sha1
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Hidden Key SHA1"
+	// +optional
+	// +kubebuilder:validation:MaxLength=64
+	Sha1 string `json:"sha1,omitempty"`
+	// Hidden Key sha256
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Hidden Key SHA256"
+	// +optional
+	// +kubebuilder:validation:MaxLength=64
+	Sha256 string `json:"sha256,omitempty"`
+	// Hidden Key Generation Time
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Hidden Key Generation Time"
+	Generated string `json:"generated,omitempty"`
+	// Hidden Key Hiding Time
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Hidden Key Hidden Time"
+	Hidden string `json:"hidden,omitempty"`
+	// FileName provides information about the file name corresponding to the key
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Hidden Key file name"
+	// +optional
+	FileName string `json:"fileName,omitempty"`
+	// Hold keeps the hidden key pair from being purged by the hidden key retention. Only used in spec
+	// +optional
+	Hold bool `json:"hold,omitempty"`
+	// Action requested for the key: Hide rotates it if active and keeps it hidden (default), KeepHidden keeps
+	// it hidden without rotating it if active, and Delete deletes its key pair if hidden. Hidden keys are never
+	// deleted without the Delete action. Only used in spec
+	// +kubebuilder:validation:Enum=Hide;KeepHidden;Delete
+	// +optional
+	Action string `json:"action,omitempty"`
+	// ConfirmDelete must repeat the sha1 or sha256 thumbprint of the key for the Delete action. Only used in spec
+	// +optional
+	// +kubebuilder:validation:MaxLength=64
+	ConfirmDelete string `json:"confirmDelete,omitempty"`
+}
+
+// TangServerKey defines a key file of a Tang Server key pair
+type TangServerKey struct {
+	// Sha1 is the SHA-1 thumbprint of the key
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key SHA1"
+	// +optional
+	Sha1 string `json:"sha1,omitempty"`
+	// Sha256 is the SHA-256 thumbprint of the key
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key SHA256"
+	// +optional
+	Sha256 string `json:"sha256,omitempty"`
+	// Alg is the algorithm of the key (i.e. ES512 for signing keys, ECMR for exchange keys)
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key Algorithm"
+	// +optional
+	Alg string `json:"alg,omitempty"`
+	// FileName is the name of the key file in the key directory
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key file name"
+	// +optional
+	FileName string `json:"fileName,omitempty"`
+}
+
+// TangServerKeyPair defines a signing key and the exchange key generated with it
+type TangServerKeyPair struct {
+	// State of the key pair: Active pairs are advertised, Hidden pairs are only used to recover existing bindings
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key Pair State"
+	// +kubebuilder:validation:Enum=Active;Hidden
+	State string `json:"state"`
+	// Signing is the key used to sign the advertisement
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Signing Key"
+	Signing TangServerKey `json:"signing"`
+	// Exchange is the key clevis uses to recover the secrets bound to the Tang Server
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Exchange Key"
+	Exchange TangServerKey `json:"exchange"`
+	// Generated is the time the key pair was generated
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key Pair Generation Time"
+	// +optional
+	Generated *metav1.Time `json:"generated,omitempty"`
+	// Hidden is the time the key pair was hidden, if it is hidden
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key Pair Hidden Time"
+	// +optional
+	Hidden *metav1.Time `json:"hidden,omitempty"`
+	// Purge is the time the hidden key pair is purged by the hidden key retention, if it is
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Key Pair Purge Time"
+	// +optional
+	Purge *metav1.Time `json:"purge,omitempty"`
+}
+
+// TangServerStatusError collects error on Tang Operator creation
+type TangServerStatusError string
+
+// TangServerReplicaKeys defines the keys found in the key directory of a ready tang pod
+type TangServerReplicaKeys struct {
+	// PodName is the name of the tang pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Pod Name"
+	PodName string `json:"podName"`
+	// Authoritative is true for the replica the keys are managed on, the other replicas are compared with
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Authoritative Replica"
+	// +optional
+	Authoritative bool `json:"authoritative,omitempty"`
+	// ActiveKeys contains the sha256 thumbprints of the active keys of the replica
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Replica Active Keys"
+	// +optional
+	ActiveKeys []string `json:"activeKeys,omitempty"`
+	// HiddenKeys contains the sha256 thumbprints of the hidden keys of the replica
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Replica Hidden Keys"
+	// +optional
+	HiddenKeys []string `json:"hiddenKeys,omitempty"`
+	// MissingKeyFiles contains the key files of the authoritative replica missing in this replica
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Missing Key Files"
+	// +optional
+	MissingKeyFiles []string `json:"missingKeyFiles,omitempty"`
+	// UnexpectedKeyFiles contains the key files of this replica absent from the authoritative replica, or with
+	// a different content
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Unexpected Key Files"
+	// +optional
+	UnexpectedKeyFiles []string `json:"unexpectedKeyFiles,omitempty"`
+	// Error is the reason the keys of the replica could not be read, if any
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Replica Key Inventory Error"
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// TangServerPodStatus defines the observed state of a tang pod
+type TangServerPodStatus struct {
+	// Name is the name of the tang pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Pod Name"
+	Name string `json:"name"`
+	// NodeName is the node the pod is scheduled on
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Node Name"
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// Phase is the phase of the pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Pod Phase"
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// Ready is true when the pod is ready to serve
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Pod Ready"
+	// +optional
+	Ready bool `json:"ready,omitempty"`
+	// Restarts is the number of restarts of the containers of the pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Pod Restarts"
+	// +optional
+	Restarts int32 `json:"restarts,omitempty"`
+	// CrashLooping is true when a container of the pod is in CrashLoopBackOff
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Pod Crash Looping"
+	// +optional
+	CrashLooping bool `json:"crashLooping,omitempty"`
+	// ImageID is the image the tang container of the pod runs
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Image ID"
+	// +optional
+	ImageID string `json:"imageID,omitempty"`
+	// ServedKeys contains the sha256 thumbprints of the active keys the pod serves
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Served Keys"
+	// +optional
+	ServedKeys []string `json:"servedKeys,omitempty"`
+}
+
+// TangServerStatus defines the observed state of TangServer
+type TangServerStatus struct {
+	// TangServerError collects error on Tang Operator creation
+	// Deprecated: use Conditions instead. This field is kept as a mirror of the Degraded and KeysReady conditions
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server Error"
+	// +optional
+	TangServerError TangServerStatusError `json:"tangServerError,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Observed Generation"
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions provide the standard observations of the Tang Server state
+	// (Available, Progressing, Degraded, KeysReady, ServiceReady, RotationInProgress, KeysPaired, KeysLocked,
+	// HiddenKeysMigrationRequired, KeysDiverged, PodsCrashLooping)
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:io.kubernetes.conditions",displayName="Conditions"
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// KeyPairs provides information about the active and hidden key pairs of the Tang Server
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Tang Server Key Pairs"
+	// +optional
+	KeyPairs []TangServerKeyPair `json:"keyPairs,omitempty"`
+	// ActiveKeys provides information about the Active Keys in the Tang Server CR
+	// Deprecated: use KeyPairs instead. This field only lists the signing keys of the active key pairs
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server Active Keys"
+	// +optional
+	ActiveKeys []TangServerActiveKeys `json:"activeKeys,omitempty"`
+	// HiddenKeys provides information about the Hidden Keys in the Tang Server CR
+	// Deprecated: use KeyPairs instead. This field only lists the signing keys of the hidden key pairs
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server Hidden Keys"
+	// +optional
+	HiddenKeys []TangServerHiddenKeys `json:"hiddenKeys,omitempty"`
+	// ReplicaKeys provides the keys found in each ready tang pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Tang Server Replica Keys"
+	// +optional
+	ReplicaKeys []TangServerReplicaKeys `json:"replicaKeys,omitempty"`
+	// Pods provides the observed state of each tang pod
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Tang Server Pods"
+	// +optional
+	Pods []TangServerPodStatus `json:"pods,omitempty"`
+	// NextKeyRotation is the time the active key pairs are next rotated by the key rotation policy
+	// +operator-sdk:csv:customresourcedefinitions:type=status,displayName="Next Key Rotation"
+	// +optional
+	NextKeyRotation *metav1.Time `json:"nextKeyRotation,omitempty"`
+	// Tang Server Running provides information about the Running Replicas
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server Running Replicas"
+	// +optional
+	Running int32 `json:"running"`
+	// Tang Server Ready provides information about the Ready Replicas
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server Ready Replicas"
+	// +optional
+	Ready int32 `json:"ready"`
+	// Selector is the label selector of the Tang Server pods, used by the scale subresource
+	// +optional
+	Selector string `json:"selector,omitempty"`
+	// Tang Server Service External URL provides information about the External Service URL
+	// +operator-sdk:csv:customresourcedefinitions:type=status,xDescriptors="urn:alm:descriptor:text",displayName="Tang Server External URL"
+	// +optional
+	ServiceExternalURL string `json:"serviceExternalURL,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.running,selectorpath=.status.selector
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas",description="Replicas to launch for a particular deployment"
+// +kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.workload.image",description="Container Image to use"
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.workload.version",description="Version of the Container Image to use"
+// +kubebuilder:printcolumn:name="ServiceType",type="string",JSONPath=".spec.exposure.serviceType",description="Type of the Service exposing Tang Server"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.ready",description="Ready replicas"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// TangServer is the Schema for the tangservers API
+type TangServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TangServerSpec   `json:"spec,omitempty"`
+	Status TangServerStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TangServerList contains a list of TangServer
+type TangServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TangServer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TangServer{}, &TangServerList{})
+}
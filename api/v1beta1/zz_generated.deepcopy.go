@@ -0,0 +1,435 @@
+//go:build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExposureSpec) DeepCopyInto(out *ExposureSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExposureSpec.
+func (in *ExposureSpec) DeepCopy() *ExposureSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExposureSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyPolicySpec) DeepCopyInto(out *KeyPolicySpec) {
+	*out = *in
+	if in.HiddenKeys != nil {
+		in, out := &in.HiddenKeys, &out.HiddenKeys
+		*out = make([]TangServerHiddenKeys, len(*in))
+		copy(*out, *in)
+	}
+	if in.Rotation != nil {
+		in, out := &in.Rotation, &out.Rotation
+		*out = new(KeyRotationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HiddenKeyRetention != nil {
+		in, out := &in.HiddenKeyRetention, &out.HiddenKeyRetention
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyPolicySpec.
+func (in *KeyPolicySpec) DeepCopy() *KeyPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRotationPolicy) DeepCopyInto(out *KeyRotationPolicy) {
+	*out = *in
+	out.MaxKeyAge = in.MaxKeyAge
+	if in.MaintenanceWindow != nil {
+		in, out := &in.MaintenanceWindow, &out.MaintenanceWindow
+		*out = new(MaintenanceWindow)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRotationPolicy.
+func (in *KeyRotationPolicy) DeepCopy() *KeyRotationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRotationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceValues) DeepCopyInto(out *ResourceValues) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceValues.
+func (in *ResourceValues) DeepCopy() *ResourceValues {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceValues)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageSpec) DeepCopyInto(out *StorageSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageSpec.
+func (in *StorageSpec) DeepCopy() *StorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServer) DeepCopyInto(out *TangServer) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServer.
+func (in *TangServer) DeepCopy() *TangServer {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TangServer) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerActiveKeys) DeepCopyInto(out *TangServerActiveKeys) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerActiveKeys.
+func (in *TangServerActiveKeys) DeepCopy() *TangServerActiveKeys {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerActiveKeys)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerHiddenKeys) DeepCopyInto(out *TangServerHiddenKeys) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerHiddenKeys.
+func (in *TangServerHiddenKeys) DeepCopy() *TangServerHiddenKeys {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerHiddenKeys)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerKey) DeepCopyInto(out *TangServerKey) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerKey.
+func (in *TangServerKey) DeepCopy() *TangServerKey {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerKeyPair) DeepCopyInto(out *TangServerKeyPair) {
+	*out = *in
+	out.Signing = in.Signing
+	out.Exchange = in.Exchange
+	if in.Generated != nil {
+		in, out := &in.Generated, &out.Generated
+		*out = (*in).DeepCopy()
+	}
+	if in.Hidden != nil {
+		in, out := &in.Hidden, &out.Hidden
+		*out = (*in).DeepCopy()
+	}
+	if in.Purge != nil {
+		in, out := &in.Purge, &out.Purge
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerKeyPair.
+func (in *TangServerKeyPair) DeepCopy() *TangServerKeyPair {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerKeyPair)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerList) DeepCopyInto(out *TangServerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TangServer, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerList.
+func (in *TangServerList) DeepCopy() *TangServerList {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TangServerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerPodStatus) DeepCopyInto(out *TangServerPodStatus) {
+	*out = *in
+	if in.ServedKeys != nil {
+		in, out := &in.ServedKeys, &out.ServedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerPodStatus.
+func (in *TangServerPodStatus) DeepCopy() *TangServerPodStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerPodStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerReplicaKeys) DeepCopyInto(out *TangServerReplicaKeys) {
+	*out = *in
+	if in.ActiveKeys != nil {
+		in, out := &in.ActiveKeys, &out.ActiveKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HiddenKeys != nil {
+		in, out := &in.HiddenKeys, &out.HiddenKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MissingKeyFiles != nil {
+		in, out := &in.MissingKeyFiles, &out.MissingKeyFiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnexpectedKeyFiles != nil {
+		in, out := &in.UnexpectedKeyFiles, &out.UnexpectedKeyFiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerReplicaKeys.
+func (in *TangServerReplicaKeys) DeepCopy() *TangServerReplicaKeys {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerReplicaKeys)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerSpec) DeepCopyInto(out *TangServerSpec) {
+	*out = *in
+	out.Storage = in.Storage
+	out.Exposure = in.Exposure
+	out.Workload = in.Workload
+	in.KeyPolicy.DeepCopyInto(&out.KeyPolicy)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerSpec.
+func (in *TangServerSpec) DeepCopy() *TangServerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TangServerStatus) DeepCopyInto(out *TangServerStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KeyPairs != nil {
+		in, out := &in.KeyPairs, &out.KeyPairs
+		*out = make([]TangServerKeyPair, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ActiveKeys != nil {
+		in, out := &in.ActiveKeys, &out.ActiveKeys
+		*out = make([]TangServerActiveKeys, len(*in))
+		copy(*out, *in)
+	}
+	if in.HiddenKeys != nil {
+		in, out := &in.HiddenKeys, &out.HiddenKeys
+		*out = make([]TangServerHiddenKeys, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReplicaKeys != nil {
+		in, out := &in.ReplicaKeys, &out.ReplicaKeys
+		*out = make([]TangServerReplicaKeys, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]TangServerPodStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NextKeyRotation != nil {
+		in, out := &in.NextKeyRotation, &out.NextKeyRotation
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TangServerStatus.
+func (in *TangServerStatus) DeepCopy() *TangServerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TangServerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadResources) DeepCopyInto(out *WorkloadResources) {
+	*out = *in
+	out.Requests = in.Requests
+	out.Limits = in.Limits
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadResources.
+func (in *WorkloadResources) DeepCopy() *WorkloadResources {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadResources)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadSpec) DeepCopyInto(out *WorkloadSpec) {
+	*out = *in
+	out.Resources = in.Resources
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadSpec.
+func (in *WorkloadSpec) DeepCopy() *WorkloadSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -23,12 +23,17 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
@@ -50,14 +55,91 @@ const DEFAULT_TANG_FINALIZER = "finalizer.nbde.tangserver.openshift.io"
 // Default recheck of keys when no active keys exit
 const DEFAULT_RECONCILE_TIMER_NO_ACTIVE_KEYS = 5 // seconds
 
-// Var to count active keys retrying counter
-var activeKeyRetries uint32 = 0
+// Default timeout of each command executed in the tang pods
+const DEFAULT_EXEC_TIMEOUT = 30 * time.Second
+
+// Default time allowed for all the key management performed in a reconciliation
+const DEFAULT_KEY_MANAGEMENT_TIMEOUT = 2 * time.Minute
 
 // TangServerReconciler reconciles a TangServer object
 type TangServerReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder events.EventRecorder
+	// APIReader reads the objects which must not be served from the cache, such as the key locks (the client if not set)
+	APIReader client.Reader
+	// MaxConcurrentReconciles is the maximum number of TangServers reconciled concurrently (1 if not set)
+	MaxConcurrentReconciles int
+	// Executor runs the key management commands in the tang pods
+	Executor PodExecutor
+	// ExecTimeout bounds each command executed in the tang pods (DEFAULT_EXEC_TIMEOUT if not set)
+	ExecTimeout time.Duration
+	// KeyManagementTimeout bounds all the key management of a reconciliation (DEFAULT_KEY_MANAGEMENT_TIMEOUT if not set)
+	KeyManagementTimeout time.Duration
+	// LockIdentity identifies the reconciler as holder of the key locks (host name and a random suffix if not set)
+	LockIdentity string
+	// Now returns the current time, used to schedule key rotations (time.Now if not set)
+	Now func() time.Time
+
+	// activeKeyRetries counts, for each TangServer, the consecutive reconciliations without active keys
+	activeKeyRetries keyRetries
+	// keyInventory caches, for each TangServer, the metadata of its key files
+	keyInventory keyInventoryCache
+}
+
+// keyRetries is a retry counter per TangServer, safe for concurrent use
+type keyRetries struct {
+	mu      sync.Mutex
+	retries map[types.NamespacedName]uint32
+}
+
+// increment adds a retry for the TangServer provided and returns the resulting amount of retries
+func (k *keyRetries) increment(name types.NamespacedName) uint32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.retries == nil {
+		k.retries = make(map[types.NamespacedName]uint32)
+	}
+	k.retries[name]++
+	return k.retries[name]
+}
+
+// reset clears the retries of the TangServer provided
+func (k *keyRetries) reset(name types.NamespacedName) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.retries, name)
+}
+
+// get returns the amount of retries of the TangServer provided
+func (k *keyRetries) get(name types.NamespacedName) uint32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.retries[name]
+}
+
+// getAPIReader returns the reader of the objects read from the API server instead of the cache
+func (r *TangServerReconciler) getAPIReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// getExecTimeout returns the timeout of each command executed in the tang pods
+func (r *TangServerReconciler) getExecTimeout() time.Duration {
+	if r.ExecTimeout > 0 {
+		return r.ExecTimeout
+	}
+	return DEFAULT_EXEC_TIMEOUT
+}
+
+// getKeyManagementTimeout returns the time allowed for the key management of a reconciliation
+func (r *TangServerReconciler) getKeyManagementTimeout() time.Duration {
+	if r.KeyManagementTimeout > 0 {
+		return r.KeyManagementTimeout
+	}
+	return DEFAULT_KEY_MANAGEMENT_TIMEOUT
 }
 
 // contains returns true if a string is found on a slice
@@ -110,25 +192,27 @@ func updateUID(cr *daemonsv1alpha1.TangServer, req ctrl.Request) {
 }
 
 // finalizeTangServerApp runs required tasks before deleting the objects owned by the CR
-func (r *TangServerReconciler) finalizeTangServer(cr *daemonsv1alpha1.TangServer) error {
+func (r *TangServerReconciler) finalizeTangServer(ctx context.Context, cr *daemonsv1alpha1.TangServer) error {
+	l := log.FromContext(ctx)
 	// TODO(user): Add the cleanup steps that the operator
 	// needs to do before the CR can be deleted. Examples
 	// of finalizers include performing backups and deleting
 	// resources that are not owned by this CR, like a PVC.
-	GetLogInstance().Info("Successfully finalized TangServer")
+	l.Info("Successfully finalized TangServer")
 	return nil
 }
 
 // checkCRReadyForDeletion will check if CR can be deleted appropriately
 func (r *TangServerReconciler) checkCRReadyForDeletion(ctx context.Context, tangserver *daemonsv1alpha1.TangServer) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
 	if contains(tangserver.GetFinalizers(), DEFAULT_TANG_FINALIZER) {
 		// Run the finalizer logic
-		err := r.finalizeTangServer(tangserver)
+		err := r.finalizeTangServer(ctx, tangserver)
 		if err != nil {
 			// Don't remove the finalizer if we failed to finalize the object
 			return ctrl.Result{}, err
 		}
-		GetLogInstance().Info("TangServer finalizers completed")
+		l.Info("TangServer finalizers completed")
 		// Remove finalizer once the finalizer logic has run
 		controllerutil.RemoveFinalizer(tangserver, DEFAULT_TANG_FINALIZER)
 		err = r.Update(ctx, tangserver)
@@ -137,7 +221,7 @@ func (r *TangServerReconciler) checkCRReadyForDeletion(ctx context.Context, tang
 			return ctrl.Result{}, err
 		}
 	}
-	GetLogInstance().Info("TangServer can be deleted now")
+	l.Info("TangServer can be deleted now")
 	return ctrl.Result{}, nil
 }
 
@@ -150,6 +234,9 @@ func (r *TangServerReconciler) checkCRReadyForDeletion(ctx context.Context, tang
 //+kubebuilder:rbac:groups=core,resources=pods/log,verbs=get;list;watch;create;update
 //+kubebuilder:rbac:groups=core,resources=pods/exec,verbs=get;list;watch;create;update
 //+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=list;watch
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -164,7 +251,6 @@ func (r *TangServerReconciler) checkCRReadyForDeletion(ctx context.Context, tang
 // +kubebuilder:rbac:groups=apps.redhat,resources=tangservers/status,verbs=get;update;patch
 func (r *TangServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
-	SetLogInstance(l)
 
 	tangserver := &daemonsv1alpha1.TangServer{
 		ObjectMeta: metav1.ObjectMeta{
@@ -177,8 +263,14 @@ func (r *TangServerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	err := r.Get(ctx, req.NamespacedName, tangserver)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// Owned objects are garbage collected, only the state kept for the TangServer is dropped
 			l.Info("TangServer resource not found")
+			r.activeKeyRetries.reset(req.NamespacedName)
+			r.keyInventory.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
 		}
+		l.Error(err, "Unable to get TangServer")
+		return ctrl.Result{}, err
 	}
 
 	// Check if the CR is marked to be deleted
@@ -188,22 +280,24 @@ func (r *TangServerReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// Reconcile Deployment object
-	result, err := r.reconcileDeployment(tangserver)
+	result, err := r.reconcileDeployment(ctx, tangserver)
 	if err != nil {
 		l.Error(err, "Error on deployment reconciliation", "Error:", err.Error())
 		dumpToErrFile("Error on deployment reconciliation, Error:" + err.Error() + "\n")
+		r.updateDegradedStatus(ctx, tangserver)
 		return result, err
 	}
 	// Reconcile Service object
-	result, err = r.reconcileService(tangserver)
+	result, err = r.reconcileService(ctx, tangserver)
 	if err != nil {
 		l.Error(err, "Error on service reconciliation")
+		r.updateDegradedStatus(ctx, tangserver)
 		return result, err
 	}
 
 	// Reconcile finished, requeue for key refresh if necessary
 	var reconcile bool
-	if result, reconcile = r.reconcilePeriodic(tangserver); reconcile {
+	if result, reconcile = r.reconcilePeriodic(ctx, tangserver); reconcile {
 		return result, nil
 	}
 	return ctrl.Result{}, nil
@@ -233,306 +327,502 @@ func mustRedeploy(new *appsv1.Deployment, prev *appsv1.Deployment) bool {
 		new.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] !=
 			prev.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] ||
 		new.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] !=
-			prev.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] {
+			prev.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] ||
+		// Pods of deployments created before the operator label was introduced must be relabelled to be cached
+		new.Spec.Template.Labels[TANGSERVER_POD_LABEL] != prev.Spec.Template.Labels[TANGSERVER_POD_LABEL] {
 		return true
 	}
 	return false
 }
 
-// handleHiddenKeys rotate keys if user specifies so in the spec
-func (r *TangServerReconciler) handleHiddenKeys(keyinfo KeyObtainInfo) bool {
-	rotated := false
-	// check hidden keys to be maintained (and delete those in the status non specified)
-	keepKeyMap := make(KeySelectiveMap, 0)
-	for _, hk := range keyinfo.TangServer.Spec.HiddenKeys {
-		for _, hsk := range keyinfo.TangServer.Status.HiddenKeys {
-			if hk.Sha1 == hsk.Sha1 {
-				keepKeyMap[hk.Sha1] = hk.Sha1
-				keepKeyMap[hsk.Sha256] = hsk.Sha256
-			} else if hk.Sha256 == hsk.Sha256 {
-				keepKeyMap[hk.Sha256] = hk.Sha256
-				keepKeyMap[hsk.Sha1] = hsk.Sha1
-			}
-		}
+// updateDegradedStatus stores the conditions set by a failing reconcile stage
+func (r *TangServerReconciler) updateDegradedStatus(ctx context.Context, cr *daemonsv1alpha1.TangServer) {
+	l := log.FromContext(ctx)
+	if !isConditionTrue(cr, daemonsv1alpha1.ConditionDegraded) {
+		return
 	}
-	// only delete selectively if have something to keep
-	if len(keepKeyMap) > 0 {
-		if err := deleteHiddenKeysSelectively(keepKeyMap, keyinfo); err != nil {
-			GetLogInstance().Error(err, "Unable to delete keys selectively", "keymap", keepKeyMap, "keyInfo", keyinfo)
-		}
+	if err := r.Client.Status().Update(ctx, cr); err != nil {
+		l.Error(err, "Unable to update TangServer status with Degraded condition")
 	}
+}
+
+// handleHiddenKeys rotates the active keys to hide and deletes the hidden keys to delete, as requested in spec.
+// Hidden keys are only deleted when explicitly requested, never because spec omits them
+func (r *TangServerReconciler) handleHiddenKeys(ctx context.Context, keyinfo KeyObtainInfo) bool {
+	l := log.FromContext(ctx)
+	rotated := false
+	r.deleteRequestedHiddenKeys(ctx, keyinfo)
 
 	// check if key is in active keys and rotate it
+	rotationFailed := false
 	for _, hk := range keyinfo.TangServer.Spec.HiddenKeys {
+		if hiddenKeyAction(hk) != daemonsv1alpha1.HiddenKeyActionHide {
+			continue
+		}
 		for _, ak := range keyinfo.TangServer.Status.ActiveKeys {
 			if ak.Sha1 == hk.Sha1 || ak.Sha256 == hk.Sha256 {
-				GetLogInstance().Info("Key must be rotated", "sha1", hk.Sha1,
+				l.Info("Key must be rotated", "sha1", hk.Sha1,
 					"sha256", hk.Sha256)
-				kr := KeyRotateInfo{
-					KeyInfo:     &keyinfo,
-					KeyFileName: ak.FileName,
-				}
-				if err := rotateKey(kr); err == nil {
+				r.startRotation(ctx, keyinfo.TangServer, ak.FileName)
+				if pair, err := rotateKeyPair(ctx, keyinfo, ak.FileName, KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON); err == nil {
 					rotated = true
-					GetLogInstance().Info("Key rotated correctly", "sha1", hk.Sha1, "sha256", hk.Sha256)
-					keyinfo.TangServer.Status.TangServerError = daemonsv1alpha1.NoError
-					r.Recorder.Eventf(keyinfo.TangServer, nil, "Normal", "KeyRotation", "KeyRotation", "Key Rotated Correctly, Key File: %s", ak.FileName)
-					if err := rotateUnadvertisedKeys(kr); err != nil {
-						GetLogInstance().Error(err, "Unable to rotate unadvertised keys", "Rotating Key", kr)
-					}
+					l.Info("Key rotated correctly", "sha1", hk.Sha1, "sha256", hk.Sha256, "New Key File", pair.Signing.File.Name)
+					r.Recorder.Eventf(keyinfo.TangServer, nil, "Normal", "KeyRotation", "KeyRotation", "Key Rotated Correctly, Key File: %s, New Key File: %s",
+						ak.FileName, pair.Signing.File.Name)
 				} else {
-					GetLogInstance().Error(err, "Key not rotated correctly", "sha1", hk.Sha1, "sha256", hk.Sha256)
+					rotationFailed = true
+					l.Error(err, "Key not rotated correctly", "sha1", hk.Sha1, "sha256", hk.Sha256)
 					r.Recorder.Eventf(keyinfo.TangServer, nil, "Error", "KeyRotation", "KeyRotation", "Key NOT Rotated Correctly, Key File: %s", ak.FileName)
-					keyinfo.TangServer.Status.TangServerError = daemonsv1alpha1.ActiveKeyNotFoundError
+					setDegraded(keyinfo.TangServer, daemonsv1alpha1.ReasonKeyRotationFailed,
+						fmt.Sprintf("Unable to rotate key file %s", ak.FileName))
 				}
 			}
 		}
 	}
+	if rotationFailed {
+		setCondition(keyinfo.TangServer, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationFailed, "Last key rotation failed")
+	} else if rotated {
+		setCondition(keyinfo.TangServer, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationSucceeded, "Last key rotation completed")
+	}
 	return rotated
 }
 
+// recoverRotation resumes or rolls back a rotation interrupted by an operator restart, reporting how it was
+// recovered. It returns false if the rotation could not be recovered
+func (r *TangServerReconciler) recoverRotation(ctx context.Context, k KeyObtainInfo) bool {
+	l := log.FromContext(ctx)
+	journal, recovery, err := recoverRotation(ctx, k)
+	if err != nil && (k.timedOut() || ctx.Err() != nil) {
+		// Reported as key management timeout
+		return false
+	} else if err != nil {
+		l.Error(err, "Unable to recover interrupted rotation", "podname", k.PodName, "namespace", k.Namespace)
+		r.Recorder.Eventf(k.TangServer, nil, "Warning", "KeyRotationRecoveryFailed", "KeyRotationRecovery",
+			"Unable to recover interrupted key rotation: %s", err.Error())
+		setDegraded(k.TangServer, daemonsv1alpha1.ReasonKeyRotationFailed, "Unable to recover interrupted key rotation")
+		return false
+	}
+	switch recovery {
+	case ROTATION_RESUMED:
+		message := fmt.Sprintf("Interrupted rotation of key file %s started at %s resumed", journal.KeyFileName,
+			journal.Started.Format(time.RFC3339))
+		r.Recorder.Eventf(k.TangServer, nil, "Normal", "KeyRotationResumed", "KeyRotationRecovery", "%s", message)
+		setCondition(k.TangServer, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationSucceeded, message)
+		r.activeKeyRetries.reset(client.ObjectKeyFromObject(k.TangServer))
+	case ROTATION_ROLLED_BACK:
+		message := fmt.Sprintf("Interrupted rotation of key file %s started at %s rolled back", journal.KeyFileName,
+			journal.Started.Format(time.RFC3339))
+		r.Recorder.Eventf(k.TangServer, nil, "Warning", "KeyRotationRolledBack", "KeyRotationRecovery", "%s", message)
+		setCondition(k.TangServer, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationRolledBack, message)
+	}
+	return true
+}
+
+// startRotation flags the rotation as in progress and stores it, so that it can be observed while keys are moved
+func (r *TangServerReconciler) startRotation(ctx context.Context, cr *daemonsv1alpha1.TangServer, keyFileName string) {
+	l := log.FromContext(ctx)
+	if !setCondition(cr, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionTrue,
+		daemonsv1alpha1.ReasonKeyRotationStarted, fmt.Sprintf("Rotating key file %s", keyFileName)) {
+		return
+	}
+	if err := r.Client.Status().Update(ctx, cr); err != nil {
+		l.Error(err, "Unable to update TangServer status with rotation in progress")
+	}
+}
+
 // UpdateKeys updates keys in the CR status
-func (r *TangServerReconciler) UpdateKeys(k KeyObtainInfo) {
-	newKeysCreated := r.CreateNewKeysIfNecessary(k)
-	// Read first hidden keys, as created will be retrieved from active keys (if exists)
-	hiddenKeys, _ := readHiddenKeys(k, ONLY_ADVERTISED)
-	activeKeys, _ := readActiveKeys(k, ONLY_ADVERTISED)
+func (r *TangServerReconciler) UpdateKeys(ctx context.Context, k KeyObtainInfo) {
+	l := log.FromContext(ctx)
+	activeKeysChanged := r.convergeActiveKeys(ctx, k)
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		// Keys that could not be read (i.e. exec timed out) keep their previous status
+		l.Error(err, "Unable to read keys", "podname", k.PodName, "namespace", k.Namespace)
+		return
+	}
+	hiddenKeys := hiddenKeysFromInventory(ctx, k, inventory, ONLY_ADVERTISED)
+	activeKeys := activeKeysFromInventory(ctx, k, inventory, ONLY_ADVERTISED)
 	k.TangServer.Status.ActiveKeys = activeKeys
 	k.TangServer.Status.HiddenKeys = hiddenKeys
-	if newKeysCreated {
-		GetLogInstance().Info("New active keys created", "Active Keys",
+	r.updateKeyPairs(ctx, k, inventory)
+	if activeKeysChanged {
+		l.Info("Active keys converged to required active keys", "Active Keys",
 			activeKeys, "Hidden Keys", hiddenKeys)
 	} else {
-		GetLogInstance().Info("No new active keys created",
+		l.Info("No active keys changed",
 			"Active Keys", activeKeys, "Hidden Keys", hiddenKeys)
 	}
 }
 
-// CreateNewKeysIfNecessary creates new keys if spec mandates so
-func (r *TangServerReconciler) CreateNewKeysIfNecessary(k KeyObtainInfo) bool {
-	requiredActiveKeyPairs := daemonsv1alpha1.DefaultActiveKeyPairs
-	if k.TangServer.Spec.RequiredActiveKeyPairs > 0 {
-		requiredActiveKeyPairs = k.TangServer.Spec.RequiredActiveKeyPairs
-		GetLogInstance().Info("Using specified required active keys", "Key Amount", requiredActiveKeyPairs)
-	} else {
-		GetLogInstance().Info("Using default active keys", "Key Amount", requiredActiveKeyPairs)
-	}
-	GetLogInstance().Info("createNewKeysIfNecessary", "Active Keys", int(len(k.TangServer.Status.ActiveKeys)),
-		"Required Active Keys", requiredActiveKeyPairs)
-	// Only create if more than one required active key pairs. Otherwise, they are automatically created
-	if int(len(k.TangServer.Status.ActiveKeys)) < int(requiredActiveKeyPairs) && requiredActiveKeyPairs > 1 {
-		if err := createNewPairOfKeys(k); err != nil {
-			GetLogInstance().Error(err, "Unable to create new keys", "KeyObtainInfo", k)
-			r.Recorder.Eventf(k.TangServer, nil, "Error", "NewKeys", "NewKeys", "Unable to create new pair of keys")
-		} else {
-			GetLogInstance().Info("New Active Keys Created", "KeyObtainInfo", k, "Active Keys",
-				len(k.TangServer.Status.ActiveKeys), "Required Active Keys", requiredActiveKeyPairs)
-			r.Recorder.Eventf(k.TangServer, nil, "Normal", "NewKeys", "NewKeys", "Created %d active pair of keys",
-				len(k.TangServer.Status.ActiveKeys))
-			return true
+// updateKeyPairs reports the key pairs in status, records them in the key status file and reports the keys
+// that can not be reliably paired
+func (r *TangServerReconciler) updateKeyPairs(ctx context.Context, k KeyObtainInfo, inventory []KeyMetadata) {
+	l := log.FromContext(ctx)
+	pairing := pairKeys(inventory)
+	k.TangServer.Status.KeyPairs = keyPairsStatus(pairing.Pairs)
+	if err := writeKeyPairs(ctx, k, pairing); err != nil {
+		l.Error(err, "Unable to write key pairs", "podname", k.PodName, "namespace", k.Namespace)
+	}
+	if k.TangServer.Spec.HiddenKeyRetention != nil {
+		setPurgeTimes(k.TangServer.Status.KeyPairs, hiddenKeyPurgeTimes(k.TangServer, pairing.Pairs, recordedKeyPairs(ctx, k)))
+	}
+	r.updateHiddenKeysMigration(ctx, k, pairing.Pairs)
+	switch {
+	case len(pairing.Ambiguous) > 0:
+		message := "Keys with more than one possible pair, not recorded: " + strings.Join(keyFileNames(pairing.Ambiguous), ", ")
+		if setCondition(k.TangServer, daemonsv1alpha1.ConditionKeysPaired, metav1.ConditionFalse, daemonsv1alpha1.ReasonAmbiguousKeyPairs, message) {
+			r.Recorder.Eventf(k.TangServer, nil, "Warning", "AmbiguousKeyPairs", "AmbiguousKeyPairs", "%s", message)
 		}
+	case len(pairing.Unpaired) > 0:
+		setCondition(k.TangServer, daemonsv1alpha1.ConditionKeysPaired, metav1.ConditionFalse, daemonsv1alpha1.ReasonUnpairedKeys,
+			"Keys without pair: "+strings.Join(keyFileNames(pairing.Unpaired), ", "))
+	default:
+		setCondition(k.TangServer, daemonsv1alpha1.ConditionKeysPaired, metav1.ConditionTrue, daemonsv1alpha1.ReasonKeysPaired,
+			fmt.Sprintf("%d key pairs", len(pairing.Pairs)))
 	}
-	return false
 }
 
 // reconcileDeployment creates deployment appropriate for this CR
-func (r *TangServerReconciler) reconcileDeployment(cr *daemonsv1alpha1.TangServer) (ctrl.Result, error) {
+func (r *TangServerReconciler) reconcileDeployment(ctx context.Context, cr *daemonsv1alpha1.TangServer) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
 	// Define a new Deployment object
-	GetLogInstance().Info("reconcileDeployment")
+	l.Info("reconcileDeployment")
 	deployment := getDeployment(cr)
 
 	// Set tangserver instance as the owner and controller of the Deployment
 	if err := ctrl.SetControllerReference(cr, deployment, r.Scheme); err != nil {
-		cr.Status.TangServerError = daemonsv1alpha1.CreateError
+		setDegraded(cr, daemonsv1alpha1.ReasonDeploymentCreateFailed, err.Error())
 		return ctrl.Result{}, err
 	}
 
 	// Check if this Deployment already exists
 	deploymentFound := &appsv1.Deployment{}
-	err := r.Get(context.Background(), types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, deploymentFound)
+	err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, deploymentFound)
 	if err != nil && errors.IsNotFound(err) {
-		GetLogInstance().Info("Creating a new Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
-		err = r.Create(context.Background(), deployment)
+		l.Info("Creating a new Deployment", "Deployment.Namespace", deployment.Namespace, "Deployment.Name", deployment.Name)
+		err = r.Create(ctx, deployment)
 		if err != nil {
-			cr.Status.TangServerError = daemonsv1alpha1.CreateError
+			setDegraded(cr, daemonsv1alpha1.ReasonDeploymentCreateFailed, err.Error())
 			return ctrl.Result{}, err
 		}
+		setCondition(cr, daemonsv1alpha1.ConditionProgressing, metav1.ConditionTrue,
+			daemonsv1alpha1.ReasonDeploymentCreated, "Deployment "+deployment.Name+" created")
+		setCondition(cr, daemonsv1alpha1.ConditionAvailable, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonDeploymentNotReady, "Deployment "+deployment.Name+" created, waiting for replicas")
+		if err = r.Client.Status().Update(ctx, cr); err != nil {
+			l.Error(err, "Unable to update TangServer status after Deployment creation")
+		}
 		// Requeue the object to update its status
 		return ctrl.Result{Requeue: true}, nil
 	} else if err != nil {
-		cr.Status.TangServerError = daemonsv1alpha1.CreateError
+		setDegraded(cr, daemonsv1alpha1.ReasonDeploymentCreateFailed, err.Error())
 		return ctrl.Result{}, err
 	} else {
 		// Deployment already exists
-		GetLogInstance().Info("Deployment already exists", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
-		GetLogInstance().Info("Checking redeployment")
+		l.Info("Deployment already exists", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		l.Info("Checking redeployment")
 		// Check if it is needed to be redeployed
 		if mustRedeploy(deployment, deploymentFound) {
-			GetLogInstance().Info("Updating deployment, must redeploy")
-			err = r.Update(context.Background(), deployment)
+			l.Info("Updating deployment, must redeploy")
+			deploymentFound.Spec.Template = deployment.Spec.Template
+			err = r.Update(ctx, deploymentFound)
 			if err != nil {
-				GetLogInstance().Error(err, "Failed to redeploy", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+				l.Error(err, "Failed to redeploy", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
 				r.Recorder.Eventf(cr, nil, "Error", "Redeploy", "Redeploy", "Failed to redeploy")
+				setDegraded(cr, daemonsv1alpha1.ReasonDeploymentUpdateFailed, err.Error())
 				return ctrl.Result{}, err
 			}
 		}
 	}
 
-	// Ensure deployment replicas match the desired state
+	// Ensure deployment replicas match the desired state. TangServer is the only source of truth for replicas,
+	// so any change performed directly on the deployment is reverted
 	if !reflect.DeepEqual(deploymentFound.Spec.Replicas, deployment.Spec.Replicas) {
-		GetLogInstance().Info("Current deployment do not match Tang Server configured Replicas")
+		l.Info("Current deployment do not match Tang Server configured Replicas")
 		// Update the replicas
-		err = r.Update(context.Background(), deployment)
+		deploymentFound.Spec.Replicas = deployment.Spec.Replicas
+		err = r.Update(ctx, deploymentFound)
 		if err != nil {
-			GetLogInstance().Error(err, "Failed to update Deployment.", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+			l.Error(err, "Failed to update Deployment.", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
 			r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Failed to update deployment, name:%s, namespace:%s", deploymentFound.Name, deploymentFound.Namespace)
+			setDegraded(cr, daemonsv1alpha1.ReasonDeploymentUpdateFailed, err.Error())
 			return ctrl.Result{}, err
 		}
 	}
 
 	// Ensure deployment container image match the desired state, returns true if deployment needs to be updated
 	if checkDeploymentImage(deploymentFound, deployment) {
-		GetLogInstance().Info("Current deployment image version do not match TangServers configured version")
+		l.Info("Current deployment image version do not match TangServers configured version")
 		// Update the image
-		err = r.Update(context.Background(), deployment)
+		deploymentFound.Spec.Template = deployment.Spec.Template
+		err = r.Update(ctx, deploymentFound)
 		if err != nil {
-			GetLogInstance().Error(err, "Failed to update Deployment", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+			l.Error(err, "Failed to update Deployment", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
 			r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Failed to update deployment, name:%s, namespace:%s", deploymentFound.Name, deploymentFound.Namespace)
+			setDegraded(cr, daemonsv1alpha1.ReasonDeploymentUpdateFailed, err.Error())
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Check if the deployment is ready and update replicas as they get ready
+	// Create list options to get deployment pods, reported in status and required for keys
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.InNamespace(deploymentFound.Namespace),
+		client.MatchingLabels{TANGSERVER_POD_LABEL: cr.Name},
+	}
+	// List the pods for this deployment
+	err = r.List(ctx, podList, listOpts...)
+	if err != nil {
+		l.Error(err, "Failed to list Pods", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		r.Recorder.Eventf(cr, nil, "Error", "PodList", "PodList", "Failed to list pods in deployment, name:%s, namespace:%s", deploymentFound.Name, deploymentFound.Namespace)
+		setDegraded(cr, daemonsv1alpha1.ReasonPodListFailed, "Failed to list pods in deployment "+deploymentFound.Name)
+		return ctrl.Result{}, err
+	}
+
+	// Check if the deployment is ready and update replicas from the state of the pods
 	deploymentReady := isDeploymentReady(deploymentFound)
 	ready := getDeploymentReadyReplicas(deploymentFound)
-	GetLogInstance().Info("Deployment Found Info", "Replicas", deploymentFound.Status.Replicas, "Ready", deploymentFound.Status.ReadyReplicas)
-	GetLogInstance().Info("Updating status with ready/running replicas", "Ready", ready, "Running", cr.Spec.Replicas, "DeploymentReady", deploymentReady)
-	cr.Status.Running = cr.Spec.Replicas
-	cr.Status.Ready = ready
+	l.Info("Deployment Found Info", "Replicas", deploymentFound.Status.Replicas, "Ready", deploymentFound.Status.ReadyReplicas)
+	r.updatePodStatus(cr, podList.Items)
+	l.Info("Updating status with ready/running replicas", "Ready", cr.Status.Ready, "Running", cr.Status.Running, "DeploymentReady", deploymentReady)
+	cr.Status.Selector = getDeploymentSelector(cr)
+	// Deployment is in place, clear errors reported on previous reconciliations by this stage
+	clearDegraded(cr, daemonsv1alpha1.ReasonDeploymentCreateFailed, daemonsv1alpha1.ReasonDeploymentUpdateFailed,
+		daemonsv1alpha1.ReasonPodListFailed, daemonsv1alpha1.ReasonKeyRotationFailed, daemonsv1alpha1.ReasonHiddenKeysDeletionFailed,
+		daemonsv1alpha1.ReasonKeyManagementTimeout, daemonsv1alpha1.ReasonInvalidKeyPath, daemonsv1alpha1.ReasonKeyLockFailed,
+		daemonsv1alpha1.ReasonInvalidKeyRotationPolicy)
 	if !deploymentReady {
-		GetLogInstance().Info("Deployment not ready", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		l.Info("Deployment not ready", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		message := fmt.Sprintf("%d/%d replicas ready", ready, deploymentFound.Status.Replicas)
+		setCondition(cr, daemonsv1alpha1.ConditionAvailable, metav1.ConditionFalse, daemonsv1alpha1.ReasonDeploymentNotReady, message)
+		setCondition(cr, daemonsv1alpha1.ConditionProgressing, metav1.ConditionTrue, daemonsv1alpha1.ReasonDeploymentNotReady, message)
 	} else {
-		// Create list options to get deployment pods and extract podname
-		podList := &corev1.PodList{}
-		listOpts := []client.ListOption{
-			client.InNamespace(deploymentFound.Namespace),
-			client.MatchingLabels(deploymentFound.Labels),
-		}
-		// List the pods for this deployment
-		err = r.List(context.Background(), podList, listOpts...)
-		if err != nil || len(podList.Items) == 0 {
-			GetLogInstance().Error(err, "Failed to list Pods, required for keys", "Deployment.Namespace",
-				deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		message := fmt.Sprintf("%d/%d replicas ready", ready, deploymentFound.Status.Replicas)
+		setCondition(cr, daemonsv1alpha1.ConditionAvailable, metav1.ConditionTrue, daemonsv1alpha1.ReasonDeploymentReady, message)
+		setCondition(cr, daemonsv1alpha1.ConditionProgressing, metav1.ConditionFalse, daemonsv1alpha1.ReasonDeploymentReady, message)
+		if len(podList.Items) == 0 {
+			l.Info("No Pods found, required for keys", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
 			r.Recorder.Eventf(cr, nil, "Error", "PodList", "PodList", "Failed to list pods in deployment, name:%s, namespace:%s", deploymentFound.Name, deploymentFound.Namespace)
-			return ctrl.Result{}, err
+			setDegraded(cr, daemonsv1alpha1.ReasonPodListFailed, "Failed to list pods in deployment "+deploymentFound.Name)
+			return ctrl.Result{}, nil
+		}
+		l.Info("Deployment ready", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
+		// Keys are managed on the oldest ready replica, the other ones are compared with it
+		replicas := readyPods(podList.Items)
+		authoritative := podList.Items[0].Name
+		if len(replicas) > 0 {
+			authoritative = replicas[0].Name
 		}
-		GetLogInstance().Info("Deployment ready", "Deployment.Namespace", deploymentFound.Namespace, "Deployment.Name", deploymentFound.Name)
 		k := KeyObtainInfo{
-			PodName:    podList.Items[0].Name,
-			Namespace:  deploymentFound.Namespace,
-			DbPath:     getDefaultKeyPath(cr),
-			TangServer: cr,
+			PodName:     authoritative,
+			Namespace:   deploymentFound.Namespace,
+			DbPath:      getDefaultKeyPath(cr),
+			TangServer:  cr,
+			Executor:    r.Executor,
+			ExecTimeout: r.getExecTimeout(),
+			stats:       &execStats{},
+			inventory:   &r.keyInventory,
 		}
-		if cr.Spec.HiddenKeys == nil {
-			GetLogInstance().Info("No hidden keys specified")
-		} else if len(cr.Spec.HiddenKeys) == 0 {
-			GetLogInstance().Info("Hidden keys specified with len 0, deleting all hidden keys")
-			if deleteAllHiddenKeys(k) {
-				r.Recorder.Eventf(cr, nil, "Normal", "HiddenKeysDeletion", "HiddenKeysDeletion", "Hidden keys deleted correctly")
-			} else {
-				r.Recorder.Eventf(cr, nil, "Error", "HiddenKeysDeletion", "HiddenKeysDeletion", "Hidden keys not deleted correctly")
-			}
-		} else if len(cr.Spec.HiddenKeys) > 0 {
-			rotated := r.handleHiddenKeys(k)
-			if rotated {
-				GetLogInstance().Info("Key(s) rotated", "Keys", cr.Spec.HiddenKeys)
-				// if keys are rotated, set the counter of active keys retries to zero
-				// just in case no active keys exist
-				activeKeyRetries = 0
-			} else {
-				GetLogInstance().Info("Key(s) not rotated", "Keys", cr.Spec.HiddenKeys)
-			}
+		// Key directory mutations are serialised across operator instances
+		locked, err := r.acquireKeyLock(ctx, cr)
+		if err != nil {
+			l.Error(err, "Unable to acquire key lock", "Lease", keyLockLeaseName(cr))
+			setDegraded(cr, daemonsv1alpha1.ReasonKeyLockFailed, "Unable to acquire key lock "+keyLockLeaseName(cr))
+			return ctrl.Result{}, err
+		}
+		if locked {
+			r.manageLockedKeys(ctx, k, replicas)
+			r.releaseKeyLock(ctx, cr)
 		}
-		r.UpdateKeys(k)
 	}
-	err = r.Client.Status().Update(context.Background(), cr)
+	setServedKeys(cr)
+	cr.Status.ObservedGeneration = cr.Generation
+	err = r.Client.Status().Update(ctx, cr)
 	if err != nil {
-		GetLogInstance().Error(err, "Unable to update TangServer status")
+		l.Error(err, "Unable to update TangServer status")
 		r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Unable to update TangServer status")
 		return ctrl.Result{}, err
 	}
 	return ctrl.Result{}, nil
 }
 
-func (r *TangServerReconciler) reconcileService(cr *daemonsv1alpha1.TangServer) (ctrl.Result, error) {
-	GetLogInstance().Info("reconcileService")
+// manageLockedKeys performs the key management of a reconciliation on the pod provided and then compares the keys
+// of the other replicas with it, while the key lock is held. Both share the key management budget, so the lock is
+// released before its Lease expires. Commands aborted by a timeout are reported through the Degraded condition
+func (r *TangServerReconciler) manageLockedKeys(ctx context.Context, k KeyObtainInfo, replicas []corev1.Pod) {
+	l := log.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, r.getKeyManagementTimeout())
+	defer cancel()
+	defer k.stats.observe()
+	r.manageKeys(ctx, k)
+	r.reconcileReplicaKeys(ctx, k, replicas)
+	if k.timedOut() || ctx.Err() != nil {
+		l.Info("Key management timed out", "Exec Timeout", k.ExecTimeout, "Key Management Timeout", r.getKeyManagementTimeout())
+		r.Recorder.Eventf(k.TangServer, nil, "Warning", "KeyManagementTimeout", "KeyManagementTimeout", "Key management timed out in pod %s", k.PodName)
+		setDegraded(k.TangServer, daemonsv1alpha1.ReasonKeyManagementTimeout,
+			fmt.Sprintf("Key management timed out in pod %s", k.PodName))
+	}
+}
+
+// manageKeys performs the key management of a reconciliation on the pod provided, until the context is done
+func (r *TangServerReconciler) manageKeys(ctx context.Context, k KeyObtainInfo) {
+	l := log.FromContext(ctx)
+	if !isValidKeyPath(k.DbPath) {
+		l.Info("Invalid key path, keys not managed", "Key Path", k.DbPath)
+		setDegraded(k.TangServer, daemonsv1alpha1.ReasonInvalidKeyPath,
+			fmt.Sprintf("Key path %q must match %s and must not contain '..'", k.DbPath, daemonsv1alpha1.KeyPathPattern))
+		return
+	}
+	recovered := r.recoverRotation(ctx, k)
+	if !recovered {
+		// No rotation is started while a previous one is pending
+		l.Info("Interrupted rotation pending, hidden keys not handled")
+	} else if len(k.TangServer.Spec.HiddenKeys) == 0 {
+		l.Info("No hidden keys specified")
+	} else {
+		rotated := r.handleHiddenKeys(ctx, k)
+		if rotated {
+			l.Info("Key(s) rotated", "Keys", k.TangServer.Spec.HiddenKeys)
+			// if keys are rotated, set the counter of active keys retries to zero
+			// just in case no active keys exist
+			r.activeKeyRetries.reset(client.ObjectKeyFromObject(k.TangServer))
+		} else {
+			l.Info("Key(s) not rotated", "Keys", k.TangServer.Spec.HiddenKeys)
+		}
+	}
+	if recovered {
+		r.rotateAgedKeys(ctx, k)
+		r.purgeHiddenKeys(ctx, k)
+	}
+	r.UpdateKeys(ctx, k)
+}
+
+func (r *TangServerReconciler) reconcileService(ctx context.Context, cr *daemonsv1alpha1.TangServer) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	l.Info("reconcileService")
 	service := getService(cr)
 
 	// Set TangServer instance as the owner and controller of the Service
 	if err := controllerutil.SetControllerReference(cr, service, r.Scheme); err != nil {
+		setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
+		setDegraded(cr, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
 		return ctrl.Result{}, err
 	}
 
 	// Check if this Service already exists
 	serviceFound := &corev1.Service{}
-	err := r.Get(context.Background(), types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, serviceFound)
+	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, serviceFound)
 	if err != nil && errors.IsNotFound(err) {
-		GetLogInstance().Info("Creating a new Service", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
-		err = r.Create(context.Background(), service)
+		l.Info("Creating a new Service", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
+		err = r.Create(ctx, service)
 		if err != nil {
+			setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
+			setDegraded(cr, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
 			return ctrl.Result{}, err
 		}
+		setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonServiceCreated,
+			"Service "+service.Name+" created")
+		if err = r.Client.Status().Update(ctx, cr); err != nil {
+			l.Error(err, "Unable to update TangServer status after Service creation")
+		}
 		// Service created successfully - don't requeue
 		return ctrl.Result{}, nil
 	} else if err != nil {
-		GetLogInstance().Error(err, "Error on service Get")
+		l.Error(err, "Error on service Get")
 		r.Recorder.Eventf(cr, nil, "Error", "Service", "Service", "Error getting service: name:%s, namespace:%s", service.Name, service.Namespace)
+		setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
+		setDegraded(cr, daemonsv1alpha1.ReasonServiceReconcileFailed, err.Error())
 		return ctrl.Result{}, err
 	} else {
 		// Service already exists
-		GetLogInstance().Info("Service already exists", "Service.Namespace", serviceFound.Namespace, "Service.Name", serviceFound.Name)
+		l.Info("Service already exists", "Service.Namespace", serviceFound.Namespace, "Service.Name", serviceFound.Name)
+		changed := clearDegraded(cr, daemonsv1alpha1.ReasonServiceReconcileFailed)
 		if len(serviceFound.Status.LoadBalancer.Ingress) > 0 {
-			GetLogInstance().Info("Service Information", "Load Balancer IP", serviceFound.Status.LoadBalancer.Ingress[0].IP, "Load Balancer Hostname", serviceFound.Status.LoadBalancer.Ingress[0].Hostname)
+			l.Info("Service Information", "Load Balancer IP", serviceFound.Status.LoadBalancer.Ingress[0].IP, "Load Balancer Hostname", serviceFound.Status.LoadBalancer.Ingress[0].Hostname)
 			cr.Status.ServiceExternalURL = getExternalServiceURL(cr, serviceFound.Status.LoadBalancer.Ingress[0])
-			err := r.Client.Status().Update(context.Background(), cr)
+			setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionTrue, daemonsv1alpha1.ReasonServiceReady,
+				"Service available at "+cr.Status.ServiceExternalURL)
+			changed = true
+		} else if serviceFound.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			l.Info("Service Information, NO Ingress")
+			changed = setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonLoadBalancerPending,
+				"Waiting for load balancer ingress") || changed
+		} else {
+			l.Info("Service Information, NO Ingress")
+			changed = setCondition(cr, daemonsv1alpha1.ConditionServiceReady, metav1.ConditionTrue, daemonsv1alpha1.ReasonServiceReady,
+				"Service available at "+getServiceURL(cr)) || changed
+		}
+		if changed {
+			err := r.Client.Status().Update(ctx, cr)
 			if err != nil {
-				GetLogInstance().Error(err, "Unable to update TangServer status with Service IP URL")
-				r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Unable to update TangServer status with Service IP URL")
+				l.Error(err, "Unable to update TangServer status with Service information")
+				r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Unable to update TangServer status with Service information")
 				return ctrl.Result{}, err
 			}
-		} else {
-			GetLogInstance().Info("Service Information, NO Ingress")
 		}
-		GetLogInstance().Info("Service Spec", "Spec", serviceFound.Spec)
-		GetLogInstance().Info("Service Status", "Status", serviceFound.Status)
+		l.Info("Service Spec", "Spec", serviceFound.Spec)
+		l.Info("Service Status", "Status", serviceFound.Status)
 	}
 	// Service reconcile finished
 	return ctrl.Result{}, nil
 }
 
-func (r *TangServerReconciler) reconcilePeriodic(cr *daemonsv1alpha1.TangServer) (ctrl.Result, bool) {
+func (r *TangServerReconciler) reconcilePeriodic(ctx context.Context, cr *daemonsv1alpha1.TangServer) (ctrl.Result, bool) {
+	l := log.FromContext(ctx)
+	changed := setKeysReady(cr)
+	if isConditionTrue(cr, daemonsv1alpha1.ConditionKeysLocked) {
+		l.Info("Key directory locked, rechecking keys", "Recheck", DEFAULT_RECONCILE_TIMER_KEYS_LOCKED)
+		if changed {
+			if err := r.Client.Status().Update(ctx, cr); err != nil {
+				l.Error(err, "Unable to update TangServer status with key readiness")
+			}
+		}
+		return ctrl.Result{RequeueAfter: time.Duration(DEFAULT_RECONCILE_TIMER_KEYS_LOCKED) * time.Second}, true
+	}
 	if cr.Spec.KeyRefreshInterval != 0 {
-		GetLogInstance().Info("Key reconciliation non zero", "Refresh Interval", cr.Spec.KeyRefreshInterval)
-		return ctrl.Result{RequeueAfter: time.Duration(cr.Spec.KeyRefreshInterval) * time.Second}, true
+		l.Info("Key reconciliation non zero", "Refresh Interval", cr.Spec.KeyRefreshInterval)
+		if changed {
+			if err := r.Client.Status().Update(ctx, cr); err != nil {
+				l.Error(err, "Unable to update TangServer status with key readiness")
+			}
+		}
+		requeueAfter := time.Duration(cr.Spec.KeyRefreshInterval) * time.Second
+		if until := untilScheduledKeyChange(cr, r.now()); until > 0 && until < requeueAfter {
+			requeueAfter = until
+		}
+		return ctrl.Result{RequeueAfter: requeueAfter}, true
 	} else if len(cr.Status.ActiveKeys) == 0 {
-		activeKeyRetries = activeKeyRetries + 1
-		cr.Status.TangServerError = daemonsv1alpha1.ActiveKeysError
-		GetLogInstance().Info("Retrying key retrieval", "Retries:", fmt.Sprint(activeKeyRetries))
-		r.Recorder.Eventf(cr, nil, "Normal", "ActiveKeyRetrieval", "ActiveKeyRetrieval", "Empty Active Key List Retries: %d", activeKeyRetries)
-		err := r.Client.Status().Update(context.Background(), cr)
+		retries := r.activeKeyRetries.increment(client.ObjectKeyFromObject(cr))
+		l.Info("Retrying key retrieval", "Retries:", fmt.Sprint(retries))
+		r.Recorder.Eventf(cr, nil, "Normal", "ActiveKeyRetrieval", "ActiveKeyRetrieval", "Empty Active Key List Retries: %d", retries)
+		err := r.Client.Status().Update(ctx, cr)
 		if err != nil {
-			GetLogInstance().Error(err, "Unable to update TangServer status with active key retries and error")
+			l.Error(err, "Unable to update TangServer status with active key retries and error")
 			r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Unable to update TangServer status clearing active key retries and error")
 		}
 		return ctrl.Result{RequeueAfter: time.Duration(DEFAULT_RECONCILE_TIMER_NO_ACTIVE_KEYS) * time.Second}, true
 	} else {
-		activeKeyRetries = 0
-		cr.Status.TangServerError = daemonsv1alpha1.NoError
-		err := r.Client.Status().Update(context.Background(), cr)
+		r.activeKeyRetries.reset(client.ObjectKeyFromObject(cr))
+		err := r.Client.Status().Update(ctx, cr)
 		if err != nil {
-			GetLogInstance().Error(err, "Unable to update TangServer status clearing active key retries and error")
+			l.Error(err, "Unable to update TangServer status clearing active key retries and error")
 			r.Recorder.Eventf(cr, nil, "Error", "Update", "Update", "Unable to update TangServer status clearing active key retries and error")
 		}
+		until := untilScheduledKeyChange(cr, r.now())
+		if !isConditionTrue(cr, daemonsv1alpha1.ConditionKeysReady) {
+			converging := time.Duration(DEFAULT_RECONCILE_TIMER_ACTIVE_KEYS_CONVERGING) * time.Second
+			if until == 0 || converging < until {
+				until = converging
+			}
+		}
+		if until > 0 {
+			l.Info("Rechecking keys on next scheduled key change", "Recheck", until)
+			return ctrl.Result{RequeueAfter: until}, true
+		}
 	}
 	return ctrl.Result{}, false
 }
@@ -540,8 +830,16 @@ func (r *TangServerReconciler) reconcilePeriodic(cr *daemonsv1alpha1.TangServer)
 // SetupWithManager sets up the controller with the Manager.
 func (r *TangServerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&daemonsv1alpha1.TangServer{}).
-		Owns(&appsv1.Deployment{}).
-		Owns(&corev1.Service{}).
+		For(&daemonsv1alpha1.TangServer{}, builder.WithPredicates(tangServerChanged)).
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(deploymentChanged)).
+		Owns(&corev1.Service{}, builder.WithPredicates(serviceChanged)).
+		// Tang pods are owned by the replica sets of the deployment, so they are mapped through the operator label
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.podToTangServer), builder.WithPredicates(podChanged)).
+		// Only the metadata of persistent volume claims and secrets is cached, their content is not required. Any
+		// update of a referenced claim is acted on, as its phase is not part of its metadata. None of them is ever
+		// read, so only their list and watch are granted
+		WatchesMetadata(&corev1.PersistentVolumeClaim{}, handler.EnqueueRequestsFromMapFunc(r.persistentVolumeClaimToTangServers)).
+		WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.secretToTangServers)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
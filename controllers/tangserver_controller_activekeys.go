@@ -0,0 +1,127 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Recheck of keys when the active key pairs could not converge to the required ones
+const DEFAULT_RECONCILE_TIMER_ACTIVE_KEYS_CONVERGING = 30 // seconds
+
+// Reason recorded for key pairs hidden to lower the active key pairs to the required ones
+const KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS_REASON = "Active key pairs above required active key pairs"
+
+// convergeActiveKeys generates or hides key pairs until the active key pairs are the required ones. Key pairs
+// are only generated for more than one required active key pair, as the tang server creates the first one, and
+// only hidden when the required active key pairs are specified. The oldest key pairs are hidden first. It
+// returns true if the active key pairs changed
+func (r *TangServerReconciler) convergeActiveKeys(ctx context.Context, k KeyObtainInfo) bool {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	required := int(getRequiredActiveKeyPairs(cr))
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		l.Error(err, "Unable to read keys, active keys not converged", "podname", k.PodName, "namespace", k.Namespace)
+		return false
+	}
+	active := len(activeKeysFromInventory(ctx, k, inventory, ONLY_ADVERTISED))
+	l.Info("Converging active keys", "Active Keys", active, "Required Active Keys", required)
+	changed := false
+	for required > 1 && active < required {
+		pair, err := generateKeyPair(ctx, k, inventory)
+		if err != nil {
+			l.Error(err, "Unable to create new keys", "Active Keys", active, "Required Active Keys", required)
+			r.Recorder.Eventf(cr, nil, "Warning", "NewKeys", "NewKeys", "Unable to create new pair of keys")
+			return changed
+		}
+		changed = true
+		active++
+		r.Recorder.Eventf(cr, nil, "Normal", "NewKeys", "NewKeys", "Created active pair of keys, Key File: %s (%d/%d)",
+			pair.Signing.File.Name, active, required)
+		if inventory, err = readKeyInventory(ctx, k); err != nil {
+			l.Error(err, "Unable to read keys, active keys not converged", "podname", k.PodName, "namespace", k.Namespace)
+			return changed
+		}
+	}
+	if cr.Spec.RequiredActiveKeyPairs == 0 || active <= required {
+		return changed
+	}
+	pairs := make([]KeyPair, 0)
+	for _, p := range pairKeys(inventory).Pairs {
+		if !p.Hidden() {
+			pairs = append(pairs, p)
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Signing.File.ModTime.Before(pairs[j].Signing.File.ModTime)
+	})
+	for _, p := range pairs {
+		if active <= required {
+			break
+		}
+		keyFileName := p.Signing.File.Name
+		l.Info("Active key pair above required active key pairs must be hidden", "Key File", keyFileName,
+			"Active Keys", active, "Required Active Keys", required)
+		r.startRotation(ctx, cr, keyFileName)
+		if err := hideKeyPair(ctx, k, keyFileName, nil, KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS, KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS_REASON); err != nil {
+			l.Error(err, "Active key pair above required active key pairs not hidden", "Key File", keyFileName)
+			r.Recorder.Eventf(cr, nil, "Warning", "KeyRotation", "KeyRotation",
+				"Key above %d required active key pairs NOT Rotated Correctly, Key File: %s", required, keyFileName)
+			setDegraded(cr, daemonsv1alpha1.ReasonKeyRotationFailed, fmt.Sprintf("Unable to rotate key file %s", keyFileName))
+			setCondition(cr, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+				daemonsv1alpha1.ReasonKeyRotationFailed, "Last key rotation failed")
+			return changed
+		}
+		changed = true
+		active--
+		r.Recorder.Eventf(cr, nil, "Normal", "KeyRotation", "KeyRotation",
+			"Key above %d required active key pairs Rotated Correctly, Key File: %s", required, keyFileName)
+	}
+	if changed {
+		setCondition(cr, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationSucceeded, "Last key rotation completed")
+	}
+	if active > required {
+		l.Info("Active keys without a reliable pair not hidden", "Active Keys", active, "Required Active Keys", required)
+	}
+	return changed
+}
+
+// setKeysReady reports in the KeysReady condition whether the tang server has active keys, and the progress of
+// the active keys towards the required active key pairs when specified. It returns true if the condition changed
+func setKeysReady(cr *daemonsv1alpha1.TangServer) bool {
+	active := len(cr.Status.ActiveKeys)
+	required := int(getRequiredActiveKeyPairs(cr))
+	switch {
+	case active == 0:
+		return setCondition(cr, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonNoActiveKeys,
+			"No active keys read from Tang Server")
+	case cr.Spec.RequiredActiveKeyPairs > 0 && active != required:
+		return setCondition(cr, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionFalse, daemonsv1alpha1.ReasonActiveKeysConverging,
+			fmt.Sprintf("%d active keys available, %d required", active, required))
+	default:
+		return setCondition(cr, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionTrue, daemonsv1alpha1.ReasonActiveKeysAvailable,
+			fmt.Sprintf("%d active keys available", active))
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("TangServer controller active key convergence", func() {
+	const keyPath = "/var/db/tang"
+	var (
+		executor   *fakePodExecutor
+		keyInfo    KeyObtainInfo
+		reconciler *TangServerReconciler
+		// oldest is the signing key file of the first key pair generated
+		oldest string
+	)
+	ctx := context.Background()
+
+	// activeKeys returns the signing key files of the active key pairs
+	activeKeys := func() []string {
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		var files []string
+		for _, p := range pairKeys(inventory).Pairs {
+			if !p.Hidden() {
+				files = append(files, p.Signing.File.Name)
+			}
+		}
+		return files
+	}
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		keyInfo, reconciler, _ = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{}, executor)
+		oldest, _ = executor.addKeyPair(keyPath)
+	})
+
+	It("Should generate every missing active key pair in one pass", func() {
+		keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 4
+		Expect(reconciler.convergeActiveKeys(ctx, keyInfo)).To(BeTrue())
+		Expect(activeKeys()).To(HaveLen(4))
+		Expect(reconciler.convergeActiveKeys(ctx, keyInfo)).To(BeFalse())
+	})
+
+	It("Should hide the oldest active key pairs above the required ones", func() {
+		for i := 0; i < 2; i++ {
+			executor.addKeyPair(keyPath)
+		}
+		keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 1
+		Expect(reconciler.convergeActiveKeys(ctx, keyInfo)).To(BeTrue())
+		active := activeKeys()
+		Expect(active).To(HaveLen(1))
+		Expect(active).ToNot(ContainElement(oldest))
+		Expect(executor.entries(keyPath)).To(ContainElement("." + oldest))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionRotationInProgress)).To(BeFalse())
+
+		keyStatus, err := readKeyStatus(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		for _, assoc := range keyStatus.KeyStatusSha256Map {
+			if assoc.Hidden != nil {
+				Expect(assoc.RotatedBy).To(Equal(KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS))
+			}
+		}
+	})
+
+	It("Should not hide active key pairs without required active key pairs", func() {
+		executor.addKeyPair(keyPath)
+		Expect(reconciler.convergeActiveKeys(ctx, keyInfo)).To(BeFalse())
+		Expect(activeKeys()).To(HaveLen(2))
+	})
+
+	It("Should keep the active key pairs and report when they can not be hidden", func() {
+		executor.addKeyPair(keyPath)
+		keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 1
+		executor.failOn = []string{"mv "}
+		reconciler.convergeActiveKeys(ctx, keyInfo)
+		Expect(activeKeys()).To(HaveLen(2))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+	})
+
+	It("Should resume interrupted hidings while the required active key pairs are specified", func() {
+		j := &RotationJournal{RotatedBy: KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS}
+		Expect(hidingRequested(keyInfo, j)).To(BeFalse())
+		keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 1
+		Expect(hidingRequested(keyInfo, j)).To(BeTrue())
+	})
+
+	Context("When reporting key readiness", func() {
+		It("Should report the progress towards the required active key pairs", func() {
+			keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 3
+			keyInfo.TangServer.Status.ActiveKeys = []daemonsv1alpha1.TangServerActiveKeys{{FileName: oldest}}
+			Expect(setKeysReady(keyInfo.TangServer)).To(BeTrue())
+			condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysReady)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(daemonsv1alpha1.ReasonActiveKeysConverging))
+			Expect(condition.Message).To(Equal("1 active keys available, 3 required"))
+			Expect(keyInfo.TangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.NoError))
+
+			result, requeue := reconciler.reconcilePeriodic(ctx, keyInfo.TangServer)
+			Expect(requeue).To(BeTrue())
+			Expect(result.RequeueAfter).To(Equal(time.Duration(DEFAULT_RECONCILE_TIMER_ACTIVE_KEYS_CONVERGING) * time.Second))
+
+			reconciler.UpdateKeys(ctx, keyInfo)
+			setKeysReady(keyInfo.TangServer)
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionKeysReady)).To(BeTrue())
+		})
+	})
+})
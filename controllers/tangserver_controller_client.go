@@ -17,20 +17,13 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
-	core_v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/remotecommand"
 )
 
 // GetClusterClientConfig first tries to get a config object which uses the service account kubernetes gives to pods,
@@ -87,70 +80,3 @@ func GetRESTClient() (*rest.RESTClient, error) {
 
 	return rest.RESTClientFor(config)
 }
-
-// podCommandExec uninterractively exec to the pod with the command specified.
-// :param string command: list of the str which specify the command.
-// :param string pod_name: Pod name
-// :param string namespace: namespace of the Pod.
-// :param io.Reader stdin: Standard Input if necessary, otherwise `nil`
-// :return: string: Output of the command. (STDOUT)
-//
-//	string: Errors. (STDERR)
-//	 error: If any error has occurred otherwise `nil`
-func podCommandExec(command, containerName, podName, namespace string, stdin io.Reader) (string, string, error) {
-	config, err := GetClusterClientConfig()
-	if err != nil {
-		return "", "", err
-	}
-	if config == nil {
-		err = fmt.Errorf("nil config")
-		return "", "", err
-	}
-
-	clientset, err := GetClientsetFromClusterConfig(config)
-	if err != nil {
-		return "", "", err
-	}
-	if clientset == nil {
-		err = fmt.Errorf("nil clientset")
-		return "", "", err
-	}
-
-	req := clientset.CoreV1().RESTClient().Post().
-		Resource("pods").
-		Name(podName).
-		Namespace(namespace).
-		SubResource("exec")
-	scheme := runtime.NewScheme()
-	if err := core_v1.AddToScheme(scheme); err != nil {
-		return "", "", fmt.Errorf("error adding to scheme: %v", err)
-	}
-
-	parameterCodec := runtime.NewParameterCodec(scheme)
-	req.VersionedParams(&core_v1.PodExecOptions{
-		Command:   []string{"/bin/bash", "-c", command},
-		Container: containerName,
-		Stdin:     stdin != nil,
-		Stdout:    true,
-		Stderr:    true,
-		TTY:       false,
-	}, parameterCodec)
-
-	exec, spdyerr := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
-	if spdyerr != nil {
-		return "", "", fmt.Errorf("error while creating Executor: %v, Command: %s", err, strings.Fields(command))
-	}
-
-	var stdout, stderr bytes.Buffer
-	err = exec.StreamWithContext(context.Background(), remotecommand.StreamOptions{
-		Stdin:  stdin,
-		Stdout: &stdout,
-		Stderr: &stderr,
-		Tty:    false,
-	})
-	if err != nil {
-		return "", "", fmt.Errorf("error in Stream: %v, Command: %s", err, strings.Fields(command))
-	}
-
-	return stdout.String(), stderr.String(), nil
-}
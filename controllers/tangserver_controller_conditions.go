@@ -0,0 +1,78 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setCondition sets a condition in the CR status and refreshes the deprecated TangServerError mirror.
+// It returns true if the condition changed
+func setCondition(cr *daemonsv1alpha1.TangServer, condType string, status metav1.ConditionStatus, reason string, message string) bool {
+	changed := meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: cr.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	mirrorTangServerError(cr)
+	return changed
+}
+
+// setDegraded marks the CR as degraded with the reason provided
+func setDegraded(cr *daemonsv1alpha1.TangServer, reason string, message string) bool {
+	return setCondition(cr, daemonsv1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+}
+
+// clearDegraded resets the Degraded condition, but only if it was raised with one of the reasons provided.
+// This way each reconcile stage only clears the errors it reported itself
+func clearDegraded(cr *daemonsv1alpha1.TangServer, reasons ...string) bool {
+	degraded := meta.FindStatusCondition(cr.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+	if degraded != nil && degraded.Status == metav1.ConditionTrue && !contains(reasons, degraded.Reason) {
+		return false
+	}
+	return setCondition(cr, daemonsv1alpha1.ConditionDegraded, metav1.ConditionFalse, daemonsv1alpha1.ReasonAsExpected, "")
+}
+
+// isConditionTrue returns true if the condition is present in the status and its status is true
+func isConditionTrue(cr *daemonsv1alpha1.TangServer, condType string) bool {
+	return meta.IsStatusConditionTrue(cr.Status.Conditions, condType)
+}
+
+// mirrorTangServerError keeps the deprecated TangServerError field in line with the conditions
+func mirrorTangServerError(cr *daemonsv1alpha1.TangServer) {
+	degraded := meta.FindStatusCondition(cr.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+	if degraded != nil && degraded.Status == metav1.ConditionTrue {
+		switch degraded.Reason {
+		case daemonsv1alpha1.ReasonDeploymentCreateFailed:
+			cr.Status.TangServerError = daemonsv1alpha1.CreateError
+			return
+		case daemonsv1alpha1.ReasonKeyRotationFailed:
+			cr.Status.TangServerError = daemonsv1alpha1.ActiveKeyNotFoundError
+			return
+		}
+	}
+	keysReady := meta.FindStatusCondition(cr.Status.Conditions, daemonsv1alpha1.ConditionKeysReady)
+	if keysReady != nil && keysReady.Status == metav1.ConditionFalse && keysReady.Reason == daemonsv1alpha1.ReasonNoActiveKeys {
+		cr.Status.TangServerError = daemonsv1alpha1.ActiveKeysError
+		return
+	}
+	cr.Status.TangServerError = daemonsv1alpha1.NoError
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("TangServer controller conditions", func() {
+	var tangServer *daemonsv1alpha1.TangServer
+
+	BeforeEach(func() {
+		tangServer = &daemonsv1alpha1.TangServer{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "test-tang-conditions",
+				Namespace:  "default",
+				Generation: 3,
+			},
+		}
+	})
+
+	Context("When setting conditions", func() {
+		It("Should store condition with observed generation", func() {
+			changed := setCondition(tangServer, daemonsv1alpha1.ConditionAvailable, metav1.ConditionTrue,
+				daemonsv1alpha1.ReasonDeploymentReady, "1/1 replicas ready")
+			Expect(changed).To(BeTrue())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionAvailable)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonDeploymentReady))
+			Expect(cond.ObservedGeneration).To(Equal(int64(3)))
+			Expect(isConditionTrue(tangServer, daemonsv1alpha1.ConditionAvailable)).To(BeTrue())
+		})
+
+		It("Should report no change when condition is set twice", func() {
+			setCondition(tangServer, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionTrue,
+				daemonsv1alpha1.ReasonActiveKeysAvailable, "1 active keys available")
+			changed := setCondition(tangServer, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionTrue,
+				daemonsv1alpha1.ReasonActiveKeysAvailable, "1 active keys available")
+			Expect(changed).To(BeFalse())
+			Expect(tangServer.Status.Conditions).To(HaveLen(1))
+		})
+	})
+
+	Context("When handling Degraded condition", func() {
+		It("Should only clear Degraded condition raised with the given reasons", func() {
+			setDegraded(tangServer, daemonsv1alpha1.ReasonServiceReconcileFailed, "service error")
+			Expect(clearDegraded(tangServer, daemonsv1alpha1.ReasonDeploymentCreateFailed)).To(BeFalse())
+			Expect(isConditionTrue(tangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+
+			Expect(clearDegraded(tangServer, daemonsv1alpha1.ReasonServiceReconcileFailed)).To(BeTrue())
+			Expect(isConditionTrue(tangServer, daemonsv1alpha1.ConditionDegraded)).To(BeFalse())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonAsExpected))
+		})
+
+		It("Should set Degraded to false when not present", func() {
+			Expect(clearDegraded(tangServer)).To(BeTrue())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		})
+	})
+
+	Context("When mirroring conditions into TangServerError", func() {
+		It("Should report no error by default", func() {
+			mirrorTangServerError(tangServer)
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.NoError))
+		})
+
+		It("Should report creation error", func() {
+			setDegraded(tangServer, daemonsv1alpha1.ReasonDeploymentCreateFailed, "create error")
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.CreateError))
+		})
+
+		It("Should report rotation error", func() {
+			setDegraded(tangServer, daemonsv1alpha1.ReasonKeyRotationFailed, "rotation error")
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.ActiveKeyNotFoundError))
+		})
+
+		It("Should report zero active keys", func() {
+			setCondition(tangServer, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionFalse,
+				daemonsv1alpha1.ReasonNoActiveKeys, "No active keys")
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.ActiveKeysError))
+			setCondition(tangServer, daemonsv1alpha1.ConditionKeysReady, metav1.ConditionTrue,
+				daemonsv1alpha1.ReasonActiveKeysAvailable, "1 active keys available")
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.NoError))
+		})
+	})
+})
@@ -20,6 +20,7 @@ import (
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Constants to use
@@ -33,11 +34,33 @@ func getDefaultName(cr *daemonsv1alpha1.TangServer) string {
 	return DEFAULT_DEPLOYMENT_PREFIX + cr.Name
 }
 
-// getDeployment function returns correctly constructed deployment
-func getDeployment(cr *daemonsv1alpha1.TangServer) *appsv1.Deployment {
-	labels := map[string]string{
+// Label of the tang pods holding the name of their TangServer, the only pods cached by the operator
+const TANGSERVER_POD_LABEL = "nbde.openshift.io/tangserver"
+
+// getDeploymentLabels function returns the labels used by deployment and its pods
+func getDeploymentLabels(cr *daemonsv1alpha1.TangServer) map[string]string {
+	return map[string]string{
 		"app": cr.Name,
 	}
+}
+
+// getPodLabels function returns the labels of the deployment pods. The selector of existing deployments can not
+// be changed, so the operator label is only set on the pods
+func getPodLabels(cr *daemonsv1alpha1.TangServer) map[string]string {
+	labels := getDeploymentLabels(cr)
+	labels[TANGSERVER_POD_LABEL] = cr.Name
+	return labels
+}
+
+// getDeploymentSelector function returns the label selector of the deployment pods in string format,
+// as required by the scale subresource
+func getDeploymentSelector(cr *daemonsv1alpha1.TangServer) string {
+	return labels.SelectorFromSet(getDeploymentLabels(cr)).String()
+}
+
+// getDeployment function returns correctly constructed deployment
+func getDeployment(cr *daemonsv1alpha1.TangServer) *appsv1.Deployment {
+	labels := getDeploymentLabels(cr)
 	replicas := int32(cr.Spec.Replicas)
 	if replicas == 0 {
 		replicas = DEFAULT_REPLICA_AMOUNT
@@ -61,7 +84,7 @@ func getDeployment(cr *daemonsv1alpha1.TangServer) *appsv1.Deployment {
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RecreateDeploymentStrategyType,
 			},
-			Template: *getPodTemplate(cr, labels),
+			Template: *getPodTemplate(cr, getPodLabels(cr)),
 		},
 	}
 }
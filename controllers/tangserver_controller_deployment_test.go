@@ -44,6 +44,24 @@ var _ = Describe("TangServer controller deployment", func() {
 		TangServerTestResourceLimitMem   = "20M"
 	)
 
+	Context("When computing deployment selector", func() {
+		It("Should match the labels set on deployment pods", func() {
+			tangServer := &daemonsv1alpha1.TangServer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      TangserverName,
+					Namespace: TangserverNamespace,
+				},
+			}
+			deployment := getDeployment(tangServer)
+			Expect(getDeploymentSelector(tangServer)).To(Equal("app=" + TangserverName))
+			Expect(deployment.Spec.Template.Labels).To(Equal(map[string]string{
+				"app":                TangserverName,
+				TANGSERVER_POD_LABEL: TangserverName,
+			}))
+			Expect(deployment.Spec.Selector.MatchLabels).To(Equal(getDeploymentLabels(tangServer)))
+		})
+	})
+
 	Context("When Creating TangServer", func() {
 		It("Should be created with default replica amount", func() {
 			By("By creating a new TangServer with empty replica amount")
@@ -0,0 +1,96 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodExecutor executes commands in the containers of a pod
+type PodExecutor interface {
+	// Exec runs the command in the container (the default one if empty) of the pod, feeding stdin if not nil.
+	// It returns the command standard output and standard error. The exec is aborted when the context is done
+	Exec(ctx context.Context, namespace, podName, containerName string, command []string, stdin io.Reader) (string, string, error)
+}
+
+// SPDYPodExecutor executes commands in pods through the exec subresource, using SPDY streams
+type SPDYPodExecutor struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+// NewSPDYPodExecutor returns a PodExecutor using the rest config provided, typically the manager one
+func NewSPDYPodExecutor(config *rest.Config) (*SPDYPodExecutor, error) {
+	if config == nil {
+		return nil, fmt.Errorf("nil config")
+	}
+	clientset, err := GetClientsetFromClusterConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &SPDYPodExecutor{config: config, clientset: clientset}, nil
+}
+
+// Exec uninterractively executes the command in the pod specified
+func (e *SPDYPodExecutor) Exec(ctx context.Context, namespace, podName, containerName string, command []string, stdin io.Reader) (string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", fmt.Errorf("exec not started: %w, Command: %s", err, command)
+	}
+	req := e.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec")
+	req.VersionedParams(&core_v1.PodExecOptions{
+		Command:   command,
+		Container: containerName,
+		Stdin:     stdin != nil,
+		Stdout:    true,
+		Stderr:    true,
+		TTY:       false,
+	}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", "", fmt.Errorf("error while creating Executor: %v, Command: %s", err, command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Tty:    false,
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", "", fmt.Errorf("error in Stream: %w, Command: %s", ctxErr, command)
+		}
+		return "", stderr.String(), fmt.Errorf("error in Stream: %v, Command: %s", err, command)
+	}
+
+	return stdout.String(), stderr.String(), nil
+}
@@ -0,0 +1,652 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeExitError is returned by the fake executor when the emulated command fails
+var fakeExitError = errors.New("command terminated with exit code 1")
+
+// fakeFile is a file of the fake pod file system
+type fakeFile struct {
+	content    []byte
+	inode      uint64
+	modTime    time.Time
+	changeTime time.Time
+}
+
+// fakePodExecutor is a PodExecutor emulating in memory the key directory of a tang pod.
+// It understands the commands issued by the key handling code: find, sha256sum, ls, mv, rm, stat, cat, test,
+// touch, tee, jose jwk thp/use and tangd-keygen. There is no shell, so commands must be provided as argv
+type fakePodExecutor struct {
+	mu    sync.Mutex
+	files map[string]*fakeFile
+	now   time.Time
+	inode uint64
+	// commands records every command executed
+	commands []string
+	// failOn makes commands starting with any of these prefixes fail
+	failOn []string
+}
+
+// newFakePodExecutor returns a fake executor with an empty file system
+func newFakePodExecutor() *fakePodExecutor {
+	return &fakePodExecutor{
+		files: make(map[string]*fakeFile),
+		now:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// newKeyManagementFixture returns the key management context of a TangServer with the spec provided, whose
+// commands in the pod are run by the executor, and a reconciler holding the TangServer in a fake client
+func newKeyManagementFixture(spec daemonsv1alpha1.TangServerSpec, executor PodExecutor) (KeyObtainInfo, *TangServerReconciler, *events.FakeRecorder) {
+	scheme.Scheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+		&daemonsv1alpha1.TangServer{},
+		&daemonsv1alpha1.TangServerList{},
+	)
+	tangServer := &daemonsv1alpha1.TangServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-tangserver-keys", Namespace: "default"},
+		Spec:       spec,
+	}
+	keyInfo := KeyObtainInfo{
+		PodName:    "test-pod",
+		Namespace:  "default",
+		DbPath:     DEFAULT_DEPLOYMENT_KEY_PATH,
+		TangServer: tangServer,
+		Executor:   executor,
+		stats:      &execStats{},
+		inventory:  &keyInventoryCache{},
+	}
+	recorder := events.NewFakeRecorder(100)
+	reconciler := &TangServerReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tangServer).WithStatusSubresource(tangServer).Build(),
+		Scheme:   scheme.Scheme,
+		Recorder: recorder,
+		Executor: executor,
+	}
+	return keyInfo, reconciler, recorder
+}
+
+// Exec emulates the command provided
+func (f *fakePodExecutor) Exec(ctx context.Context, namespace, podName, containerName string, command []string, stdin io.Reader) (string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, strings.Join(command, " "))
+	for _, prefix := range f.failOn {
+		if strings.HasPrefix(strings.Join(command, " "), prefix) {
+			return "", "injected failure", fakeExitError
+		}
+	}
+	// Every command takes a second, so that file times differ
+	f.now = f.now.Add(time.Second)
+	if len(command) == 0 {
+		return "", "empty command", fakeExitError
+	}
+	switch path.Base(command[0]) {
+	case "find":
+		return f.find(command[1:])
+	case "sha256sum":
+		return f.sha256sum(command[1:])
+	case "ls":
+		return f.ls(command[1:])
+	case "mv":
+		return f.mv(command[1:])
+	case "rm":
+		return f.rm(command[1:])
+	case "stat":
+		return f.stat(command[1:])
+	case "cat":
+		return f.cat(command[1:])
+	case "test":
+		return f.test(command[1:])
+	case "touch":
+		return f.touch(command[1:])
+	case "tee":
+		return f.tee(command[1:], stdin)
+	case "jose":
+		return f.jose(command[1:])
+	case "tangd-keygen":
+		return f.keygen(command[1:])
+	case "curl":
+		return f.adv(command[1:])
+	}
+	return "", command[0] + ": command not found", fakeExitError
+}
+
+// fakeSplitFlags separates arguments starting with - from the rest
+func fakeSplitFlags(args []string) (string, []string) {
+	flags := ""
+	operands := make([]string, 0)
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") && len(a) > 1 {
+			flags += strings.TrimLeft(a, "-")
+		} else {
+			operands = append(operands, a)
+		}
+	}
+	return flags, operands
+}
+
+// newFile returns a new file, with its own inode, created now
+func (f *fakePodExecutor) newFile(content []byte) *fakeFile {
+	f.inode++
+	return &fakeFile{content: content, inode: f.inode, modTime: f.now, changeTime: f.now}
+}
+
+// addFile stores a file in the fake file system
+func (f *fakePodExecutor) addFile(filePath string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[path.Clean(filePath)] = f.newFile(content)
+}
+
+// fileContent returns the content of a file and whether it exists
+func (f *fakePodExecutor) fileContent(filePath string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, found := f.files[path.Clean(filePath)]
+	if !found {
+		return nil, false
+	}
+	return file.content, true
+}
+
+// listDir returns the sorted names of the entries in the directory provided
+func (f *fakePodExecutor) listDir(dir string) []string {
+	names := make([]string, 0)
+	dir = path.Clean(dir)
+	for p := range f.files {
+		if path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entries returns the sorted names of the entries in the directory provided
+func (f *fakePodExecutor) entries(dir string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listDir(dir)
+}
+
+// addKeyPair generates a tang signing and exchange key pair in the directory provided, returning their file names
+func (f *fakePodExecutor) addKeyPair(dir string) (string, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.generateKeyPair(dir)
+}
+
+// generateKeyPair writes the signing key and, some milliseconds later, the exchange key, as tangd-keygen does.
+// Time goes on afterwards, so that pairs generated one after another belong to different batches
+func (f *fakePodExecutor) generateKeyPair(dir string) (string, string) {
+	names := make([]string, 0, 2)
+	for _, key := range []struct {
+		alg    string
+		keyOps []string
+	}{{"ES512", []string{"sign", "verify"}}, {"ECMR", []string{"deriveKey"}}} {
+		jwk := fakeGenerateJWK(key.alg, key.keyOps)
+		thp, _ := fakeThumbprint(jwk, "S256")
+		name := thp + ".jwk"
+		f.files[path.Join(path.Clean(dir), name)] = f.newFile(jwk)
+		names = append(names, name)
+		f.now = f.now.Add(10 * time.Millisecond)
+	}
+	f.now = f.now.Add(time.Second)
+	return names[0], names[1]
+}
+
+// fakeGenerateJWK generates a P-521 JWK as tangd-keygen does
+func fakeGenerateJWK(alg string, keyOps []string) []byte {
+	key, err := ecdh.P521().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	// Uncompressed point: 0x04 || X || Y
+	point := key.PublicKey().Bytes()[1:]
+	size := len(point) / 2
+	jwk, _ := json.Marshal(map[string]interface{}{
+		"alg":     alg,
+		"crv":     "P-521",
+		"d":       base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		"key_ops": keyOps,
+		"kty":     "EC",
+		"x":       base64.RawURLEncoding.EncodeToString(point[:size]),
+		"y":       base64.RawURLEncoding.EncodeToString(point[size:]),
+	})
+	return jwk
+}
+
+// fakeThumbprint computes the RFC 7638 thumbprint of an EC JWK
+func fakeThumbprint(jwk []byte, alg string) (string, error) {
+	var key struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(jwk, &key); err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	switch alg {
+	case "S1":
+		h = sha1.New()
+	case "S256":
+		h = sha256.New()
+	default:
+		return "", fmt.Errorf("unsupported hash %s", alg)
+	}
+	fmt.Fprintf(h, `{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, key.Crv, key.Kty, key.X, key.Y)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// find only supports listing the files of a directory, with -name, -printf and -exec ... {} +
+func (f *fakePodExecutor) find(args []string) (string, string, error) {
+	if len(args) == 0 {
+		return "", "find: missing directory", fakeExitError
+	}
+	dir := path.Clean(args[0])
+	var format, name string
+	var exec []string
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-mindepth", "-maxdepth", "-type":
+			i++
+		case "-name":
+			if i+1 < len(args) {
+				name = args[i+1]
+				i++
+			}
+		case "-printf":
+			if i+1 < len(args) {
+				format = args[i+1]
+				i++
+			}
+		case "-exec":
+			for i++; i < len(args) && args[i] != "+"; i++ {
+				exec = append(exec, args[i])
+			}
+		default:
+			return "", "find: unsupported argument " + args[i], fakeExitError
+		}
+	}
+	out := ""
+	paths := make([]string, 0)
+	for _, entry := range f.listDir(dir) {
+		if name != "" && entry != name {
+			continue
+		}
+		file := f.files[path.Join(dir, entry)]
+		epoch := func(t time.Time) string { return fmt.Sprintf("%d.%09d0", t.Unix(), t.Nanosecond()) }
+		out += strings.NewReplacer("%f", entry, "%s", fmt.Sprint(len(file.content)), "%i", fmt.Sprint(file.inode),
+			"%T@", epoch(file.modTime), "%C@", epoch(file.changeTime)).Replace(format)
+		paths = append(paths, path.Join(args[0], entry))
+	}
+	if len(exec) > 0 && len(paths) > 0 {
+		if exec[len(exec)-1] != "{}" {
+			return out, "find: unsupported -exec", fakeExitError
+		}
+		var stdo, stde string
+		var err error
+		switch path.Base(exec[0]) {
+		case "sha256sum":
+			stdo, stde, err = f.sha256sum(append(exec[1:len(exec)-1], paths...))
+		case "cat":
+			stdo, stde, err = f.cat(append(exec[1:len(exec)-1], paths...))
+		default:
+			return out, "find: unsupported -exec", fakeExitError
+		}
+		return out + stdo, stde, err
+	}
+	return out, "", nil
+}
+
+func (f *fakePodExecutor) sha256sum(args []string) (string, string, error) {
+	out := ""
+	for _, a := range args {
+		file, found := f.files[path.Clean(a)]
+		if !found {
+			return out, "sha256sum: " + a + ": No such file or directory", fakeExitError
+		}
+		out += fmt.Sprintf("%x  %s\n", sha256.Sum256(file.content), a)
+	}
+	return out, "", nil
+}
+
+func (f *fakePodExecutor) ls(args []string) (string, string, error) {
+	flags, operands := fakeSplitFlags(args)
+	if len(operands) != 1 {
+		return "", "ls: one directory expected", fakeExitError
+	}
+	all := strings.Contains(flags, "a")
+	out := ""
+	if all {
+		out = ".\n..\n"
+	}
+	for _, name := range f.listDir(operands[0]) {
+		if all || !strings.HasPrefix(name, ".") {
+			out += name + "\n"
+		}
+	}
+	return out, "", nil
+}
+
+func (f *fakePodExecutor) mv(args []string) (string, string, error) {
+	flags, operands := fakeSplitFlags(args)
+	if len(operands) != 2 {
+		return "", "mv: missing file operand", fakeExitError
+	}
+	src, dst := path.Clean(operands[0]), path.Clean(operands[1])
+	file, found := f.files[src]
+	if !found {
+		return "", "mv: cannot stat '" + operands[0] + "': No such file or directory", fakeExitError
+	}
+	if _, exists := f.files[dst]; exists && strings.Contains(flags, "n") {
+		return "", "", nil
+	}
+	delete(f.files, src)
+	file.changeTime = f.now
+	f.files[dst] = file
+	return "", "", nil
+}
+
+func (f *fakePodExecutor) rm(args []string) (string, string, error) {
+	flags, operands := fakeSplitFlags(args)
+	out, errOut := "", ""
+	var err error
+	for _, o := range operands {
+		p := path.Clean(o)
+		if _, found := f.files[p]; !found {
+			if !strings.Contains(flags, "f") {
+				errOut += "rm: cannot remove '" + o + "': No such file or directory\n"
+				err = fakeExitError
+			}
+			continue
+		}
+		delete(f.files, p)
+		if strings.Contains(flags, "v") {
+			out += "removed '" + o + "'\n"
+		}
+	}
+	return out, errOut, err
+}
+
+func (f *fakePodExecutor) stat(args []string) (string, string, error) {
+	if len(args) != 3 || args[0] != "-c" {
+		return "", "stat: unsupported arguments", fakeExitError
+	}
+	file, found := f.files[path.Clean(args[2])]
+	if !found {
+		return "", "stat: cannot statx '" + args[2] + "': No such file or directory", fakeExitError
+	}
+	const layout = "2006-01-02 15:04:05.000000000 -0700"
+	out := strings.NewReplacer("%y", file.modTime.Format(layout), "%z", file.changeTime.Format(layout)).Replace(args[1])
+	return out + "\n", "", nil
+}
+
+func (f *fakePodExecutor) cat(args []string) (string, string, error) {
+	out := ""
+	for _, a := range args {
+		file, found := f.files[path.Clean(a)]
+		if !found {
+			return out, "cat: " + a + ": No such file or directory", fakeExitError
+		}
+		out += string(file.content)
+	}
+	return out, "", nil
+}
+
+func (f *fakePodExecutor) test(args []string) (string, string, error) {
+	if len(args) != 2 || args[0] != "-f" {
+		return "", "test: unsupported arguments", fakeExitError
+	}
+	if _, found := f.files[path.Clean(args[1])]; !found {
+		return "", "", fakeExitError
+	}
+	return "", "", nil
+}
+
+func (f *fakePodExecutor) touch(args []string) (string, string, error) {
+	modTime := f.now
+	operands := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-d" && i+1 < len(args):
+			t, err := parseEpoch(strings.TrimPrefix(args[i+1], "@"))
+			if err != nil {
+				return "", "touch: invalid date format '" + args[i+1] + "'", fakeExitError
+			}
+			modTime = t
+			i++
+		case strings.HasPrefix(a, "-"):
+		default:
+			operands = append(operands, a)
+		}
+	}
+	for _, a := range operands {
+		p := path.Clean(a)
+		if file, found := f.files[p]; found {
+			file.modTime = modTime
+			file.changeTime = f.now
+		} else {
+			file := f.newFile(nil)
+			file.modTime = modTime
+			f.files[p] = file
+		}
+	}
+	return "", "", nil
+}
+
+func (f *fakePodExecutor) tee(args []string, stdin io.Reader) (string, string, error) {
+	content := []byte{}
+	if stdin != nil {
+		var err error
+		if content, err = io.ReadAll(stdin); err != nil {
+			return "", err.Error(), fakeExitError
+		}
+	}
+	for _, a := range args {
+		f.files[path.Clean(a)] = f.newFile(content)
+	}
+	return string(content), "", nil
+}
+
+func (f *fakePodExecutor) jose(args []string) (string, string, error) {
+	if len(args) < 2 || args[0] != "jwk" {
+		return "", "jose: unsupported command", fakeExitError
+	}
+	var input, alg, use string
+	required := false
+	for i := 2; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-i" || a == "--input":
+			if i+1 < len(args) {
+				input = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(a, "--input="):
+			input = strings.TrimPrefix(a, "--input=")
+		case a == "-a" || a == "--algorithm":
+			if i+1 < len(args) {
+				alg = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(a, "-a"):
+			alg = strings.TrimPrefix(a, "-a")
+		case strings.HasPrefix(a, "--use="):
+			use = strings.TrimPrefix(a, "--use=")
+		case a == "-r" || a == "--required":
+			required = true
+		}
+	}
+	file, found := f.files[path.Clean(input)]
+	if !found {
+		return "", "Error reading JWK from " + input, fakeExitError
+	}
+	switch args[1] {
+	case "thp":
+		if alg == "" {
+			alg = "S256"
+		}
+		thp, err := fakeThumbprint(file.content, alg)
+		if err != nil {
+			return "", err.Error(), fakeExitError
+		}
+		return thp, "", nil
+	case "use":
+		var key struct {
+			KeyOps []string `json:"key_ops"`
+		}
+		if err := json.Unmarshal(file.content, &key); err != nil {
+			return "", "Error reading JWK from " + input, fakeExitError
+		}
+		if !required && len(key.KeyOps) == 0 {
+			return "", "", nil
+		}
+		if contains(key.KeyOps, use) {
+			return "", "", nil
+		}
+		return "", "", fakeExitError
+	}
+	return "", "jose: unsupported command", fakeExitError
+}
+
+func (f *fakePodExecutor) keygen(args []string) (string, string, error) {
+	if len(args) < 1 {
+		return "", "Usage: tangd-keygen <jwkdir> [<sig> <exc>]", fakeExitError
+	}
+	f.generateKeyPair(args[0])
+	return "", "", nil
+}
+
+// adv emulates fetching the tang advertisement, which contains every key not hidden, whatever its directory
+func (f *fakePodExecutor) adv(args []string) (string, string, error) {
+	_, operands := fakeSplitFlags(args)
+	if len(operands) != 1 || !strings.HasSuffix(operands[0], "/adv") {
+		return "", "curl: unsupported arguments", fakeExitError
+	}
+	keys := make([]json.RawMessage, 0)
+	for p, file := range f.files {
+		if strings.HasPrefix(path.Base(p), ".") || !strings.HasSuffix(p, ".jwk") || !json.Valid(file.content) {
+			continue
+		}
+		keys = append(keys, file.content)
+	}
+	payload, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	jws, _ := json.Marshal(map[string]string{"payload": base64.RawURLEncoding.EncodeToString(payload), "signature": "fake"})
+	return string(jws), "", nil
+}
+
+var _ = Describe("TangServer controller fake pod executor", func() {
+	const keyPath = "/var/db/tang"
+	var executor *fakePodExecutor
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+	})
+
+	Context("When emulating tangd-keygen", func() {
+		It("Should generate a signing and an exchange key named after their thumbprint", func() {
+			_, _, err := executor.Exec(ctx, "default", "pod", "", []string{"/usr/libexec/tangd-keygen", keyPath + "/"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(executor.entries(keyPath)).To(HaveLen(2))
+			for _, name := range executor.entries(keyPath) {
+				thp, _, err := executor.Exec(ctx, "default", "pod", "", []string{"jose", "jwk", "thp", "-a", "S256", "-i", keyPath + "/" + name}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(name).To(Equal(thp + ".jwk"))
+			}
+		})
+
+		It("Should tell signing keys from exchange keys", func() {
+			signing, exchange := executor.addKeyPair(keyPath)
+			_, _, err := executor.Exec(ctx, "default", "pod", "", []string{"jose", "jwk", "use", "--input", keyPath + "/" + signing, "--required", "--use=verify"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = executor.Exec(ctx, "default", "pod", "", []string{"jose", "jwk", "use", "--input", keyPath + "/" + exchange, "--required", "--use=verify"}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When emulating file commands", func() {
+		It("Should list, move, stat and remove files", func() {
+			signing, _ := executor.addKeyPair(keyPath)
+			_, _, err := executor.Exec(ctx, "default", "pod", "", []string{"mv", keyPath + "/" + signing, keyPath + "/." + signing}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			stdo, _, err := executor.Exec(ctx, "default", "pod", "", []string{"ls", keyPath}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdo).ToNot(ContainSubstring(signing))
+			stdo, _, err = executor.Exec(ctx, "default", "pod", "", []string{"ls", "-a", keyPath + "/"}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdo).To(ContainSubstring("." + signing + "\n"))
+			stdo, _, err = executor.Exec(ctx, "default", "pod", "", []string{"stat", "-c", "%z", keyPath + "/." + signing}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdo).To(HavePrefix("2023-01-01 00:00:"))
+			_, _, err = executor.Exec(ctx, "default", "pod", "", []string{"rm", "-v", keyPath + "/." + signing}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = executor.Exec(ctx, "default", "pod", "", []string{"rm", "-v", keyPath + "/." + signing}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should write files streamed through stdin", func() {
+			_, _, err := executor.Exec(ctx, "default", "pod", "", []string{"tee", keyPath + "/file.txt"}, strings.NewReader(`{"a": "b"}`))
+			Expect(err).ToNot(HaveOccurred())
+			content, found := executor.fileContent(keyPath + "/file.txt")
+			Expect(found).To(BeTrue())
+			Expect(string(content)).To(Equal(`{"a": "b"}`))
+		})
+
+		It("Should fail injected and unknown commands", func() {
+			executor.failOn = []string{"ls"}
+			_, _, err := executor.Exec(ctx, "default", "pod", "", []string{"ls", keyPath}, nil)
+			Expect(err).To(HaveOccurred())
+			_, _, err = executor.Exec(ctx, "default", "pod", "", []string{"unknown"}, nil)
+			Expect(err).To(HaveOccurred())
+			_, _, err = executor.Exec(ctx, "default", "pod", "", []string{"/bin/bash", "-c", "ls " + keyPath}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
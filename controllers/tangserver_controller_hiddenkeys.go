@@ -0,0 +1,196 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// hiddenKeyAction returns the action requested for a key of spec.hiddenKeys, Hide if none
+func hiddenKeyAction(hk daemonsv1alpha1.TangServerHiddenKeys) string {
+	if hk.Action == "" {
+		return daemonsv1alpha1.HiddenKeyActionHide
+	}
+	return hk.Action
+}
+
+// hiddenKeyHasThumbprint returns true if the key of spec.hiddenKeys has one of the thumbprints provided.
+// Thumbprints are compared as provided, as the CRD validation and the webhook do, which reject surrounding spaces
+func hiddenKeyHasThumbprint(hk daemonsv1alpha1.TangServerHiddenKeys, sha1 string, sha256 string) bool {
+	return (hk.Sha1 != "" && hk.Sha1 == sha1) || (hk.Sha256 != "" && hk.Sha256 == sha256)
+}
+
+// hiddenKeyMatches returns true if the key of spec.hiddenKeys is the signing key of the key pair
+func hiddenKeyMatches(hk daemonsv1alpha1.TangServerHiddenKeys, p KeyPair) bool {
+	return hiddenKeyHasThumbprint(hk, p.Signing.Sha1, p.Signing.Sha256)
+}
+
+// isDeletionConfirmed returns true if the deletion of the key of spec.hiddenKeys is confirmed with its thumbprint
+func isDeletionConfirmed(hk daemonsv1alpha1.TangServerHiddenKeys) bool {
+	return hk.ConfirmDelete != "" && (hk.ConfirmDelete == hk.Sha1 || hk.ConfirmDelete == hk.Sha256)
+}
+
+// deleteHiddenKeyPair deletes the files of a hidden key pair and removes it from the key status file
+func deleteHiddenKeyPair(ctx context.Context, k KeyObtainInfo, p KeyPair) error {
+	if !p.Hidden() {
+		return fmt.Errorf("refusing to delete active key file %s", p.Signing.File.Name)
+	}
+	if err := removeKeyFiles(ctx, k, []string{p.Signing.File.Name, p.Exchange.File.Name}); err != nil {
+		return err
+	}
+	if err := dumpKeyAssociations(ctx, k, nil, []string{p.Signing.Sha1, p.Signing.Sha256}, nil); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to remove deleted key pair from key status file", "Key File", p.Signing.File.Name)
+	}
+	return nil
+}
+
+// deleteRequestedHiddenKeys deletes the hidden key pairs with the Delete action in spec, once confirmed with
+// their thumbprint. Active key pairs, held ones and keys without a reliable pair are never deleted
+func (r *TangServerReconciler) deleteRequestedHiddenKeys(ctx context.Context, k KeyObtainInfo) {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	requested := make([]daemonsv1alpha1.TangServerHiddenKeys, 0)
+	for _, hk := range cr.Spec.HiddenKeys {
+		if hiddenKeyAction(hk) == daemonsv1alpha1.HiddenKeyActionDelete {
+			requested = append(requested, hk)
+		}
+	}
+	if len(requested) == 0 {
+		return
+	}
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		l.Error(err, "Unable to read keys, hidden keys not deleted", "podname", k.PodName, "namespace", k.Namespace)
+		return
+	}
+	pairs := pairKeys(inventory).Pairs
+	for _, hk := range requested {
+		var pair *KeyPair
+		for i := range pairs {
+			if hiddenKeyMatches(hk, pairs[i]) {
+				pair = &pairs[i]
+				break
+			}
+		}
+		if pair == nil {
+			l.Info("Key requested to be deleted not found or without a reliable pair", "sha1", hk.Sha1, "sha256", hk.Sha256)
+			continue
+		}
+		keyFileName := pair.Signing.File.Name
+		var refusal string
+		switch {
+		case !isDeletionConfirmed(hk):
+			refusal = "deletion not confirmed with its thumbprint"
+		case !pair.Hidden():
+			refusal = "key is active, it must be hidden first"
+		case isHeldKeyPair(cr, *pair):
+			refusal = "key is held"
+		}
+		if refusal != "" {
+			l.Info("Hidden key pair not deleted", "Key File", keyFileName, "Cause", refusal)
+			r.Recorder.Eventf(cr, nil, "Warning", "HiddenKeysDeletion", "HiddenKeysDeletion",
+				"Hidden key pair NOT deleted, %s, Key File: %s", refusal, keyFileName)
+			continue
+		}
+		if err := deleteHiddenKeyPair(ctx, k, *pair); err != nil {
+			l.Error(err, "Unable to delete hidden key pair", "Key File", keyFileName)
+			r.Recorder.Eventf(cr, nil, "Warning", "HiddenKeysDeletion", "HiddenKeysDeletion",
+				"Hidden key pair NOT deleted, Key File: %s", keyFileName)
+			setDegraded(cr, daemonsv1alpha1.ReasonHiddenKeysDeletionFailed, fmt.Sprintf("Unable to delete hidden key file %s", keyFileName))
+			continue
+		}
+		r.Recorder.Eventf(cr, nil, "Normal", "HiddenKeysDeletion", "HiddenKeysDeletion",
+			"Hidden key pair deleted, Key File: %s, Exchange Key File: %s", keyFileName, pair.Exchange.File.Name)
+	}
+}
+
+// hiddenKeysOmittedFromSpec returns the hidden key pairs that previous operator versions deleted because
+// spec.hiddenKeys did not list them: all of them for an empty list, the ones omitted otherwise. Key pairs hidden
+// by the key rotation policy or the required active key pairs were never deleted that way
+func hiddenKeysOmittedFromSpec(ctx context.Context, k KeyObtainInfo, pairs []KeyPair) []KeyPair {
+	cr := k.TangServer
+	if cr.Spec.HiddenKeys == nil {
+		return nil
+	}
+	omitted := make([]KeyPair, 0)
+	for _, p := range pairs {
+		if !p.Hidden() {
+			continue
+		}
+		listed := false
+		for _, hk := range cr.Spec.HiddenKeys {
+			if hiddenKeyMatches(hk, p) {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			omitted = append(omitted, p)
+		}
+	}
+	if len(omitted) == 0 {
+		return omitted
+	}
+	recorded, err := readKeyStatus(ctx, k)
+	if err != nil {
+		log.FromContext(ctx).Info("Unable to read key status file, hidden keys rotated by policy not told apart", "Error", err.Error())
+		return omitted
+	}
+	relying := make([]KeyPair, 0, len(omitted))
+	for _, p := range omitted {
+		rotatedBy := recorded.KeyStatusSha256Map[p.Signing.Sha256].RotatedBy
+		if rotatedBy != KEY_HIDDEN_BY_ROTATION_POLICY && rotatedBy != KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS {
+			relying = append(relying, p)
+		}
+	}
+	return relying
+}
+
+// updateHiddenKeysMigration reports through the HiddenKeysMigrationRequired condition the hidden keys that
+// spec.hiddenKeys expects to be deleted by omission, which the operator no longer does
+func (r *TangServerReconciler) updateHiddenKeysMigration(ctx context.Context, k KeyObtainInfo, pairs []KeyPair) {
+	cr := k.TangServer
+	omitted := hiddenKeysOmittedFromSpec(ctx, k, pairs)
+	if len(omitted) == 0 {
+		if meta.FindStatusCondition(cr.Status.Conditions, daemonsv1alpha1.ConditionHiddenKeysMigrationRequired) != nil {
+			setCondition(cr, daemonsv1alpha1.ConditionHiddenKeysMigrationRequired, metav1.ConditionFalse,
+				daemonsv1alpha1.ReasonAsExpected, "No hidden keys rely on being omitted from spec.hiddenKeys")
+		}
+		return
+	}
+	files := make([]string, 0, len(omitted))
+	for _, p := range omitted {
+		files = append(files, p.Signing.File.Name)
+	}
+	message := "Hidden keys omitted from spec.hiddenKeys are no longer deleted. List them with the Delete action and " +
+		"confirmDelete to delete them, or with KeepHidden to keep them: " + strings.Join(files, ", ")
+	if len(cr.Spec.HiddenKeys) == 0 {
+		message = "An empty spec.hiddenKeys no longer deletes all hidden keys. List them with the Delete action and " +
+			"confirmDelete to delete them, or unset spec.hiddenKeys to keep them: " + strings.Join(files, ", ")
+	}
+	if setCondition(cr, daemonsv1alpha1.ConditionHiddenKeysMigrationRequired, metav1.ConditionTrue,
+		daemonsv1alpha1.ReasonImplicitDeletionRemoved, message) {
+		r.Recorder.Eventf(cr, nil, "Warning", "HiddenKeysMigration", "HiddenKeysMigration", "%s", message)
+	}
+}
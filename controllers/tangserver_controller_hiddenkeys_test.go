@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller hidden keys", func() {
+	const keyPath = "/var/db/tang"
+	var (
+		executor   *fakePodExecutor
+		keyInfo    KeyObtainInfo
+		reconciler *TangServerReconciler
+		recorder   *events.FakeRecorder
+		// active and hidden contain the signing key files of the active and hidden key pairs
+		active, hidden []string
+	)
+	ctx := context.Background()
+
+	// sha256 returns the sha256 thumbprint of a key file
+	sha256 := func(name string) string {
+		return strings.TrimSuffix(strings.TrimPrefix(name, "."), ".jwk")
+	}
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		keyInfo, reconciler, recorder = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{}, executor)
+		active, hidden = nil, nil
+		signing, _ := executor.addKeyPair(keyPath)
+		active = append(active, signing)
+		for i := 0; i < 2; i++ {
+			signing, exchange := executor.addKeyPair(keyPath)
+			Expect(moveKeyFile(ctx, keyInfo, signing, "."+signing)).To(Succeed())
+			Expect(moveKeyFile(ctx, keyInfo, exchange, "."+exchange)).To(Succeed())
+			hidden = append(hidden, "."+signing)
+		}
+		reconciler.UpdateKeys(ctx, keyInfo)
+	})
+
+	Context("When handling hidden key actions", func() {
+		It("Should keep the hidden keys omitted from spec", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha256: sha256(hidden[0])}}
+			reconciler.handleHiddenKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ContainElements(hidden[0], hidden[1]))
+		})
+
+		It("Should only delete hidden keys with a confirmed Delete action", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+				{Sha256: sha256(hidden[0]), Action: daemonsv1alpha1.HiddenKeyActionDelete, ConfirmDelete: sha256(hidden[0])},
+				{Sha256: sha256(hidden[1]), Action: daemonsv1alpha1.HiddenKeyActionDelete},
+			}
+			reconciler.handleHiddenKeys(ctx, keyInfo)
+			entries := executor.entries(keyPath)
+			Expect(entries).ToNot(ContainElement(hidden[0]))
+			Expect(entries).To(ContainElement(hidden[1]))
+			Expect(recorder.Events).To(Receive(And(ContainSubstring("Hidden key pair deleted"), ContainSubstring(hidden[0]))))
+			Expect(recorder.Events).To(Receive(And(ContainSubstring("not confirmed"), ContainSubstring(hidden[1]))))
+
+			keyStatus, err := readKeyStatus(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyStatus.KeyStatusSha256Map).ToNot(HaveKey(sha256(hidden[0])))
+		})
+
+		It("Should neither delete active nor held keys", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+				{Sha256: sha256(active[0]), Action: daemonsv1alpha1.HiddenKeyActionDelete, ConfirmDelete: sha256(active[0])},
+				{Sha256: sha256(hidden[0]), Action: daemonsv1alpha1.HiddenKeyActionDelete, ConfirmDelete: sha256(hidden[0]), Hold: true},
+			}
+			reconciler.handleHiddenKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ContainElements(active[0], hidden[0]))
+			Expect(recorder.Events).To(Receive(ContainSubstring("must be hidden first")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("key is held")))
+		})
+
+		It("Should only rotate active keys with the Hide action", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+				{Sha256: sha256(active[0]), Action: daemonsv1alpha1.HiddenKeyActionKeepHidden},
+			}
+			Expect(reconciler.handleHiddenKeys(ctx, keyInfo)).To(BeFalse())
+			Expect(executor.entries(keyPath)).To(ContainElement(active[0]))
+
+			keyInfo.TangServer.Spec.HiddenKeys[0].Action = ""
+			Expect(reconciler.handleHiddenKeys(ctx, keyInfo)).To(BeTrue())
+			Expect(executor.entries(keyPath)).To(ContainElement("." + active[0]))
+		})
+
+		It("Should report hidden key pairs that can not be deleted", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+				{Sha256: sha256(hidden[0]), Action: daemonsv1alpha1.HiddenKeyActionDelete, ConfirmDelete: sha256(hidden[0])},
+			}
+			executor.failOn = []string{"rm "}
+			reconciler.handleHiddenKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ContainElement(hidden[0]))
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring("NOT deleted")))
+		})
+	})
+
+	Context("When reporting the hidden keys migration", func() {
+		It("Should not report anything without hidden keys in spec", func() {
+			Expect(meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions,
+				daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)).To(BeNil())
+		})
+
+		It("Should report hidden keys that an empty list used to delete", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{}
+			reconciler.UpdateKeys(ctx, keyInfo)
+			condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions,
+				daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal(daemonsv1alpha1.ReasonImplicitDeletionRemoved))
+			Expect(condition.Message).To(And(ContainSubstring("empty spec.hiddenKeys"), ContainSubstring(hidden[0]), ContainSubstring(hidden[1])))
+			Expect(recorder.Events).To(Receive(ContainSubstring("HiddenKeysMigration")))
+			Expect(executor.entries(keyPath)).To(ContainElements(hidden[0], hidden[1]))
+		})
+
+		It("Should clear the report once every hidden key is listed", func() {
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha256: sha256(hidden[0])}}
+			reconciler.UpdateKeys(ctx, keyInfo)
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)).To(BeTrue())
+			condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions,
+				daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)
+			Expect(condition.Message).To(ContainSubstring(hidden[1]))
+			Expect(condition.Message).ToNot(ContainSubstring(hidden[0]))
+
+			keyInfo.TangServer.Spec.HiddenKeys = append(keyInfo.TangServer.Spec.HiddenKeys,
+				daemonsv1alpha1.TangServerHiddenKeys{Sha256: sha256(hidden[1]), Action: daemonsv1alpha1.HiddenKeyActionKeepHidden})
+			reconciler.UpdateKeys(ctx, keyInfo)
+			condition = meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions,
+				daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		})
+	})
+})
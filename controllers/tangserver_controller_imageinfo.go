@@ -20,8 +20,8 @@ import (
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
 )
 
-const DEFAULT_APP_IMAGE = "registry.redhat.io/rhel9/tang"
-const DEFAULT_APP_VERSION = "latest"
+const DEFAULT_APP_IMAGE = daemonsv1alpha1.DefaultImage
+const DEFAULT_APP_VERSION = daemonsv1alpha1.DefaultVersion
 
 func getCompleteImageNameAndVersion(appImage string, appVersion string) string {
 	return appImage + ":" + appVersion
@@ -0,0 +1,247 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Layout of the times reported in the key status, as "stat -c %y" prints them
+const KEY_TIME_LAYOUT = "2006-01-02 15:04:05.000000000 -0700"
+
+// inventoryPrintf is the find format of the metadata of each key directory entry:
+// name, size, inode, modification and change time (seconds since epoch)
+const inventoryPrintf = "%f\t%s\t%i\t%T@\t%C@\n"
+
+// inventoryHashRegexp matches the sha256sum output lines
+var inventoryHashRegexp = regexp.MustCompile(`^([0-9a-f]{64}) [ *](.+)$`)
+
+// KeyFileInfo contains the metadata of a file of the key directory
+type KeyFileInfo struct {
+	Name       string
+	Size       int64
+	Inode      uint64
+	ModTime    time.Time
+	ChangeTime time.Time
+	// Hash is the hex encoded sha256 of the file content
+	Hash string
+}
+
+// KeyMetadata contains the parsed information of a key file, which does not change while the file does not
+type KeyMetadata struct {
+	File   KeyFileInfo
+	JWK    *JWK
+	Sha1   string
+	Sha256 string
+	// err is the reason the file is not a valid key, if any
+	err error
+}
+
+// keyFileID identifies a version of a key file, so that renamed files are not processed again
+type keyFileID struct {
+	inode   uint64
+	modTime time.Time
+	hash    string
+}
+
+func (f KeyFileInfo) id() keyFileID {
+	return keyFileID{inode: f.Inode, modTime: f.ModTime, hash: f.Hash}
+}
+
+// keyInventoryCache caches the key metadata of each TangServer, safe for concurrent use
+type keyInventoryCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]map[keyFileID]KeyMetadata
+}
+
+// lookup returns the cached metadata of the file provided, if the file has not changed
+func (c *keyInventoryCache) lookup(name types.NamespacedName, file KeyFileInfo) (KeyMetadata, bool) {
+	if c == nil {
+		return KeyMetadata{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, found := c.entries[name][file.id()]
+	m.File = file
+	return m, found
+}
+
+// replace stores the metadata of the TangServer provided, dropping the files no longer present
+func (c *keyInventoryCache) replace(name types.NamespacedName, keys []KeyMetadata) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[types.NamespacedName]map[keyFileID]KeyMetadata)
+	}
+	entries := make(map[keyFileID]KeyMetadata, len(keys))
+	for _, k := range keys {
+		entries[k.File.id()] = k
+	}
+	c.entries[name] = entries
+}
+
+// forget drops the metadata of the TangServer provided
+func (c *keyInventoryCache) forget(name types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// inventoryCommand returns the command listing the metadata and content hash of the key directory files.
+// find reports every file before running sha256sum on all of them, so a single exec is required
+func inventoryCommand(dbPath string) []string {
+	return []string{"find", dbPath + "/", "-mindepth", "1", "-maxdepth", "1", "-type", "f",
+		"-printf", inventoryPrintf, "-exec", "sha256sum", "{}", "+"}
+}
+
+// parseEpoch parses a time printed by find as seconds since epoch, with an optional fractional part
+func parseEpoch(value string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(value, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", value)
+		}
+	}
+	return time.Unix(s, nsec).UTC(), nil
+}
+
+// parseInventory parses the output of the inventory command, returning the files sorted by name
+func parseInventory(output string) ([]KeyFileInfo, error) {
+	files := make(map[string]*KeyFileInfo)
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if m := inventoryHashRegexp.FindStringSubmatch(line); m != nil {
+			hashes[path.Base(m[2])] = m[1]
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected key inventory line %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in key inventory line %q", line)
+		}
+		inode, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode in key inventory line %q", line)
+		}
+		modTime, err := parseEpoch(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		changeTime, err := parseEpoch(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		files[fields[0]] = &KeyFileInfo{Name: fields[0], Size: size, Inode: inode, ModTime: modTime, ChangeTime: changeTime}
+	}
+	result := make([]KeyFileInfo, 0, len(files))
+	for name, f := range files {
+		f.Hash = hashes[name]
+		result = append(result, *f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// readKeyInventory returns the metadata of the keys in the key directory, fetching and parsing only the
+// files changed since the previous inventory of the TangServer. Files that are not JWKs are not returned
+func readKeyInventory(ctx context.Context, keyInfo KeyObtainInfo) ([]KeyMetadata, error) {
+	l := log.FromContext(ctx)
+	command := inventoryCommand(keyInfo.DbPath)
+	stdo, stde, err := keyInfo.podExec(ctx, nil, command...)
+	if err != nil {
+		l.Error(err, "Unable to execute command in Pod", "command", command, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
+		return nil, err
+	}
+	files, err := parseInventory(stdo)
+	if err != nil {
+		return nil, err
+	}
+	var name types.NamespacedName
+	if keyInfo.TangServer != nil {
+		name = client.ObjectKeyFromObject(keyInfo.TangServer)
+	}
+	inventory := make([]KeyMetadata, 0, len(files))
+	keys := make([]KeyMetadata, 0, len(files))
+	for _, f := range files {
+		if isForbiddenPath(f.Name) {
+			continue
+		}
+		if !isValidKeyFileName(f.Name) {
+			l.Info("Ignoring unexpected entry in key directory", "entry", f.Name)
+			continue
+		}
+		fpath := keyInfo.DbPath + "/" + f.Name
+		m, cached := keyInfo.inventory.lookup(name, f)
+		if !cached {
+			content, err := readKeyFile(ctx, keyInfo, fpath)
+			if err != nil {
+				// Nothing is known about a file that could not be fetched, so it is not cached
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				l.Error(err, "Ignoring unreadable key file", "key path", fpath)
+				continue
+			}
+			jwk, err := parseJWK(content)
+			m = KeyMetadata{File: f, JWK: jwk, err: err}
+			if err == nil {
+				m.Sha1 = getSHA(ctx, SHA1, jwk, fpath)
+				m.Sha256 = getSHA(ctx, SHA256, jwk, fpath)
+			}
+		}
+		inventory = append(inventory, m)
+		if m.err != nil {
+			l.Info("Ignoring key file that is not a JWK", "key path", fpath, "error", m.err.Error())
+			continue
+		}
+		keys = append(keys, m)
+	}
+	keyInfo.inventory.replace(name, inventory)
+	return keys, nil
+}
+
+// formatKeyTime formats a key file time as reported in the key status
+func formatKeyTime(t time.Time) string {
+	return t.Format(KEY_TIME_LAYOUT)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("TangServer controller key inventory", func() {
+	const keyPath = "/var/db/tang"
+
+	Context("When parsing the key inventory", func() {
+		It("Should parse file metadata and content hashes", func() {
+			hash := strings.Repeat("ab", 32)
+			output := "key.jwk\t1024\t42\t1672531200.5000000000\t1672531201\n" +
+				".hidden.jwk\t512\t43\t1672531100.0000000000\t1672531300.0000000000\n" +
+				hash + "  " + keyPath + "/key.jwk\n"
+			files, err := parseInventory(output)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+			Expect(files[0].Name).To(Equal(".hidden.jwk"))
+			Expect(files[0].Hash).To(BeEmpty())
+			Expect(files[1]).To(Equal(KeyFileInfo{
+				Name:       "key.jwk",
+				Size:       1024,
+				Inode:      42,
+				ModTime:    time.Date(2023, 1, 1, 0, 0, 0, 500000000, time.UTC),
+				ChangeTime: time.Date(2023, 1, 1, 0, 0, 1, 0, time.UTC),
+				Hash:       hash,
+			}))
+			Expect(formatKeyTime(files[1].ModTime)).To(Equal("2023-01-01 00:00:00.500000000 +0000"))
+		})
+
+		It("Should reject unexpected output", func() {
+			for _, output := range []string{
+				"key.jwk\t1024\n",
+				"key.jwk\tsize\t42\t1672531200\t1672531200\n",
+				"key.jwk\t1024\t42\tnow\t1672531200\n",
+			} {
+				_, err := parseInventory(output)
+				Expect(err).To(HaveOccurred(), output)
+			}
+		})
+	})
+
+	Context("When reading the key inventory of a tang pod", func() {
+		var (
+			executor *fakePodExecutor
+			keyInfo  KeyObtainInfo
+			cache    *keyInventoryCache
+		)
+		ctx := context.Background()
+
+		// cats returns the key files fetched since the previous call
+		cats := func() []string {
+			fetched := make([]string, 0)
+			for _, command := range executor.commands {
+				if strings.HasPrefix(command, "cat ") {
+					fetched = append(fetched, strings.TrimPrefix(command, "cat "+keyPath+"/"))
+				}
+			}
+			executor.commands = nil
+			return fetched
+		}
+
+		BeforeEach(func() {
+			executor = newFakePodExecutor()
+			cache = &keyInventoryCache{}
+			keyInfo = KeyObtainInfo{
+				PodName:   "test-pod",
+				Namespace: "default",
+				DbPath:    keyPath,
+				TangServer: &daemonsv1alpha1.TangServer{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-tangserver-inventory", Namespace: "default"},
+				},
+				Executor:  executor,
+				stats:     &execStats{},
+				inventory: cache,
+			}
+		})
+
+		It("Should list the whole directory in a single exec", func() {
+			signing, exchange := executor.addKeyPair(keyPath)
+			executor.addFile(keyPath+"/"+KEY_STATUS_FILE_NAME, []byte("{}"))
+			keys, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+			for _, key := range keys {
+				Expect(key.File.Hash).To(HaveLen(64))
+				if key.File.Name == signing {
+					Expect(key.Sha256).To(Equal(strings.TrimSuffix(signing, ".jwk")))
+				}
+			}
+			Expect(executor.commands[0]).To(HavePrefix("find "))
+			Expect(cats()).To(ConsistOf(signing, exchange))
+			Expect(keyInfo.stats.count.Load()).To(Equal(int32(3)))
+		})
+
+		It("Should only fetch the key files changed since the previous inventory", func() {
+			signing, exchange := executor.addKeyPair(keyPath)
+			_, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cats()).To(ConsistOf(signing, exchange))
+
+			_, err = readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cats()).To(BeEmpty())
+
+			newSigning, newExchange := executor.addKeyPair(keyPath)
+			Expect(moveKeyFile(ctx, keyInfo, signing, "."+signing)).To(Succeed())
+			executor.addFile(keyPath+"/"+exchange, fakeGenerateJWK("ECMR", []string{"deriveKey"}))
+			executor.commands = nil
+			keys, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(4))
+			// The hidden key was renamed, not changed, so it is not fetched again
+			Expect(cats()).To(ConsistOf(exchange, newSigning, newExchange))
+		})
+
+		It("Should not cache key files that could not be fetched", func() {
+			signing, exchange := executor.addKeyPair(keyPath)
+			executor.failOn = []string{"cat " + keyPath + "/" + signing}
+			keys, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0].File.Name).To(Equal(exchange))
+			executor.failOn = nil
+			cats()
+			keys, err = readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(HaveLen(2))
+			Expect(cats()).To(ConsistOf(signing))
+		})
+
+		It("Should cache files that are not keys", func() {
+			executor.addFile(keyPath+"/corrupted.jwk", []byte("not a key"))
+			for i := 0; i < 2; i++ {
+				keys, err := readKeyInventory(ctx, keyInfo)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(keys).To(BeEmpty())
+			}
+			Expect(cats()).To(ConsistOf("corrupted.jwk"))
+		})
+
+		It("Should forget the inventory of deleted TangServers", func() {
+			executor.addKeyPair(keyPath)
+			_, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			name := types.NamespacedName{Name: "test-tangserver-inventory", Namespace: "default"}
+			Expect(cache.entries[name]).To(HaveLen(2))
+			cache.forget(name)
+			Expect(cache.entries).ToNot(HaveKey(name))
+		})
+	})
+
+	Context("When accounting pod execs", func() {
+		It("Should update the exec metrics", func() {
+			before := testutil.ToFloat64(podExecTotal.WithLabelValues("tangd-keygen", "success"))
+			stats := &execStats{}
+			stats.record([]string{"/usr/libexec/tangd-keygen", keyPath + "/"}, time.Second, nil, false)
+			stats.record([]string{"find", keyPath + "/"}, 2*time.Second, context.DeadlineExceeded, true)
+			Expect(testutil.ToFloat64(podExecTotal.WithLabelValues("tangd-keygen", "success"))).To(Equal(before + 1))
+			Expect(stats.count.Load()).To(Equal(int32(2)))
+			Expect(stats.timeouts.Load()).To(Equal(int32(1)))
+			Expect(time.Duration(stats.duration.Load())).To(Equal(3 * time.Second))
+			stats.observe()
+			(*execStats)(nil).observe()
+		})
+	})
+})
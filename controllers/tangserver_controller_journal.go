@@ -0,0 +1,247 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Rotation journal file, stored in the key directory so that it lives in the same volume as the keys it moves
+const ROTATION_JOURNAL_FILE_NAME = "rotation_journal.json"
+
+// Schema version of the rotation journal written
+const ROTATION_JOURNAL_SCHEMA_VERSION = 1
+
+// RotationMove is a key file renamed to hide it
+type RotationMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RotationJournal records the moves of a rotation before performing them, so that a rotation interrupted
+// by an operator restart can be resumed or rolled back by the next reconciliation
+type RotationJournal struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	KeyFileName   string `json:"keyFileName"`
+	Sha1          string `json:"sha1,omitempty"`
+	Sha256        string `json:"sha256,omitempty"`
+	// RotatedBy is the agent requesting the rotation, as recorded in the key status file
+	RotatedBy string `json:"rotatedBy,omitempty"`
+	// Started is the time the rotation was planned
+	Started time.Time      `json:"started"`
+	Moves   []RotationMove `json:"moves"`
+}
+
+// RotationRecovery is the outcome of recovering an interrupted rotation
+type RotationRecovery uint8
+
+const (
+	// ROTATION_NOT_INTERRUPTED means there was no rotation to recover
+	ROTATION_NOT_INTERRUPTED RotationRecovery = iota
+	// ROTATION_RESUMED means the pending moves of the rotation were performed
+	ROTATION_RESUMED
+	// ROTATION_ROLLED_BACK means the moves already performed were undone
+	ROTATION_ROLLED_BACK
+)
+
+// rotationJournalPath returns the path of the rotation journal
+func rotationJournalPath(keyInfo KeyObtainInfo) string {
+	return keyInfo.DbPath + "/" + ROTATION_JOURNAL_FILE_NAME
+}
+
+// rotationJournalCommand returns the command printing the rotation journal, which prints nothing if there is none
+func rotationJournalCommand(dbPath string) []string {
+	return []string{"find", dbPath + "/", "-mindepth", "1", "-maxdepth", "1", "-type", "f",
+		"-name", ROTATION_JOURNAL_FILE_NAME, "-exec", "cat", "{}", "+"}
+}
+
+// validate checks that the journal only hides key files of the key directory, as its moves are executed
+func (j RotationJournal) validate() error {
+	if j.SchemaVersion != ROTATION_JOURNAL_SCHEMA_VERSION {
+		return fmt.Errorf("unsupported rotation journal schema version %d", j.SchemaVersion)
+	}
+	for _, m := range j.Moves {
+		if !isValidKeyFileName(m.From) || isHiddenKeyFile(m.From) || m.To != "."+m.From {
+			return fmt.Errorf("invalid rotation journal move %q to %q", m.From, m.To)
+		}
+	}
+	return nil
+}
+
+// newRotationJournal plans the rotation of the key pair of the signing key provided. Keys that can not be
+// reliably paired hide the unadvertised active keys, as previous operator versions did, except the exchange
+// keys of other key pairs and the key files to keep, such as the replacement key pair just generated
+func newRotationJournal(ctx context.Context, keyInfo KeyObtainInfo, keyFileName string, inventory []KeyMetadata, keep []string) RotationJournal {
+	j := RotationJournal{
+		SchemaVersion: ROTATION_JOURNAL_SCHEMA_VERSION,
+		KeyFileName:   keyFileName,
+		Started:       time.Now().UTC(),
+		Moves:         []RotationMove{{From: keyFileName, To: "." + keyFileName}},
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, name := range keep {
+		kept[name] = true
+	}
+	for _, p := range pairKeys(inventory).Pairs {
+		if p.Signing.File.Name == keyFileName {
+			j.Sha1, j.Sha256 = p.Signing.Sha1, p.Signing.Sha256
+			j.Moves = append(j.Moves, RotationMove{From: p.Exchange.File.Name, To: "." + p.Exchange.File.Name})
+			return j
+		}
+		kept[p.Exchange.File.Name] = true
+	}
+	for _, k := range activeKeysFromInventory(ctx, keyInfo, inventory, ONLY_UNADVERTISED) {
+		if !kept[k.FileName] {
+			j.Moves = append(j.Moves, RotationMove{From: k.FileName, To: "." + k.FileName})
+		}
+	}
+	return j
+}
+
+// readRotationJournal returns the rotation journal of the key directory, nil if no rotation is in progress
+func readRotationJournal(ctx context.Context, keyInfo KeyObtainInfo) (*RotationJournal, error) {
+	stdo, stde, err := keyInfo.podExec(ctx, nil, rotationJournalCommand(keyInfo.DbPath)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rotation journal: %w, %s", err, stde)
+	}
+	if strings.TrimSpace(stdo) == "" {
+		return nil, nil
+	}
+	var j RotationJournal
+	if err := json.Unmarshal([]byte(stdo), &j); err != nil {
+		return nil, fmt.Errorf("unable to parse rotation journal: %w", err)
+	}
+	if err := j.validate(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// writeRotationJournal stores the rotation journal atomically
+func writeRotationJournal(ctx context.Context, keyInfo KeyObtainInfo, j RotationJournal) error {
+	content, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return writePodFileAtomically(ctx, keyInfo, rotationJournalPath(keyInfo), content)
+}
+
+// removeRotationJournal removes the rotation journal, once its rotation is complete or rolled back
+func removeRotationJournal(ctx context.Context, keyInfo KeyObtainInfo) error {
+	_, _, err := keyInfo.podExec(ctx, nil, "rm", "-f", rotationJournalPath(keyInfo))
+	return err
+}
+
+// moveKeyFile renames a key file of the key directory
+func moveKeyFile(ctx context.Context, keyInfo KeyObtainInfo, from string, to string) error {
+	command := []string{"mv", keyInfo.DbPath + "/" + from, keyInfo.DbPath + "/" + to}
+	_, stde, err := keyInfo.podExec(ctx, nil, command...)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Unable to move key file", "command", command, "stderror", stde)
+	}
+	return err
+}
+
+// executeRotation journals the rotation and performs its moves. The journal is only removed once every move
+// is done, so that a rotation interrupted at any point is found by the next reconciliation
+func executeRotation(ctx context.Context, keyInfo KeyObtainInfo, j RotationJournal) error {
+	if err := writeRotationJournal(ctx, keyInfo, j); err != nil {
+		return fmt.Errorf("unable to write rotation journal: %w", err)
+	}
+	for _, m := range j.Moves {
+		if err := moveKeyFile(ctx, keyInfo, m.From, m.To); err != nil {
+			return err
+		}
+	}
+	return removeRotationJournal(ctx, keyInfo)
+}
+
+// recoverRotation completes or undoes the rotation recorded in the journal, if any. A rotation is resumed
+// when the files still to hide are present and the key is still requested to be hidden. Otherwise the
+// files already hidden are restored, so that signing and exchange keys are never left in different states
+func recoverRotation(ctx context.Context, keyInfo KeyObtainInfo) (*RotationJournal, RotationRecovery, error) {
+	l := log.FromContext(ctx)
+	j, err := readRotationJournal(ctx, keyInfo)
+	if err != nil || j == nil {
+		return j, ROTATION_NOT_INTERRUPTED, err
+	}
+	l.Info("Recovering interrupted rotation", "Journal", j)
+	inventory, err := readKeyInventory(ctx, keyInfo)
+	if err != nil {
+		return j, ROTATION_NOT_INTERRUPTED, err
+	}
+	present := make(map[string]bool, len(inventory))
+	for _, k := range inventory {
+		present[k.File.Name] = true
+	}
+	var done, pending []RotationMove
+	for _, m := range j.Moves {
+		switch {
+		case present[m.To] && !present[m.From]:
+			done = append(done, m)
+		case present[m.From] && !present[m.To]:
+			pending = append(pending, m)
+		case !present[m.From] && !present[m.To]:
+			// A file to hide vanished, the rotation can not be completed
+			l.Info("Key file of interrupted rotation not found", "File", m.From)
+		default:
+			return j, ROTATION_NOT_INTERRUPTED, fmt.Errorf("both %s and %s exist, unable to recover rotation", m.From, m.To)
+		}
+	}
+	recovery := ROTATION_RESUMED
+	moves := pending
+	if len(done)+len(pending) < len(j.Moves) || !hidingRequested(keyInfo, j) {
+		recovery = ROTATION_ROLLED_BACK
+		moves = make([]RotationMove, 0, len(done))
+		for _, m := range done {
+			moves = append(moves, RotationMove{From: m.To, To: m.From})
+		}
+	}
+	for _, m := range moves {
+		if err := moveKeyFile(ctx, keyInfo, m.From, m.To); err != nil {
+			return j, ROTATION_NOT_INTERRUPTED, err
+		}
+	}
+	return j, recovery, removeRotationJournal(ctx, keyInfo)
+}
+
+// hidingRequested returns true if the key rotated by the journal is still requested to be hidden in spec, or
+// the key rotation policy or required active key pairs that rotated it are still configured. Journals without
+// thumbprints can not be checked, and are considered requested
+func hidingRequested(keyInfo KeyObtainInfo, j *RotationJournal) bool {
+	switch j.RotatedBy {
+	case KEY_HIDDEN_BY_ROTATION_POLICY:
+		return keyInfo.TangServer.Spec.KeyRotation != nil
+	case KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS:
+		return keyInfo.TangServer.Spec.RequiredActiveKeyPairs > 0
+	}
+	if j.Sha1 == "" && j.Sha256 == "" {
+		return true
+	}
+	for _, hk := range keyInfo.TangServer.Spec.HiddenKeys {
+		if hiddenKeyHasThumbprint(hk, j.Sha1, j.Sha256) {
+			return true
+		}
+	}
+	return false
+}
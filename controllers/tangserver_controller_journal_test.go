@@ -0,0 +1,165 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller rotation journal", func() {
+	const keyPath = "/var/db/tang"
+	var (
+		executor          *fakePodExecutor
+		keyInfo           KeyObtainInfo
+		reconciler        *TangServerReconciler
+		recorder          *events.FakeRecorder
+		signing, exchange string
+	)
+	ctx := context.Background()
+
+	// planRotation returns the journal of the rotation of the signing key
+	planRotation := func() RotationJournal {
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		return newRotationJournal(ctx, keyInfo, signing, inventory, nil)
+	}
+
+	// interruptRotation journals the rotation and only performs its first move, as a crashed operator would
+	interruptRotation := func() RotationJournal {
+		j := planRotation()
+		Expect(writeRotationJournal(ctx, keyInfo, j)).To(Succeed())
+		Expect(moveKeyFile(ctx, keyInfo, j.Moves[0].From, j.Moves[0].To)).To(Succeed())
+		return j
+	}
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		keyInfo, reconciler, recorder = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{}, executor)
+		signing, exchange = executor.addKeyPair(keyPath)
+		executor.addKeyPair(keyPath)
+		keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+			{Sha256: strings.TrimSuffix(signing, ".jwk")},
+		}
+	})
+
+	It("Should plan hiding the signing key and its exchange key", func() {
+		j := planRotation()
+		Expect(j.Moves).To(Equal([]RotationMove{{From: signing, To: "." + signing}, {From: exchange, To: "." + exchange}}))
+		Expect(j.Sha256).To(Equal(strings.TrimSuffix(signing, ".jwk")))
+	})
+
+	It("Should journal the rotation before moving keys and remove the journal once done", func() {
+		executor.commands = nil
+		Expect(executeRotation(ctx, keyInfo, planRotation())).To(Succeed())
+		entries := executor.entries(keyPath)
+		Expect(entries).To(ContainElements("."+signing, "."+exchange))
+		Expect(entries).ToNot(ContainElement(ROTATION_JOURNAL_FILE_NAME))
+		journaled, moved := -1, -1
+		for i, command := range executor.commands {
+			if strings.HasPrefix(command, "mv -f "+keyPath+"/"+ROTATION_JOURNAL_FILE_NAME) {
+				journaled = i
+			} else if strings.HasPrefix(command, "mv "+keyPath+"/"+signing) {
+				moved = i
+			}
+		}
+		Expect(journaled).To(BeNumerically(">=", 0))
+		Expect(moved).To(BeNumerically(">", journaled))
+	})
+
+	It("Should do nothing without an interrupted rotation", func() {
+		j, recovery, err := recoverRotation(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(j).To(BeNil())
+		Expect(recovery).To(Equal(ROTATION_NOT_INTERRUPTED))
+	})
+
+	It("Should resume an interrupted rotation", func() {
+		interruptRotation()
+		Expect(executor.entries(keyPath)).To(ContainElement(exchange))
+		_, recovery, err := recoverRotation(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recovery).To(Equal(ROTATION_RESUMED))
+		entries := executor.entries(keyPath)
+		Expect(entries).To(ContainElements("."+signing, "."+exchange))
+		Expect(entries).ToNot(ContainElement(BeElementOf(signing, exchange, ROTATION_JOURNAL_FILE_NAME)))
+	})
+
+	It("Should roll back an interrupted rotation no longer requested", func() {
+		interruptRotation()
+		keyInfo.TangServer.Spec.HiddenKeys = nil
+		_, recovery, err := recoverRotation(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recovery).To(Equal(ROTATION_ROLLED_BACK))
+		entries := executor.entries(keyPath)
+		Expect(entries).To(ContainElements(signing, exchange))
+		Expect(entries).ToNot(ContainElement(BeElementOf("."+signing, "."+exchange, ROTATION_JOURNAL_FILE_NAME)))
+	})
+
+	It("Should roll back an interrupted rotation whose keys vanished", func() {
+		interruptRotation()
+		_, _, err := executor.Exec(ctx, "default", "test-pod", "", []string{"rm", keyPath + "/" + exchange}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, recovery, err := recoverRotation(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recovery).To(Equal(ROTATION_ROLLED_BACK))
+		Expect(executor.entries(keyPath)).To(ContainElement(signing))
+	})
+
+	It("Should refuse journals moving files out of the key directory", func() {
+		for _, journal := range []string{
+			`{"schemaVersion":1,"keyFileName":"a.jwk","moves":[{"from":"../a.jwk","to":".../a.jwk"}]}`,
+			`{"schemaVersion":1,"keyFileName":"a.jwk","moves":[{"from":"a.jwk","to":"b.jwk"}]}`,
+			`{"schemaVersion":2,"keyFileName":"a.jwk","moves":[]}`,
+		} {
+			executor.addFile(keyPath+"/"+ROTATION_JOURNAL_FILE_NAME, []byte(journal))
+			executor.commands = nil
+			_, _, err := recoverRotation(ctx, keyInfo)
+			Expect(err).To(HaveOccurred(), journal)
+			for _, command := range executor.commands {
+				Expect(command).ToNot(HavePrefix("mv "))
+			}
+		}
+	})
+
+	It("Should report how an interrupted rotation was recovered", func() {
+		interruptRotation()
+		Expect(reconciler.recoverRotation(ctx, keyInfo)).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("KeyRotationResumed")))
+		condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions, daemonsv1alpha1.ConditionRotationInProgress)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Message).To(ContainSubstring(signing))
+	})
+
+	It("Should not handle hidden keys while a rotation can not be recovered", func() {
+		interruptRotation()
+		// The exchange key was regenerated while hidden, so it is not known which one to keep
+		Expect(moveKeyFile(ctx, keyInfo, exchange, "."+exchange)).To(Succeed())
+		executor.addFile(keyPath+"/"+exchange, fakeGenerateJWK("ECMR", []string{"deriveKey"}))
+		Expect(reconciler.recoverRotation(ctx, keyInfo)).To(BeFalse())
+		Expect(recorder.Events).To(Receive(ContainSubstring("KeyRotationRecoveryFailed")))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+		Expect(executor.entries(keyPath)).To(ContainElement(ROTATION_JOURNAL_FILE_NAME))
+	})
+})
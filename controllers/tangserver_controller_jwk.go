@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1" // Register hashes used for thumbprints
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JWK contains the JSON Web Key members required to identify tang keys
+type JWK struct {
+	Kty    string   `json:"kty"`
+	Alg    string   `json:"alg,omitempty"`
+	Use    string   `json:"use,omitempty"`
+	KeyOps []string `json:"key_ops,omitempty"`
+	Crv    string   `json:"crv,omitempty"`
+	X      string   `json:"x,omitempty"`
+	Y      string   `json:"y,omitempty"`
+	N      string   `json:"n,omitempty"`
+	E      string   `json:"e,omitempty"`
+	K      string   `json:"k,omitempty"`
+}
+
+// thumbprintHashes maps the jose thumbprint algorithm names to their hash
+var thumbprintHashes = map[SHAType]crypto.Hash{
+	SHA1:   crypto.SHA1,
+	SHA256: crypto.SHA256,
+	SHA384: crypto.SHA384,
+	SHA512: crypto.SHA512,
+}
+
+// parseJWK parses the JSON content of a key file
+func parseJWK(data []byte) (*JWK, error) {
+	jwk := &JWK{}
+	if err := json.Unmarshal(data, jwk); err != nil {
+		return nil, fmt.Errorf("unable to parse JWK: %v", err)
+	}
+	if jwk.Kty == "" {
+		return nil, fmt.Errorf("unable to parse JWK: missing kty")
+	}
+	return jwk, nil
+}
+
+// thumbprintMembers returns the required members of the key, as specified by RFC 7638
+func (j *JWK) thumbprintMembers() (map[string]string, error) {
+	var members map[string]string
+	switch j.Kty {
+	case "EC":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X, "y": j.Y}
+	case "OKP":
+		members = map[string]string{"crv": j.Crv, "kty": j.Kty, "x": j.X}
+	case "RSA":
+		members = map[string]string{"e": j.E, "kty": j.Kty, "n": j.N}
+	case "oct":
+		members = map[string]string{"k": j.K, "kty": j.Kty}
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	for name, value := range members {
+		if value == "" {
+			return nil, fmt.Errorf("%s key without %s member", j.Kty, name)
+		}
+	}
+	return members, nil
+}
+
+// Thumbprint returns the base64url encoded RFC 7638 thumbprint of the key, using the hash provided
+func (j *JWK) Thumbprint(shaType SHAType) (string, error) {
+	hash, found := thumbprintHashes[shaType]
+	if !found {
+		return "", fmt.Errorf("unsupported thumbprint hash %d", shaType)
+	}
+	members, err := j.thumbprintMembers()
+	if err != nil {
+		return "", err
+	}
+	// Members are marshalled in lexicographic order and without whitespace, as RFC 7638 requires
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(members); err != nil {
+		return "", err
+	}
+	h := hash.New()
+	h.Write(bytes.TrimRight(buf.Bytes(), "\n"))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// IsSigningKey returns true if the key verifies signatures, so that it is advertised by tang.
+// As jose does, key_ops takes precedence over use, and the algorithm is only checked when none is present
+func (j *JWK) IsSigningKey() bool {
+	if len(j.KeyOps) > 0 {
+		return contains(j.KeyOps, "verify")
+	}
+	if j.Use != "" {
+		return j.Use == "sig"
+	}
+	switch {
+	case j.Alg == "EdDSA":
+		return true
+	case strings.HasPrefix(j.Alg, "ES"), strings.HasPrefix(j.Alg, "PS"),
+		strings.HasPrefix(j.Alg, "RS"), strings.HasPrefix(j.Alg, "HS"):
+		return true
+	}
+	// ECMR and any other algorithm are exchange keys
+	return false
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TangServer controller JWK functions", func() {
+
+	// RSA key of RFC 7638, section 3.1
+	const rfc7638Key = `{
+		"kty": "RSA",
+		"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		"e": "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29"
+	}`
+
+	Context("When computing thumbprints", func() {
+		It("Should match the RFC 7638 example", func() {
+			jwk, err := parseJWK([]byte(rfc7638Key))
+			Expect(err).ToNot(HaveOccurred())
+			thp, err := jwk.Thumbprint(SHA256)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(thp).To(Equal("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"))
+		})
+
+		It("Should compute the thumbprints of tang keys", func() {
+			content := fakeGenerateJWK("ES512", []string{"sign", "verify"})
+			jwk, err := parseJWK(content)
+			Expect(err).ToNot(HaveOccurred())
+			for alg, shaType := range map[string]SHAType{"S1": SHA1, "S256": SHA256} {
+				expected, err := fakeThumbprint(content, alg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jwk.Thumbprint(shaType)).To(Equal(expected))
+			}
+			for shaType, length := range map[SHAType]int{SHA1: 27, SHA256: 43, SHA384: 64, SHA512: 86} {
+				thp, err := jwk.Thumbprint(shaType)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(thp).To(HaveLen(length))
+			}
+		})
+
+		It("Should fail with unknown hashes or incomplete keys", func() {
+			jwk, err := parseJWK([]byte(rfc7638Key))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = jwk.Thumbprint(UNKNOWN_SHA)
+			Expect(err).To(HaveOccurred())
+			_, err = (&JWK{Kty: "EC", Crv: "P-521", X: "x"}).Thumbprint(SHA256)
+			Expect(err).To(HaveOccurred())
+			_, err = (&JWK{Kty: "unknown"}).Thumbprint(SHA256)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When parsing keys", func() {
+		It("Should reject content that is not a JWK", func() {
+			for _, content := range []string{"", "not json", "{}", `{"keys": []}`} {
+				_, err := parseJWK([]byte(content))
+				Expect(err).To(HaveOccurred(), content)
+			}
+		})
+
+		It("Should tell signing keys from exchange keys", func() {
+			signing := []string{
+				`{"kty": "EC", "alg": "ES512", "key_ops": ["sign", "verify"]}`,
+				`{"kty": "EC", "alg": "ES512"}`,
+				`{"kty": "EC", "use": "sig"}`,
+				`{"kty": "OKP", "alg": "EdDSA"}`,
+			}
+			exchange := []string{
+				`{"kty": "EC", "alg": "ECMR", "key_ops": ["deriveKey"]}`,
+				`{"kty": "EC", "alg": "ECMR"}`,
+				`{"kty": "EC", "alg": "ES512", "key_ops": ["deriveKey"]}`,
+				`{"kty": "EC", "use": "enc"}`,
+				`{"kty": "EC"}`,
+			}
+			for _, content := range signing {
+				jwk, err := parseJWK([]byte(content))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jwk.IsSigningKey()).To(BeTrue(), content)
+			}
+			for _, content := range exchange {
+				jwk, err := parseJWK([]byte(content))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(jwk.IsSigningKey()).To(BeFalse(), content)
+			}
+		})
+	})
+})
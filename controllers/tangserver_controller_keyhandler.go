@@ -17,9 +17,18 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type SHAType uint8
@@ -30,6 +39,8 @@ const (
 	UNKNOWN_SHA SHAType = iota
 	SHA256
 	SHA1
+	SHA384
+	SHA512
 )
 
 const (
@@ -45,19 +56,41 @@ const (
 	ONLY_UNADVERTISED
 )
 
-const DEFAULT_DEPLOYMENT_KEY_PATH = "/var/db/tang"
+const DEFAULT_DEPLOYMENT_KEY_PATH = daemonsv1alpha1.DefaultKeyPath
 
-var FORBIDDEN_PATH_MAP = map[string]string{
-	".":          "FORBIDDEN",
-	"..":         "FORBIDDEN",
-	"lost+found": "FORBIDDEN",
+// forbiddenPaths contains the entries of the key directory that must never be considered keys.
+// It is never modified, so it can be safely read from concurrent reconciliations
+var forbiddenPaths = map[string]struct{}{
+	".":                            {},
+	"..":                           {},
+	"lost+found":                   {},
+	KEY_STATUS_FILE_NAME:           {},
+	KEY_STATUS_FILE_NAME + ".lock": {},
+	KEY_STATUS_FILE_NAME + TEMPORARY_FILE_SUFFIX:       {},
+	ROTATION_JOURNAL_FILE_NAME:                         {},
+	ROTATION_JOURNAL_FILE_NAME + TEMPORARY_FILE_SUFFIX: {},
 }
 
+// keyPathRegexp matches the key paths that are safe to use as arguments of the commands run in tang pods
+var keyPathRegexp = regexp.MustCompile(daemonsv1alpha1.KeyPathPattern)
+
+// keyFileNameRegexp matches the key directory entries that are safe to use as arguments of the commands run in tang pods
+var keyFileNameRegexp = regexp.MustCompile(`^\.?[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
 type KeyObtainInfo struct {
 	PodName    string
 	Namespace  string
 	DbPath     string
 	TangServer *daemonsv1alpha1.TangServer
+	// Executor runs the commands in the pod
+	Executor PodExecutor
+	// ExecTimeout bounds each command executed in the pod (no bound if zero)
+	ExecTimeout time.Duration
+
+	// stats, if set, accounts the commands executed in the pod
+	stats *execStats
+	// inventory, if set, caches the metadata of the key files across reconciliations
+	inventory *keyInventoryCache
 }
 
 type KeyAssociationInfo struct {
@@ -65,11 +98,6 @@ type KeyAssociationInfo struct {
 	KeyAssoc KeyAssociation
 }
 
-type KeyRotateInfo struct {
-	KeyInfo     *KeyObtainInfo
-	KeyFileName string
-}
-
 // getDefaultKeyPath returns directory where keys are dumped, typically /var/db/tang
 func getDefaultKeyPath(cr *daemonsv1alpha1.TangServer) string {
 	if cr.Spec.KeyPath != "" {
@@ -78,302 +106,161 @@ func getDefaultKeyPath(cr *daemonsv1alpha1.TangServer) string {
 	return DEFAULT_DEPLOYMENT_KEY_PATH
 }
 
+// podExec executes the command in the pod keys are obtained from, bounded by the exec timeout.
+// The command is run as provided, without a shell, with stdin (if not nil) as its standard input
+func (k KeyObtainInfo) podExec(ctx context.Context, stdin io.Reader, command ...string) (string, string, error) {
+	if k.ExecTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, k.ExecTimeout)
+		defer cancel()
+	}
+	if k.Executor == nil {
+		return "", "", fmt.Errorf("no pod executor available, Command: %s", command)
+	}
+	start := time.Now()
+	stdo, stde, err := k.Executor.Exec(ctx, k.Namespace, k.PodName, "", command, stdin)
+	k.stats.record(command, time.Since(start), err, errors.Is(err, context.DeadlineExceeded))
+	return stdo, stde, err
+}
+
+// timedOut returns true if any command executed in the pod was aborted because of a timeout
+func (k KeyObtainInfo) timedOut() bool {
+	return k.stats != nil && k.stats.timeouts.Load() > 0
+}
+
+// readKeyFile fetches the content of the key file provided
+func readKeyFile(ctx context.Context, keyInfo KeyObtainInfo, filePath string) ([]byte, error) {
+	stdo, stde, err := keyInfo.podExec(ctx, nil, "cat", filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file %s: %w, %s", filePath, err, stde)
+	}
+	return []byte(stdo), nil
+}
+
 // keyToAdvertise returns if a key is to be advertised (is a signing key)
-func keyToAdvertise(keyInfo KeyObtainInfo, path string) bool {
-	command := "jose jwk use --input " + path + " --required --use=verify"
-	_, _, notAdvertisable := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
-	if notAdvertisable != nil {
-		GetLogInstance().Info("Key not advertisable", "key path", path)
+func keyToAdvertise(ctx context.Context, jwk *JWK, path string) bool {
+	l := log.FromContext(ctx)
+	if !jwk.IsSigningKey() {
+		l.Info("Key not advertisable", "key path", path)
 		return false
 	}
-	GetLogInstance().Info("Key advertisable", "key path", path)
+	l.Info("Key advertisable", "key path", path)
 	return true
 }
 
 // ignoreKey function checks if key must be ignored
-func ignoreKey(keyInfo KeyObtainInfo, advertised KeyAdvertisingType, keypath string) bool {
-	if keyToAdvertise(keyInfo, keypath) {
+func ignoreKey(ctx context.Context, jwk *JWK, advertised KeyAdvertisingType, keypath string) bool {
+	l := log.FromContext(ctx)
+	if keyToAdvertise(ctx, jwk, keypath) {
 		if advertised == ONLY_UNADVERTISED {
-			GetLogInstance().Info("Key ignored", "key path", keypath)
+			l.Info("Key ignored", "key path", keypath)
 			return true
 		}
 	} else {
 		if advertised == ONLY_ADVERTISED {
-			GetLogInstance().Info("Key ignored", "key path", keypath)
+			l.Info("Key ignored", "key path", keypath)
 			return true
 		}
 	}
-	GetLogInstance().Info("Key not ignored", "key path", keypath)
+	l.Info("Key not ignored", "key path", keypath)
 	return false
 }
 
-// writeStatusFile function
-func writeStatusFile(keyInfo KeyObtainInfo, sha1 string, sha256 string, statusSigning string, statusEncryption string) error {
-	if len(sha1) > 0 && len(sha256) > 0 && len(statusSigning) > 0 && len(statusEncryption) > 0 {
-		GetLogInstance().Info("writeStatusFile", "sha1", sha1, "sha256", sha256, "statusSigning", statusSigning, "statusEncryption", statusEncryption)
-		k := KeyAssociationInfo{
-			KeyInfo: &keyInfo,
-			KeyAssoc: KeyAssociation{
-				Sha1:          sha1,
-				Sha256:        sha256,
-				SigningKey:    statusSigning,
-				EncriptionKey: statusEncryption,
-			},
+// isValidKeyPath returns true if the key path is absolute, only contains safe characters and has no '..' element
+func isValidKeyPath(keyPath string) bool {
+	if !keyPathRegexp.MatchString(keyPath) {
+		return false
+	}
+	for _, elem := range strings.Split(keyPath, "/") {
+		if elem == ".." {
+			return false
 		}
-		GetLogInstance().Info("Dumping Key Association", "Key Association", k)
-		return dumpKeyAssociation(k)
 	}
-	return nil
+	return true
 }
 
-func updateForbiddenMap(forbiddenKey string) {
-	FORBIDDEN_PATH_MAP[forbiddenKey] = "FORBIDDEN"
+// isValidKeyFileName returns true if the key directory entry only contains safe characters and is not . or ..
+func isValidKeyFileName(name string) bool {
+	return keyFileNameRegexp.MatchString(name)
 }
 
-// readActiveKeys function return active key list
-func readActiveKeys(keyInfo KeyObtainInfo, onlyAdvertised KeyAdvertisingType) ([]daemonsv1alpha1.TangServerActiveKeys, error) {
-	command := "ls " + keyInfo.DbPath
-	stdo, stde, err := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
-	} else {
-		GetLogInstance().Info("Executed active keys retrieval command correctly", "Active keys:", stdo)
-		keys := strings.Split(stdo, "\n")
-		activeKeys := make([]daemonsv1alpha1.TangServerActiveKeys, 0)
-		var statusSigning string
-		var statusEncryption string
-		var ignoredKey bool
-		var sha1 string
-		var sha256 string
-		for _, k := range keys {
-			if len(k) > 0 {
-				if _, forbidden := FORBIDDEN_PATH_MAP[k]; forbidden {
-					continue
-				}
-				k = strings.TrimLeft(strings.TrimRight(k, "\n"), "\n")
-				k = strings.TrimLeft(strings.TrimRight(k, "\r"), "\r")
-				fpath := keyInfo.DbPath + "/" + k
-				if ignoreKey(keyInfo, onlyAdvertised, fpath) {
-					statusEncryption = fpath
-					ignoredKey = true
-				} else {
-					statusSigning = fpath
-					ignoredKey = false
-				}
-				if !ignoredKey {
-					sha1 = getSHA(SHA1, keyInfo, fpath)
-					sha256 = getSHA(SHA256, keyInfo, fpath)
-					activeKeys = append(activeKeys, daemonsv1alpha1.TangServerActiveKeys{
-						Sha1:      sha1,
-						Sha256:    sha256,
-						Generated: getLastTime(CREATION, keyInfo, fpath),
-						FileName:  k,
-					})
-				}
-				if err := writeStatusFile(keyInfo, sha1, sha256, statusSigning, statusEncryption); err != nil {
-					GetLogInstance().Error(err, "Unable to write status file", "keyInfo", keyInfo)
-				}
-			}
-		}
-		return activeKeys, nil
-	}
-	return nil, err
+// isKeyFilePath returns true if the file path provided is a valid entry of the key directory
+func isKeyFilePath(keyInfo KeyObtainInfo, filePath string) bool {
+	return path.Dir(filePath) == path.Clean(keyInfo.DbPath) && isValidKeyFileName(path.Base(filePath))
 }
 
-// readHiddenKeys function return hidden key list
-func readHiddenKeys(keyInfo KeyObtainInfo, onlyAdvertised KeyAdvertisingType) ([]daemonsv1alpha1.TangServerHiddenKeys, error) {
-	command := "ls -a " + keyInfo.DbPath + "/"
-	stdo, stde, err := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
-	} else {
-		GetLogInstance().Info("Executed hidden keys retrieval command correctly", "Hidden keys:", stdo)
-		keys := strings.Split(stdo, "\n")
-		hiddenKeys := make([]daemonsv1alpha1.TangServerHiddenKeys, 0)
-		var statusSigning string
-		var statusEncryption string
-		var ignoredKey bool
-		var sha1 string
-		var sha256 string
-		for _, k := range keys {
-			if len(k) > 0 {
-				if _, forbidden := FORBIDDEN_PATH_MAP[k]; forbidden {
-					continue
-				}
-				if k[0] == '.' {
-					k = strings.TrimLeft(strings.TrimRight(k, "\n"), "\n")
-					k = strings.TrimLeft(strings.TrimRight(k, "\r"), "\r")
-					fpath := keyInfo.DbPath + "/" + k
-					if ignoreKey(keyInfo, onlyAdvertised, fpath) {
-						statusEncryption = fpath
-						ignoredKey = true
-					} else {
-						statusSigning = fpath
-						ignoredKey = false
-					}
-					if !ignoredKey {
-						sha1 = getSHA(SHA1, keyInfo, fpath)
-						sha256 = getSHA(SHA256, keyInfo, fpath)
-						hiddenKeys = append(hiddenKeys, daemonsv1alpha1.TangServerHiddenKeys{
-							Sha1:      sha1,
-							Sha256:    sha256,
-							Generated: getCreationTimeFromKeys(keyInfo, sha1),
-							Hidden:    getLastTime(MODIFICATION, keyInfo, fpath),
-							FileName:  k,
-						})
-					}
-					if err := writeStatusFile(keyInfo, sha1, sha256, statusSigning, statusEncryption); err != nil {
-						GetLogInstance().Error(err, "Unable to write status file", "keyInfo", keyInfo)
-					}
-				}
-			}
-		}
-		return hiddenKeys, nil
-	}
-	return nil, err
+// isForbiddenPath returns true if the key directory entry provided must not be considered a key
+func isForbiddenPath(entry string) bool {
+	_, forbidden := forbiddenPaths[entry]
+	return forbidden
 }
 
-// getCreationTimeFromKeys function returns creation time for an active or hidden key with its sha1
-func getCreationTimeFromKeys(keyInfo KeyObtainInfo, sha1 string) string {
-	for _, k := range keyInfo.TangServer.Status.ActiveKeys {
-		if k.Sha1 == sha1 {
-			return k.Generated
+// activeKeysFromInventory returns the active keys of the key inventory provided
+func activeKeysFromInventory(ctx context.Context, keyInfo KeyObtainInfo, inventory []KeyMetadata, onlyAdvertised KeyAdvertisingType) []daemonsv1alpha1.TangServerActiveKeys {
+	activeKeys := make([]daemonsv1alpha1.TangServerActiveKeys, 0)
+	for _, key := range inventory {
+		if isHiddenKeyFile(key.File.Name) || ignoreKey(ctx, key.JWK, onlyAdvertised, keyInfo.DbPath+"/"+key.File.Name) {
+			continue
 		}
+		activeKeys = append(activeKeys, daemonsv1alpha1.TangServerActiveKeys{
+			Sha1:      key.Sha1,
+			Sha256:    key.Sha256,
+			Generated: formatKeyTime(key.File.ModTime),
+			FileName:  key.File.Name,
+		})
 	}
-	// Check if its already stored
-	return getCreationTimeFromHiddenKey(keyInfo, sha1)
+	return activeKeys
 }
 
-// getCreationTimeFromHiddenKey function returns creation time for an active key with its sha1
-func getCreationTimeFromHiddenKey(keyInfo KeyObtainInfo, sha1 string) string {
-	for _, k := range keyInfo.TangServer.Status.HiddenKeys {
-		if k.Sha1 == sha1 {
-			return k.Generated
+// hiddenKeysFromInventory returns the hidden keys of the key inventory provided
+func hiddenKeysFromInventory(ctx context.Context, keyInfo KeyObtainInfo, inventory []KeyMetadata, onlyAdvertised KeyAdvertisingType) []daemonsv1alpha1.TangServerHiddenKeys {
+	hiddenKeys := make([]daemonsv1alpha1.TangServerHiddenKeys, 0)
+	for _, key := range inventory {
+		if !isHiddenKeyFile(key.File.Name) || ignoreKey(ctx, key.JWK, onlyAdvertised, keyInfo.DbPath+"/"+key.File.Name) {
+			continue
 		}
+		hiddenKeys = append(hiddenKeys, daemonsv1alpha1.TangServerHiddenKeys{
+			Sha1:      key.Sha1,
+			Sha256:    key.Sha256,
+			Generated: formatKeyTime(key.File.ModTime),
+			Hidden:    formatKeyTime(key.File.ChangeTime),
+			FileName:  key.File.Name,
+		})
 	}
-	GetLogInstance().Info("Unable to obtain creation time")
-	return "UNKNOWN_CREATION_TIME"
+	return hiddenKeys
 }
 
 // createNewPairOfKeys function creates new pair of keys (via /usr/libexec/tangd-keygen)
-func createNewPairOfKeys(k KeyObtainInfo) error {
-	command := "/usr/libexec/tangd-keygen " + k.DbPath + "/"
-	stdo, stde, err := podCommandExec(command, "", k.PodName, k.Namespace, nil)
+func createNewPairOfKeys(ctx context.Context, k KeyObtainInfo) error {
+	l := log.FromContext(ctx)
+	command := []string{"/usr/libexec/tangd-keygen", k.DbPath + "/"}
+	stdo, stde, err := k.podExec(ctx, nil, command...)
 	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", k.PodName, "namespace", k.Namespace)
+		l.Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", k.PodName, "namespace", k.Namespace)
 	}
 	return err
 }
 
-// rotateUnadvertisedKeys function rotate key file, moving it to hidden file
-// TODO: Rotate the key corresponding to a particular signing key
-//
-//	Right now, all unadvertised keys will be rotated
-func rotateUnadvertisedKeys(krinfo KeyRotateInfo) error {
-	var ge error
-	GetLogInstance().Info("rotateUnadvertisedKeys", "Advertised Key Info", krinfo.KeyFileName)
-	keys, e := readActiveKeys(*krinfo.KeyInfo, ONLY_UNADVERTISED)
-	if e != nil {
-		GetLogInstance().Error(e, "Unable to read unadvertised keys", "Key Rotate Info", krinfo, "podname", krinfo.KeyInfo.PodName, "namespace", krinfo.KeyInfo.Namespace)
-		return e
-	}
-	ge = nil
-	for _, uk := range keys {
-		rk := KeyRotateInfo{
-			KeyInfo:     krinfo.KeyInfo,
-			KeyFileName: uk.FileName,
-		}
-		e := rotateKey(rk)
-		if ge == nil && e != nil {
-			GetLogInstance().Error(e, "Error rotating unadvertised key", "Rotate Key Info", rk)
-			ge = e
-		}
-	}
-	return ge
-}
-
-// dumpKeyStatusFileWithEchoRedirection receives the key in string format and the file where it is to be dumped, and dumps it
-func dumpKeyStatusFileWithEchoRedirection(keyFile string, fileContent []byte, podName string, namespace string) error {
-	command := `echo '` + string(fileContent) + `' > ` + keyFile
-	stdo, stde, err := podCommandExec(command, "", podName, namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "podname", podName, "namespace", namespace, "stdo", stdo, "stde", stde)
-	} else {
-		GetLogInstance().Info("Command executed successfully", "command", command, "podname", podName, "namespace", namespace, "stdo", stdo, "stde", stde)
-	}
-	return err
-}
-
-// rotateKey function rotate key file, moving it to hidden file
-func rotateKey(k KeyRotateInfo) error {
-	command := "mv " + k.KeyInfo.DbPath + "/" + k.KeyFileName + " " + k.KeyInfo.DbPath + "/." + k.KeyFileName
-	stdo, stde, err := podCommandExec(command, "", k.KeyInfo.PodName, k.KeyInfo.Namespace, nil)
+// writePodFile writes the content provided to a file in the pod, streaming it through the standard input
+func writePodFile(ctx context.Context, keyInfo KeyObtainInfo, filePath string, fileContent []byte) error {
+	l := log.FromContext(ctx)
+	command := []string{"tee", filePath}
+	_, stde, err := keyInfo.podExec(ctx, bytes.NewReader(fileContent), command...)
 	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", k.KeyInfo.PodName, "namespace", k.KeyInfo.Namespace)
+		l.Error(err, "Unable to execute command in Pod", "command", command, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace, "stde", stde)
 	} else {
-		GetLogInstance().Info("Move file command executed correctly", "command", command, "podname", k.KeyInfo.PodName, "namespace", k.KeyInfo.Namespace)
+		l.Info("Command executed successfully", "command", command, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
 	}
 	return err
 }
 
-// getSHA function returns SHA1 or SHA256 of the file provided in the parameters
-func getSHA(shaType SHAType, keyInfo KeyObtainInfo, filePath string) string {
-	alg := "Unknown"
-	switch shaType {
-	case SHA1:
-		alg = "S1"
-	case SHA256:
-		alg = "S256"
-	}
-	command := "jose jwk thp -a" + alg + " -i " + filePath
-	stdo, stde, err := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
+// getSHA function returns the thumbprint of the key provided, using the hash specified
+func getSHA(ctx context.Context, shaType SHAType, jwk *JWK, filePath string) string {
+	l := log.FromContext(ctx)
+	thp, err := jwk.Thumbprint(shaType)
 	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
+		l.Error(err, "Unable to compute key thumbprint", "key path", filePath)
 		return ""
 	}
-	return stdo
-}
-
-// getLastTime indicates last creation/modficiation time of the file
-func getLastTime(fmod FileModType, keyInfo KeyObtainInfo, filePath string) string {
-	command := "stat -c "
-	ftype := ""
-	switch fmod {
-	case CREATION:
-		ftype += "'%y'"
-	case MODIFICATION:
-		ftype += "'%z'"
-	}
-	command += ftype + " " + filePath
-	stdo, stde, err := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
-		return ""
-	}
-	//return strings.TrimLeft(strings.TrimRight(stdo, "\n"), "\n")
-	return strings.TrimRight(strings.TrimLeft(strings.TrimLeft(strings.TrimRight(stdo, "\n"), "\n"), "'"), "'")
-}
-
-// deleteAllHiddenKeys function return active key list
-func deleteAllHiddenKeys(keyInfo KeyObtainInfo) bool {
-	if len(keyInfo.TangServer.Status.ActiveKeys) > 0 {
-		command := "rm -frv"
-		ahk, e := readHiddenKeys(keyInfo, ALL_KEYS)
-		if e != nil {
-			GetLogInstance().Error(e, "Unable to read hidden keys", "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
-			return false
-		}
-		for _, kf := range ahk {
-			command += " " + keyInfo.DbPath + "/" + kf.FileName
-		}
-		stdo, stde, err := podCommandExec(command, "", keyInfo.PodName, keyInfo.Namespace, nil)
-		GetLogInstance().Info("Executing command in Pod", "command", command, "podname", keyInfo.PodName)
-		if err != nil {
-			GetLogInstance().Error(err, "Unable to execute command in Pod", "command", command, "stdo", stdo, "stderror", stde, "podname", keyInfo.PodName, "namespace", keyInfo.Namespace)
-			return false
-		} else {
-			GetLogInstance().Info("Command correctly executed", "output", stdo, "error", stde)
-		}
-	}
-	return true
+	return thp
 }
@@ -17,6 +17,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
@@ -94,23 +98,21 @@ var _ = Describe("TangServer controller keyhandler", func() {
 			Expect(getDefaultKeyPath(tangServer)).To(Equal(DEFAULT_DEPLOYMENT_KEY_PATH))
 		})
 
-		It("Should handle forbidden path map correctly", func() {
-			_, exists := FORBIDDEN_PATH_MAP["."]
-			Expect(exists).To(BeTrue())
-			_, exists = FORBIDDEN_PATH_MAP[".."]
-			Expect(exists).To(BeTrue())
-			_, exists = FORBIDDEN_PATH_MAP["lost+found"]
-			Expect(exists).To(BeTrue())
-
-			updateForbiddenMap("testkey")
-			_, exists = FORBIDDEN_PATH_MAP["testkey"]
-			Expect(exists).To(BeTrue())
+		It("Should handle forbidden paths correctly", func() {
+			Expect(isForbiddenPath(".")).To(BeTrue())
+			Expect(isForbiddenPath("..")).To(BeTrue())
+			Expect(isForbiddenPath("lost+found")).To(BeTrue())
+			Expect(isForbiddenPath(KEY_STATUS_FILE_NAME)).To(BeTrue())
+			Expect(isForbiddenPath(KEY_STATUS_FILE_NAME + ".lock")).To(BeTrue())
+			Expect(isForbiddenPath("testkey")).To(BeFalse())
 		})
 
 		It("Should handle SHAType constants correctly", func() {
 			Expect(int(UNKNOWN_SHA)).To(Equal(0))
 			Expect(int(SHA256)).To(Equal(1))
 			Expect(int(SHA1)).To(Equal(2))
+			Expect(int(SHA384)).To(Equal(3))
+			Expect(int(SHA512)).To(Equal(4))
 		})
 
 		It("Should handle FileModType constants correctly", func() {
@@ -176,4 +178,154 @@ var _ = Describe("TangServer controller keyhandler", func() {
 			Expect(result).To(Equal(expected))
 		})
 	})
+
+	Context("When validating key paths and file names", func() {
+		It("Should accept only absolute key paths with safe characters", func() {
+			for _, keyPath := range []string{"/var/db/tang", "/var/db/tang-2/keys_v1", "/var/db/tang..keys"} {
+				Expect(isValidKeyPath(keyPath)).To(BeTrue(), keyPath)
+			}
+			for _, keyPath := range []string{"", "var/db/tang", "/var/db/../../etc", "/var/db/tang keys", "/var/db/tang;ls", "/var/db/$(tang)"} {
+				Expect(isValidKeyPath(keyPath)).To(BeFalse(), keyPath)
+			}
+		})
+
+		It("Should accept only key file names with safe characters", func() {
+			for _, name := range []string{"key.jwk", ".key.jwk", "-Ax0ok1Hd9pNlDpkdwxHsvZQGyB8.jwk", "key_1"} {
+				Expect(isValidKeyFileName(name)).To(BeTrue(), name)
+			}
+			for _, name := range []string{"", ".", "..", "..key", "dir/key.jwk", "key name.jwk", "key'.jwk", "key;ls"} {
+				Expect(isValidKeyFileName(name)).To(BeFalse(), name)
+			}
+		})
+	})
+
+	Context("When executing commands in the tang pod", func() {
+		It("Should count commands aborted by a timeout", func() {
+			keyInfo := KeyObtainInfo{
+				PodName:     "test-pod",
+				Namespace:   TangserverNamespace,
+				Executor:    newFakePodExecutor(),
+				ExecTimeout: time.Nanosecond,
+				stats:       &execStats{},
+			}
+			time.Sleep(time.Millisecond)
+			_, _, err := keyInfo.podExec(context.Background(), nil, "ls", "/var/db/tang")
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(keyInfo.timedOut()).To(BeTrue())
+		})
+
+		It("Should not count commands aborted by a cancellation", func() {
+			keyInfo := KeyObtainInfo{
+				PodName:   "test-pod",
+				Namespace: TangserverNamespace,
+				Executor:  newFakePodExecutor(),
+				stats:     &execStats{},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, _, err := keyInfo.podExec(ctx, nil, "ls", "/var/db/tang")
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(keyInfo.timedOut()).To(BeFalse())
+		})
+	})
+
+	Context("When managing the keys of a tang pod", func() {
+		var (
+			executor *fakePodExecutor
+			keyInfo  KeyObtainInfo
+		)
+		ctx := context.Background()
+
+		// activeKeys returns the active keys of the key directory
+		activeKeys := func(onlyAdvertised KeyAdvertisingType) []daemonsv1alpha1.TangServerActiveKeys {
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			return activeKeysFromInventory(ctx, keyInfo, inventory, onlyAdvertised)
+		}
+
+		BeforeEach(func() {
+			executor = newFakePodExecutor()
+			keyInfo = KeyObtainInfo{
+				PodName:   "test-pod",
+				Namespace: TangserverNamespace,
+				DbPath:    TangServerTestKeyPath,
+				TangServer: &daemonsv1alpha1.TangServer{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      TangserverName,
+						Namespace: TangserverNamespace,
+					},
+					Spec: daemonsv1alpha1.TangServerSpec{
+						KeyPath: TangServerTestKeyPath,
+					},
+				},
+				Executor: executor,
+			}
+		})
+
+		It("Should read signing keys as active keys", func() {
+			signing, exchange := executor.addKeyPair(TangServerTestKeyPath)
+			content, _ := executor.fileContent(TangServerTestKeyPath + "/" + signing)
+			sha1, _ := fakeThumbprint(content, "S1")
+
+			advertised := activeKeys(ONLY_ADVERTISED)
+			Expect(advertised).To(HaveLen(1))
+			Expect(advertised[0].FileName).To(Equal(signing))
+			Expect(advertised[0].Sha1).To(Equal(sha1))
+			Expect(advertised[0].Sha256).To(Equal(strings.TrimSuffix(signing, ".jwk")))
+			Expect(advertised[0].Generated).ToNot(BeEmpty())
+
+			unadvertised := activeKeys(ONLY_UNADVERTISED)
+			Expect(unadvertised).To(HaveLen(1))
+			Expect(unadvertised[0].FileName).To(Equal(exchange))
+		})
+
+		It("Should read each key file once, without jose", func() {
+			executor.addKeyPair(TangServerTestKeyPath)
+			_, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			cats := 0
+			for _, command := range executor.commands {
+				Expect(command).ToNot(HavePrefix("jose"))
+				if strings.HasPrefix(command, "cat "+TangServerTestKeyPath+"/") && !strings.HasSuffix(command, KEY_STATUS_FILE_NAME) {
+					cats++
+				}
+			}
+			Expect(cats).To(Equal(2))
+		})
+
+		It("Should ignore key files that are not JWKs", func() {
+			signing, _ := executor.addKeyPair(TangServerTestKeyPath)
+			executor.addFile(TangServerTestKeyPath+"/corrupted.jwk", []byte("not a key"))
+			advertised := activeKeys(ONLY_ADVERTISED)
+			Expect(advertised).To(HaveLen(1))
+			Expect(advertised[0].FileName).To(Equal(signing))
+		})
+
+		It("Should create a new pair of keys", func() {
+			executor.addKeyPair(TangServerTestKeyPath)
+			Expect(createNewPairOfKeys(ctx, keyInfo)).To(Succeed())
+			Expect(activeKeys(ONLY_ADVERTISED)).To(HaveLen(2))
+		})
+
+		It("Should ignore key directory entries unsafe for pod commands", func() {
+			signing, _ := executor.addKeyPair(TangServerTestKeyPath)
+			executor.addFile(TangServerTestKeyPath+"/key;rm -rf.jwk", []byte("{}"))
+			advertised := activeKeys(ONLY_ADVERTISED)
+			Expect(advertised).To(HaveLen(1))
+			Expect(advertised[0].FileName).To(Equal(signing))
+			for _, command := range executor.commands {
+				Expect(command).ToNot(ContainSubstring("key;rm"))
+			}
+		})
+
+		It("Should stream file content without interpreting it", func() {
+			content := []byte(`{"signing": "'; touch /tmp/owned; echo '"}`)
+			Expect(writePodFile(ctx, keyInfo, TangServerTestKeyPath+"/"+KEY_STATUS_FILE_NAME, content)).To(Succeed())
+			written, found := executor.fileContent(TangServerTestKeyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(found).To(BeTrue())
+			Expect(written).To(Equal(content))
+			_, found = executor.fileContent("/tmp/owned")
+			Expect(found).To(BeFalse())
+		})
+	})
 })
@@ -0,0 +1,235 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Maximum time between the signing and the exchange key files written by the same tangd-keygen execution
+const KEY_PAIR_BATCH_WINDOW = time.Second
+
+// KeyPair is a signing key and the exchange key generated with it
+type KeyPair struct {
+	Signing  KeyMetadata
+	Exchange KeyMetadata
+}
+
+// Hidden returns true if the key pair is hidden (not advertised)
+func (p KeyPair) Hidden() bool {
+	return isHiddenKeyFile(p.Signing.File.Name)
+}
+
+// association returns the key status file association of the key pair, for the key directory provided
+func (p KeyPair) association(dbPath string) KeyAssociation {
+	return KeyAssociation{
+		Sha1:          p.Signing.Sha1,
+		Sha256:        p.Signing.Sha256,
+		SigningKey:    dbPath + "/" + p.Signing.File.Name,
+		EncriptionKey: dbPath + "/" + p.Exchange.File.Name,
+	}
+}
+
+// keyStatus returns the status of a key of a key pair
+func keyStatus(k KeyMetadata) daemonsv1alpha1.TangServerKey {
+	key := daemonsv1alpha1.TangServerKey{Sha1: k.Sha1, Sha256: k.Sha256, FileName: k.File.Name}
+	if k.JWK != nil {
+		key.Alg = k.JWK.Alg
+	}
+	return key
+}
+
+// status returns the status of the key pair. Key files are renamed to hide them, which keeps their
+// modification time (the generation time) and updates their change time (the hiding time)
+func (p KeyPair) status() daemonsv1alpha1.TangServerKeyPair {
+	generated := metav1.NewTime(p.Signing.File.ModTime)
+	pair := daemonsv1alpha1.TangServerKeyPair{
+		State:     daemonsv1alpha1.KeyPairStateActive,
+		Signing:   keyStatus(p.Signing),
+		Exchange:  keyStatus(p.Exchange),
+		Generated: &generated,
+	}
+	if p.Hidden() {
+		hidden := metav1.NewTime(p.Signing.File.ChangeTime)
+		pair.State = daemonsv1alpha1.KeyPairStateHidden
+		pair.Hidden = &hidden
+	}
+	return pair
+}
+
+// keyPairsStatus returns the status of the key pairs, active ones first and from the oldest to the newest
+func keyPairsStatus(pairs []KeyPair) []daemonsv1alpha1.TangServerKeyPair {
+	status := make([]daemonsv1alpha1.TangServerKeyPair, 0, len(pairs))
+	for _, p := range pairs {
+		status = append(status, p.status())
+	}
+	sort.SliceStable(status, func(i, j int) bool {
+		if status[i].State != status[j].State {
+			return status[i].State == daemonsv1alpha1.KeyPairStateActive
+		}
+		return status[i].Generated.Before(status[j].Generated)
+	})
+	return status
+}
+
+// KeyPairing is the result of pairing the keys of a key directory
+type KeyPairing struct {
+	Pairs []KeyPair
+	// Ambiguous contains the keys that could be paired with more than one key
+	Ambiguous []KeyMetadata
+	// Unpaired contains the keys that could not be paired with any key
+	Unpaired []KeyMetadata
+}
+
+// keys returns all the keys of the pairing, paired or not
+func (p KeyPairing) keys() []KeyMetadata {
+	keys := make([]KeyMetadata, 0, 2*len(p.Pairs)+len(p.Ambiguous)+len(p.Unpaired))
+	for _, pair := range p.Pairs {
+		keys = append(keys, pair.Signing, pair.Exchange)
+	}
+	keys = append(keys, p.Ambiguous...)
+	return append(keys, p.Unpaired...)
+}
+
+// isHiddenKeyFile returns true if the key file name corresponds to a hidden key
+func isHiddenKeyFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// pairingStem returns the file name of a key without the hidden prefix, the extension and the sig/exc marker,
+// so that keys generated with explicit names (i.e. tangd-keygen <dir> foo-sig foo-exc) can be paired by name
+func pairingStem(name string, marker string) (string, bool) {
+	stem := strings.TrimSuffix(strings.TrimPrefix(name, "."), ".jwk")
+	if !strings.Contains(stem, marker) {
+		return "", false
+	}
+	return strings.Replace(stem, marker, "*", 1), true
+}
+
+// namePaired returns true if the signing and exchange key file names only differ in their sig/exc marker
+func namePaired(signing KeyMetadata, exchange KeyMetadata) bool {
+	s, found := pairingStem(signing.File.Name, "sig")
+	if !found {
+		return false
+	}
+	e, found := pairingStem(exchange.File.Name, "exc")
+	return found && s == e
+}
+
+// batchPaired returns true if the exchange key was written right after the signing key, as tangd-keygen does
+func batchPaired(signing KeyMetadata, exchange KeyMetadata) bool {
+	elapsed := exchange.File.ModTime.Sub(signing.File.ModTime)
+	return elapsed >= 0 && elapsed < KEY_PAIR_BATCH_WINDOW
+}
+
+// pairKeys pairs the signing and exchange keys provided. Keys are only paired with keys of the same state (active
+// or hidden), by file name first and then by tangd-keygen batch. A key is only paired when there is a single
+// candidate for it and it is the single candidate of that candidate, so the result never depends on key order
+func pairKeys(keys []KeyMetadata) KeyPairing {
+	var signing, exchange []KeyMetadata
+	for _, k := range keys {
+		if k.JWK == nil {
+			continue
+		}
+		if k.JWK.IsSigningKey() {
+			signing = append(signing, k)
+		} else {
+			exchange = append(exchange, k)
+		}
+	}
+	sortKeys := func(keys []KeyMetadata) {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].File.Name < keys[j].File.Name })
+	}
+	sortKeys(signing)
+	sortKeys(exchange)
+
+	result := KeyPairing{Pairs: make([]KeyPair, 0)}
+	pairedSigning := make([]bool, len(signing))
+	pairedExchange := make([]bool, len(exchange))
+	candidates := func(match func(KeyMetadata, KeyMetadata) bool) ([][]int, [][]int) {
+		bySigning := make([][]int, len(signing))
+		byExchange := make([][]int, len(exchange))
+		for i, s := range signing {
+			for j, e := range exchange {
+				if pairedSigning[i] || pairedExchange[j] || isHiddenKeyFile(s.File.Name) != isHiddenKeyFile(e.File.Name) {
+					continue
+				}
+				if match(s, e) {
+					bySigning[i] = append(bySigning[i], j)
+					byExchange[j] = append(byExchange[j], i)
+				}
+			}
+		}
+		return bySigning, byExchange
+	}
+	for _, match := range []func(KeyMetadata, KeyMetadata) bool{namePaired, batchPaired} {
+		// Pairing some keys may leave a single candidate for others
+		for progress := true; progress; {
+			progress = false
+			bySigning, byExchange := candidates(match)
+			for i, c := range bySigning {
+				if len(c) == 1 && len(byExchange[c[0]]) == 1 {
+					result.Pairs = append(result.Pairs, KeyPair{Signing: signing[i], Exchange: exchange[c[0]]})
+					pairedSigning[i], pairedExchange[c[0]] = true, true
+					progress = true
+				}
+			}
+		}
+	}
+
+	sort.Slice(result.Pairs, func(i, j int) bool {
+		return result.Pairs[i].Signing.File.Name < result.Pairs[j].Signing.File.Name
+	})
+
+	bySigning, byExchange := candidates(batchPaired)
+	for i, s := range signing {
+		if pairedSigning[i] {
+			continue
+		}
+		if len(bySigning[i]) > 0 {
+			result.Ambiguous = append(result.Ambiguous, s)
+		} else {
+			result.Unpaired = append(result.Unpaired, s)
+		}
+	}
+	for j, e := range exchange {
+		if pairedExchange[j] {
+			continue
+		}
+		if len(byExchange[j]) > 0 {
+			result.Ambiguous = append(result.Ambiguous, e)
+		} else {
+			result.Unpaired = append(result.Unpaired, e)
+		}
+	}
+	return result
+}
+
+// keyFileNames returns the file names of the keys provided
+func keyFileNames(keys []KeyMetadata) []string {
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, k.File.Name)
+	}
+	sort.Strings(names)
+	return names
+}
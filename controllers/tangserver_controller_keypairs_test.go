@@ -0,0 +1,302 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller key pairing", func() {
+	const keyPath = "/var/db/tang"
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// key returns the metadata of a signing or exchange key file written at the offset provided
+	key := func(name string, signing bool, offset time.Duration) KeyMetadata {
+		jwk := &JWK{Kty: "EC", Alg: "ECMR", KeyOps: []string{"deriveKey"}}
+		if signing {
+			jwk = &JWK{Kty: "EC", Alg: "ES512", KeyOps: []string{"sign", "verify"}}
+		}
+		return KeyMetadata{
+			File: KeyFileInfo{Name: name, ModTime: base.Add(offset)},
+			JWK:  jwk,
+			Sha1: name + "-sha1", Sha256: name + "-sha256",
+		}
+	}
+
+	// pairNames returns the signing and exchange file names of each pair
+	pairNames := func(pairing KeyPairing) [][2]string {
+		names := make([][2]string, 0)
+		for _, p := range pairing.Pairs {
+			names = append(names, [2]string{p.Signing.File.Name, p.Exchange.File.Name})
+		}
+		return names
+	}
+
+	Context("When pairing keys", func() {
+		It("Should pair the keys written by the same tangd-keygen execution", func() {
+			keys := []KeyMetadata{
+				key("b-sig.jwk", true, 0),
+				key("a-exc.jwk", false, 10*time.Millisecond),
+				key("c-sig.jwk", true, time.Hour),
+				key("d-exc.jwk", false, time.Hour+10*time.Millisecond),
+			}
+			pairing := pairKeys(keys)
+			Expect(pairNames(pairing)).To(Equal([][2]string{{"b-sig.jwk", "a-exc.jwk"}, {"c-sig.jwk", "d-exc.jwk"}}))
+			Expect(pairing.Ambiguous).To(BeEmpty())
+			Expect(pairing.Unpaired).To(BeEmpty())
+		})
+
+		It("Should not depend on the order of the keys", func() {
+			keys := []KeyMetadata{
+				key("1.jwk", true, 0), key("2.jwk", false, 0),
+				key("3.jwk", true, time.Minute), key("4.jwk", false, time.Minute),
+				key(".5.jwk", true, 0), key(".6.jwk", false, 0),
+			}
+			expected := pairNames(pairKeys(keys))
+			Expect(expected).To(HaveLen(3))
+			for i := range keys {
+				rotated := append(append([]KeyMetadata{}, keys[i:]...), keys[:i]...)
+				Expect(pairNames(pairKeys(rotated))).To(Equal(expected))
+			}
+		})
+
+		It("Should pair keys generated with explicit names", func() {
+			keys := []KeyMetadata{
+				key("foo-sig.jwk", true, 0), key("foo-exc.jwk", false, 0),
+				key("bar-sig.jwk", true, 0), key("bar-exc.jwk", false, 0),
+			}
+			pairing := pairKeys(keys)
+			Expect(pairNames(pairing)).To(ConsistOf([2]string{"foo-sig.jwk", "foo-exc.jwk"}, [2]string{"bar-sig.jwk", "bar-exc.jwk"}))
+			Expect(pairing.Ambiguous).To(BeEmpty())
+		})
+
+		It("Should only pair keys in the same state", func() {
+			keys := []KeyMetadata{key(".hidden.jwk", true, 0), key("active.jwk", false, 0)}
+			pairing := pairKeys(keys)
+			Expect(pairing.Pairs).To(BeEmpty())
+			Expect(keyFileNames(pairing.Unpaired)).To(Equal([]string{".hidden.jwk", "active.jwk"}))
+		})
+
+		It("Should not pair exchange keys written before the signing key", func() {
+			pairing := pairKeys([]KeyMetadata{key("s.jwk", true, time.Second), key("e.jwk", false, 0)})
+			Expect(pairing.Pairs).To(BeEmpty())
+			Expect(pairing.Unpaired).To(HaveLen(2))
+		})
+
+		It("Should report keys that could be paired with more than one key", func() {
+			keys := []KeyMetadata{
+				key("s1.jwk", true, 0), key("s2.jwk", true, 0),
+				key("e1.jwk", false, 0), key("e2.jwk", false, 0),
+				key("s3.jwk", true, time.Hour), key("e3.jwk", false, time.Hour),
+			}
+			pairing := pairKeys(keys)
+			Expect(pairNames(pairing)).To(Equal([][2]string{{"s3.jwk", "e3.jwk"}}))
+			Expect(keyFileNames(pairing.Ambiguous)).To(Equal([]string{"e1.jwk", "e2.jwk", "s1.jwk", "s2.jwk"}))
+			Expect(pairing.Unpaired).To(BeEmpty())
+		})
+
+		It("Should resolve batches once the keys paired by name are removed", func() {
+			keys := []KeyMetadata{
+				key("foo-sig.jwk", true, 0), key("foo-exc.jwk", false, 0),
+				key("s.jwk", true, 0), key("e.jwk", false, 0),
+			}
+			pairing := pairKeys(keys)
+			Expect(pairNames(pairing)).To(ConsistOf([2]string{"foo-sig.jwk", "foo-exc.jwk"}, [2]string{"s.jwk", "e.jwk"}))
+			Expect(pairing.Ambiguous).To(BeEmpty())
+		})
+	})
+
+	Context("When recording key pairs in a tang pod", func() {
+		var (
+			executor *fakePodExecutor
+			keyInfo  KeyObtainInfo
+		)
+		ctx := context.Background()
+
+		// keyStatus returns the content of the key status file
+		keyStatus := func() KeyAssociationMap {
+			content, found := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(found).To(BeTrue())
+			var keyStatusMap KeyAssociationMap
+			Expect(json.Unmarshal(content, &keyStatusMap)).To(Succeed())
+			return keyStatusMap
+		}
+
+		BeforeEach(func() {
+			executor = newFakePodExecutor()
+			keyInfo = KeyObtainInfo{
+				PodName:   "test-pod",
+				Namespace: "default",
+				DbPath:    keyPath,
+				TangServer: &daemonsv1alpha1.TangServer{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-tang-pairs", Namespace: "default"},
+					Spec:       daemonsv1alpha1.TangServerSpec{KeyPath: keyPath},
+				},
+				Executor: executor,
+			}
+		})
+
+		It("Should record every pair of several key pairs", func() {
+			pairs := map[string]string{}
+			for i := 0; i < 3; i++ {
+				signing, exchange := executor.addKeyPair(keyPath)
+				pairs[keyPath+"/"+signing] = keyPath + "/" + exchange
+			}
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writeKeyPairs(ctx, keyInfo, pairKeys(inventory))).To(Succeed())
+			recorded := keyStatus().KeyStatusSha256Map
+			Expect(recorded).To(HaveLen(3))
+			for _, assoc := range recorded {
+				Expect(assoc.EncriptionKey).To(Equal(pairs[assoc.SigningKey]))
+			}
+
+			executor.commands = nil
+			Expect(writeKeyPairs(ctx, keyInfo, pairKeys(inventory))).To(Succeed())
+			for _, command := range executor.commands {
+				Expect(command).ToNot(HavePrefix("tee"))
+			}
+		})
+
+		It("Should delete the exchange key paired with the hidden key, not the recorded one", func() {
+			keepSigning, keepExchange := executor.addKeyPair(keyPath)
+			signing, exchange := executor.addKeyPair(keyPath)
+			for _, name := range []string{keepSigning, keepExchange, signing, exchange} {
+				Expect(moveKeyFile(ctx, keyInfo, name, "."+name)).To(Succeed())
+			}
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			keyInfo.TangServer.Status.HiddenKeys = hiddenKeysFromInventory(ctx, keyInfo, inventory, ONLY_ADVERTISED)
+			Expect(keyInfo.TangServer.Status.HiddenKeys).To(HaveLen(2))
+			var keep, remove daemonsv1alpha1.TangServerHiddenKeys
+			for _, hk := range keyInfo.TangServer.Status.HiddenKeys {
+				if hk.FileName == "."+keepSigning {
+					keep = hk
+				} else {
+					remove = hk
+				}
+			}
+			// A wrong association, as recorded by previous versions, pointing to the exchange key to keep
+			Expect(dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{{Sha1: remove.Sha1, Sha256: remove.Sha256, SigningKey: keyPath + "/." + signing, EncriptionKey: keyPath + "/." + keepExchange}}, nil, nil)).To(Succeed())
+
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+				{Sha1: keep.Sha1, Action: daemonsv1alpha1.HiddenKeyActionKeepHidden},
+				{Sha1: remove.Sha1, Action: daemonsv1alpha1.HiddenKeyActionDelete, ConfirmDelete: remove.Sha1},
+			}
+			reconciler := &TangServerReconciler{Recorder: events.NewFakeRecorder(100), Executor: executor}
+			reconciler.deleteRequestedHiddenKeys(ctx, keyInfo)
+			entries := executor.entries(keyPath)
+			Expect(entries).To(ContainElements("."+keepSigning, "."+keepExchange))
+			Expect(entries).ToNot(ContainElement("." + signing))
+			Expect(entries).ToNot(ContainElement("." + exchange))
+		})
+
+		It("Should report ambiguous pairs in status and drop their associations", func() {
+			recorder := events.NewFakeRecorder(100)
+			reconciler := &TangServerReconciler{Recorder: recorder, Executor: executor}
+			signing, exchange := executor.addKeyPair(keyPath)
+			Expect(dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{{Sha1: "sha1", Sha256: "sha256", SigningKey: keyPath + "/" + signing, EncriptionKey: keyPath + "/" + exchange}}, nil, nil)).To(Succeed())
+			// A second pair written in the same batch can not be told apart
+			executor.addFile(keyPath+"/other-signing.jwk", fakeGenerateJWK("ES512", []string{"sign", "verify"}))
+			executor.addFile(keyPath+"/other-exchange.jwk", fakeGenerateJWK("ECMR", []string{"deriveKey"}))
+			executor.mu.Lock()
+			for _, name := range []string{signing, exchange} {
+				executor.files[keyPath+"/"+name].modTime = executor.files[keyPath+"/other-signing.jwk"].modTime
+			}
+			executor.mu.Unlock()
+
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			for i := range inventory {
+				if inventory[i].File.Name == signing {
+					inventory[i].Sha1, inventory[i].Sha256 = "sha1", "sha256"
+				}
+			}
+			reconciler.updateKeyPairs(ctx, keyInfo, inventory)
+			cond := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysPaired)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonAmbiguousKeyPairs))
+			Expect(cond.Message).To(ContainSubstring(signing))
+			Expect(recorder.Events).To(Receive(ContainSubstring("AmbiguousKeyPairs")))
+			Expect(keyStatus().KeyStatusSha1Map).ToNot(HaveKey("sha1"))
+			Expect(keyStatus().KeyStatusSha256Map).ToNot(HaveKey("sha256"))
+		})
+
+		It("Should expose signing and exchange keys of every pair in status", func() {
+			reconciler := &TangServerReconciler{Recorder: events.NewFakeRecorder(100), Executor: executor}
+			hiddenSigning, hiddenExchange := executor.addKeyPair(keyPath)
+			signing, exchange := executor.addKeyPair(keyPath)
+			generated := metav1.NewTime(executor.files[keyPath+"/"+hiddenSigning].modTime)
+			for _, name := range []string{hiddenSigning, hiddenExchange} {
+				Expect(moveKeyFile(ctx, keyInfo, name, "."+name)).To(Succeed())
+			}
+			hidden := metav1.NewTime(executor.files[keyPath+"/."+hiddenSigning].changeTime)
+			exchangeContent, _ := executor.fileContent(keyPath + "/" + exchange)
+			exchangeSha256, _ := fakeThumbprint(exchangeContent, "S256")
+
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			reconciler.updateKeyPairs(ctx, keyInfo, inventory)
+			pairs := keyInfo.TangServer.Status.KeyPairs
+			Expect(pairs).To(HaveLen(2))
+
+			Expect(pairs[0].State).To(Equal(daemonsv1alpha1.KeyPairStateActive))
+			Expect(pairs[0].Signing).To(Equal(daemonsv1alpha1.TangServerKey{
+				Sha1:     pairs[0].Signing.Sha1,
+				Sha256:   strings.TrimSuffix(signing, ".jwk"),
+				Alg:      "ES512",
+				FileName: signing,
+			}))
+			Expect(pairs[0].Exchange.Sha256).To(Equal(exchangeSha256))
+			Expect(pairs[0].Exchange.Alg).To(Equal("ECMR"))
+			Expect(pairs[0].Exchange.FileName).To(Equal(exchange))
+			Expect(pairs[0].Hidden).To(BeNil())
+
+			Expect(pairs[1].State).To(Equal(daemonsv1alpha1.KeyPairStateHidden))
+			Expect(pairs[1].Signing.FileName).To(Equal("." + hiddenSigning))
+			Expect(pairs[1].Exchange.FileName).To(Equal("." + hiddenExchange))
+			Expect(pairs[1].Generated.Equal(&generated)).To(BeTrue())
+			Expect(pairs[1].Hidden.Equal(&hidden)).To(BeTrue())
+
+			hiddenKeys := hiddenKeysFromInventory(ctx, keyInfo, inventory, ONLY_ADVERTISED)
+			Expect(hiddenKeys).To(HaveLen(1))
+			Expect(hiddenKeys[0].Generated).To(Equal(formatKeyTime(generated.Time)))
+		})
+
+		It("Should report every key paired in status", func() {
+			reconciler := &TangServerReconciler{Recorder: events.NewFakeRecorder(100), Executor: executor}
+			executor.addKeyPair(keyPath)
+			executor.addKeyPair(keyPath)
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			reconciler.updateKeyPairs(ctx, keyInfo, inventory)
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionKeysPaired)).To(BeTrue())
+			Expect(keyStatus().KeyStatusSha1Map).To(HaveLen(2))
+		})
+	})
+})
@@ -17,18 +17,51 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
 
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const KEY_STATUS_FILE_NAME = "key_status.txt"
 
+// Schema version of the key status file written. Files without schema version are migrated when rewritten
+const KEY_STATUS_SCHEMA_VERSION = 1
+
+// Agents hiding key pairs, as recorded in the key status file
+const (
+	KEY_HIDDEN_BY_SPEC                      = "spec.hiddenKeys"
+	KEY_HIDDEN_BY_ROTATION_POLICY           = "spec.keyRotation"
+	KEY_HIDDEN_BY_REQUIRED_ACTIVE_KEY_PAIRS = "spec.requiredActiveKeyPairs"
+	KEY_HIDDEN_BY_EXTERNAL                  = "external"
+)
+
+// Reason recorded for key pairs hidden in spec
+const KEY_HIDDEN_BY_SPEC_REASON = "Key requested to be hidden in spec"
+
+// errKeyStatusSchemaUnsupported is returned for key status files written by a newer operator, which are never overwritten
+var errKeyStatusSchemaUnsupported = errors.New("unsupported key status file schema version")
+
 type KeyAssociation struct {
 	Sha1          string `json:"-"`
 	Sha256        string `json:"-"`
 	SigningKey    string `json:"signing"`
 	EncriptionKey string `json:"encryption"`
+	// Created is the generation time of the key pair
+	Created *time.Time `json:"created,omitempty"`
+	// Hidden is the time the key pair was first seen hidden
+	Hidden *time.Time `json:"hidden,omitempty"`
+	// RotatedBy is the agent that hid the key pair
+	RotatedBy string `json:"rotatedBy,omitempty"`
+	// Reason explains why the key pair was hidden
+	Reason string `json:"reason,omitempty"`
 }
 
 type KeyAssociationSha1Map map[string]KeyAssociation
@@ -36,11 +69,75 @@ type KeyAssociationSha256Map map[string]KeyAssociation
 type KeyAssociationShaMap map[string]KeyAssociation
 
 type KeyAssociationMap struct {
+	SchemaVersion      int                     `json:"schemaVersion"`
 	KeyStatusSha1Map   KeyAssociationSha1Map   `json:"sha1"`
 	KeyStatusSha256Map KeyAssociationSha256Map `json:"sha256"`
+	// Checksum is the sha256 of the file content with an empty checksum
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// checksum returns the checksum of the key status map content
+func (m KeyAssociationMap) checksum() (string, error) {
+	m.Checksum = ""
+	content, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// marshalKeyStatus returns the key status file content of the map, with the current schema version and its checksum
+func marshalKeyStatus(m KeyAssociationMap) ([]byte, error) {
+	m.SchemaVersion = KEY_STATUS_SCHEMA_VERSION
+	checksum, err := m.checksum()
+	if err != nil {
+		return nil, err
+	}
+	m.Checksum = checksum
+	return json.Marshal(m)
+}
+
+// parseKeyStatus parses the key status file content. Files without schema version are accepted as they are
+// migrated when rewritten, files of the current schema must match their checksum and newer schemas are rejected
+func parseKeyStatus(content []byte) (KeyAssociationMap, error) {
+	var m KeyAssociationMap
+	if err := json.Unmarshal(content, &m); err != nil {
+		return m, err
+	}
+	switch {
+	case m.SchemaVersion > KEY_STATUS_SCHEMA_VERSION:
+		return m, fmt.Errorf("%w: %d", errKeyStatusSchemaUnsupported, m.SchemaVersion)
+	case m.SchemaVersion == KEY_STATUS_SCHEMA_VERSION:
+		checksum, err := m.checksum()
+		if err != nil {
+			return m, err
+		}
+		if m.Checksum != checksum {
+			return m, fmt.Errorf("key status file checksum mismatch: recorded %q, computed %q", m.Checksum, checksum)
+		}
+	}
+	return m, nil
 }
 
-type KeySelectiveMap map[string]string
+// mergeKeyAssociation returns the association provided, keeping the lifecycle metadata recorded for it.
+// The hiding metadata is dropped when the key pair is active again
+func mergeKeyAssociation(recorded KeyAssociation, assoc KeyAssociation) KeyAssociation {
+	if recorded.Created != nil {
+		assoc.Created = recorded.Created
+	}
+	if !isHiddenKeyFile(path.Base(assoc.SigningKey)) {
+		assoc.Hidden, assoc.RotatedBy, assoc.Reason = nil, "", ""
+		return assoc
+	}
+	if recorded.Hidden != nil {
+		assoc.Hidden = recorded.Hidden
+	}
+	if recorded.RotatedBy != "" {
+		assoc.RotatedBy, assoc.Reason = recorded.RotatedBy, recorded.Reason
+	}
+	return assoc
+}
 
 func keyStatusFile() string {
 	return KEY_STATUS_FILE_NAME
@@ -58,103 +155,122 @@ func keyStatusLockFilePath(k KeyAssociationInfo) string {
 	return getDefaultKeyPath(k.KeyInfo.TangServer) + "/" + KEY_STATUS_FILE_NAME + ".lock"
 }
 
-func deleteHiddenKeysSelectively(keepKeys KeySelectiveMap, keyinfo KeyObtainInfo) error {
-	// If Key Status File Exist, unmarshal it
-	statusFile := keyStatusFilePathWithTangServer(keyinfo.TangServer)
-	command := "cat " + statusFile
-	GetLogInstance().Info("deleteHiddenKeysSelectively", "Keys to keep", keepKeys)
-	stdo, _, e := podCommandExec(command, "", keyinfo.PodName, keyinfo.Namespace, nil)
-	if e != nil {
-		GetLogInstance().Error(e, "deleteHiddenKeysSelectively: Unable to read status file", "statusFile", statusFile)
-	} else {
-		var KeyStatusMap KeyAssociationMap
-		if err := json.Unmarshal([]byte(stdo), &KeyStatusMap); err != nil {
-			GetLogInstance().Error(err, "deleteHiddenKeysSelectively: Unable to unmarshal status file", "Status File", statusFile, "JSON content", stdo)
-			return err
-		}
-		// Join Both Sha Maps
-		joinedMaps := make(KeyAssociationShaMap, 0)
-		for k1, v1 := range KeyStatusMap.KeyStatusSha1Map {
-			joinedMaps[k1] = v1
-		}
-		for k2, v2 := range KeyStatusMap.KeyStatusSha256Map {
-			joinedMaps[k2] = v2
-		}
-
-		for k, v := range joinedMaps {
-			if _, found := keepKeys[k]; !found {
-				//remove only if it is a hidden key
-				for _, hsk := range keyinfo.TangServer.Status.HiddenKeys {
-					if k == hsk.Sha1 || k == hsk.Sha256 {
-						//delete signing and encryption hidden keys!!
-						GetLogInstance().Info("deleteHiddenKeysSelectively: deletePodFiles", "Key Association", v, "SHA1/SHA256 not found", k)
-						if e := deletePodFile(keyinfo, v); e != nil {
-							GetLogInstance().Error(e, "deleteHiddenKeysSelectively: Error Deleting Key Association Files", "Key Association", v)
-						} else {
-							GetLogInstance().Info("deleteHiddenKeysSelectively: Keys Deleted Correctly", "Key Association", v)
-						}
-					}
-				}
-			}
-		}
+// readKeyStatus returns the key associations recorded in the key status file
+func readKeyStatus(ctx context.Context, k KeyObtainInfo) (KeyAssociationMap, error) {
+	stdo, _, err := k.podExec(ctx, nil, "cat", keyStatusFilePathWithTangServer(k.TangServer))
+	if err != nil {
+		return KeyAssociationMap{}, err
 	}
-	return nil
+	return parseKeyStatus([]byte(stdo))
 }
 
-func dumpKeyAssociation(k KeyAssociationInfo) error {
-	updateForbiddenMap(keyStatusFile())
-	// If lock file exists, do nothing
-	keyStatusLockFilePath := keyStatusLockFilePath(k)
-	command := "test -f " + keyStatusLockFilePath
-	_, _, err := podCommandExec(command, "", k.KeyInfo.PodName, k.KeyInfo.Namespace, nil)
-	if err == nil {
-		GetLogInstance().Info("Lock operation in progress")
-		return nil
-	}
-	// Lock
-	command = "touch " + keyStatusLockFilePath
-	_, _, err = podCommandExec(command, "", k.KeyInfo.PodName, k.KeyInfo.Namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to lock status file")
-		return err
-	}
+// dumpKeyAssociations records the key associations provided in the key status file, and removes the ones
+// of the thumbprints to drop. If the existing keys are provided, the associations of the keys missing from
+// them are removed too. The file is only rewritten if its content changes. Concurrent updates are
+// prevented by the key lock of the TangServer, held during key management
+func dumpKeyAssociations(ctx context.Context, keyInfo KeyObtainInfo, assocs []KeyAssociation, drop []string, existing []KeyMetadata) error {
+	l := log.FromContext(ctx)
+	k := KeyAssociationInfo{KeyInfo: &keyInfo}
 	var KeyStatusMap KeyAssociationMap
+	var err error
+	statusFile := keyStatusFilePath(k)
+	// If Key Status File Exist, parse it
+	stdo, _, e := keyInfo.podExec(ctx, nil, "cat", statusFile)
+	if e == nil {
+		l.Info("Updating status map with key status file")
+		KeyStatusMap, err = parseKeyStatus([]byte(stdo))
+		if errors.Is(err, errKeyStatusSchemaUnsupported) {
+			l.Error(err, "Key status file written by a newer operator, not updated", "Status File", statusFile)
+			return err
+		} else if err != nil {
+			// Associations are recorded again from the key pairs, only the lifecycle metadata is lost
+			l.Error(err, "Unable to parse status file, rewriting it", "Status File", statusFile, "JSON content", stdo)
+			KeyStatusMap = KeyAssociationMap{}
+		} else if KeyStatusMap.SchemaVersion < KEY_STATUS_SCHEMA_VERSION {
+			l.Info("Migrating key status file", "Status File", statusFile, "from", KeyStatusMap.SchemaVersion,
+				"to", KEY_STATUS_SCHEMA_VERSION)
+		}
+	}
 	if KeyStatusMap.KeyStatusSha1Map == nil {
-		KeyStatusMap.KeyStatusSha1Map = make(KeyAssociationSha1Map, 1)
+		KeyStatusMap.KeyStatusSha1Map = make(KeyAssociationSha1Map, len(assocs))
 	}
 	if KeyStatusMap.KeyStatusSha256Map == nil {
-		KeyStatusMap.KeyStatusSha256Map = make(KeyAssociationSha256Map, 1)
+		KeyStatusMap.KeyStatusSha256Map = make(KeyAssociationSha256Map, len(assocs))
 	}
-	statusFile := keyStatusFilePath(k)
-	// If Key Status File Exist, unmarshal it
-	command = "cat " + statusFile
-	stdo, _, e := podCommandExec(command, "", k.KeyInfo.PodName, k.KeyInfo.Namespace, nil)
-	if e == nil {
-		GetLogInstance().Info("Updating status map with key status file")
-		if err = json.Unmarshal([]byte(stdo), &KeyStatusMap); err != nil {
-			GetLogInstance().Error(err, "Unable to unmarshal status file", "Status File", statusFile, "JSON content", stdo)
+	for _, sha := range drop {
+		delete(KeyStatusMap.KeyStatusSha1Map, sha)
+		delete(KeyStatusMap.KeyStatusSha256Map, sha)
+	}
+	if existing != nil {
+		sha1s := make(map[string]bool, len(existing))
+		sha256s := make(map[string]bool, len(existing))
+		for _, m := range existing {
+			sha1s[m.Sha1] = true
+			sha256s[m.Sha256] = true
+		}
+		for sha := range KeyStatusMap.KeyStatusSha1Map {
+			if !sha1s[sha] {
+				delete(KeyStatusMap.KeyStatusSha1Map, sha)
+			}
+		}
+		for sha := range KeyStatusMap.KeyStatusSha256Map {
+			if !sha256s[sha] {
+				delete(KeyStatusMap.KeyStatusSha256Map, sha)
+			}
 		}
 	}
-	delete(KeyStatusMap.KeyStatusSha1Map, k.KeyAssoc.Sha1)
-	delete(KeyStatusMap.KeyStatusSha256Map, k.KeyAssoc.Sha256)
-	KeyStatusMap.KeyStatusSha1Map[k.KeyAssoc.Sha1] = k.KeyAssoc
-	KeyStatusMap.KeyStatusSha256Map[k.KeyAssoc.Sha256] = k.KeyAssoc
-	keyStatus, err := json.Marshal(KeyStatusMap)
-	if err != nil {
-		GetLogInstance().Error(err, "Error on KeyStatusMap marshalling", "file", statusFile, "keyStatusMap", KeyStatusMap)
+	for _, assoc := range assocs {
+		assoc = mergeKeyAssociation(KeyStatusMap.KeyStatusSha1Map[assoc.Sha1], assoc)
+		KeyStatusMap.KeyStatusSha1Map[assoc.Sha1] = assoc
+		KeyStatusMap.KeyStatusSha256Map[assoc.Sha256] = assoc
 	}
-	GetLogInstance().Info("Dumping key status to file", "file", statusFile, "keyStatus", string(keyStatus))
-	err = dumpKeyStatusFileWithEchoRedirection(statusFile, keyStatus, k.KeyInfo.PodName, k.KeyInfo.Namespace)
+	keyStatus, err := marshalKeyStatus(KeyStatusMap)
 	if err != nil {
-		GetLogInstance().Error(err, "Error Dumping Key Status File", "file", statusFile, "keyStatus", string(keyStatus))
+		l.Error(err, "Error on KeyStatusMap marshalling", "file", statusFile, "keyStatusMap", KeyStatusMap)
+	} else if e == nil && string(keyStatus) == stdo {
+		l.Info("Key status file up to date", "file", statusFile)
+	} else {
+		l.Info("Dumping key status to file", "file", statusFile, "keyStatus", string(keyStatus))
+		err = writePodFileAtomically(ctx, keyInfo, statusFile, keyStatus)
+		if err != nil {
+			l.Error(err, "Error Dumping Key Status File", "file", statusFile, "keyStatus", string(keyStatus))
+		} else if _, _, e := keyInfo.podExec(ctx, nil, "rm", "-f", keyStatusLockFilePath(k)); e != nil {
+			// Lock files of previous operator versions are no longer used
+			l.Error(e, "Unable to delete legacy lock status file")
+		}
 	}
+	return err
+}
 
-	// Unlock
-	command = "rm -fr " + keyStatusLockFilePath
-	_, _, err = podCommandExec(command, "", k.KeyInfo.PodName, k.KeyInfo.Namespace, nil)
-	if err != nil {
-		GetLogInstance().Error(err, "Unable to delete lock status file")
-		return err
+// hidingCause returns the agent that hid the key pair and the reason, as far as the operator can tell
+func hidingCause(ts *daemonsv1alpha1.TangServer, p KeyPair) (string, string) {
+	for _, hk := range ts.Spec.HiddenKeys {
+		if hiddenKeyMatches(hk, p) {
+			return KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON
+		}
 	}
-	return err
+	return KEY_HIDDEN_BY_EXTERNAL, "Key hidden outside of the operator"
+}
+
+// writeKeyPairs records the key pairs and their lifecycle in the key status file. Associations previously
+// recorded for ambiguous keys are removed, so that they are never used to delete keys, and so are the ones
+// of the keys no longer found
+func writeKeyPairs(ctx context.Context, keyInfo KeyObtainInfo, pairing KeyPairing) error {
+	assocs := make([]KeyAssociation, 0, len(pairing.Pairs))
+	for _, p := range pairing.Pairs {
+		assoc := p.association(keyInfo.DbPath)
+		created := p.Signing.File.ModTime
+		assoc.Created = &created
+		if p.Hidden() {
+			hidden := p.Signing.File.ChangeTime
+			assoc.Hidden = &hidden
+			assoc.RotatedBy, assoc.Reason = hidingCause(keyInfo.TangServer, p)
+		}
+		assocs = append(assocs, assoc)
+	}
+	drop := make([]string, 0)
+	for _, k := range pairing.Ambiguous {
+		drop = append(drop, k.Sha1, k.Sha256)
+	}
+	return dumpKeyAssociations(ctx, keyInfo, assocs, drop, pairing.keys())
 }
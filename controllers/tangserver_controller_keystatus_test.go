@@ -17,9 +17,12 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -109,39 +112,6 @@ var _ = Describe("TangServer controller key status functions", func() {
 	})
 
 	Context("When testing key association functions", func() {
-		var tempDir string
-		var keyInfo KeyObtainInfo
-		var tangServer *daemonsv1alpha1.TangServer
-
-		BeforeEach(func() {
-			var err error
-			tempDir, err = os.MkdirTemp("", "tang-test-*")
-			Expect(err).To(BeNil())
-
-			tangServer = &daemonsv1alpha1.TangServer{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      "test-tang",
-					Namespace: "test-namespace",
-				},
-				Spec: daemonsv1alpha1.TangServerSpec{
-					KeyPath: tempDir,
-				},
-			}
-
-			keyInfo = KeyObtainInfo{
-				PodName:    "test-pod",
-				Namespace:  "test-namespace",
-				DbPath:     tempDir,
-				TangServer: tangServer,
-			}
-		})
-
-		AfterEach(func() {
-			if tempDir != "" {
-				os.RemoveAll(tempDir)
-			}
-		})
-
 		It("Should test key association data structure", func() {
 			sha1 := "test-sha1"
 			sha256 := "test-sha256"
@@ -185,23 +155,6 @@ var _ = Describe("TangServer controller key status functions", func() {
 			Expect(parsedAssoc.Sha256).To(Equal(""))
 		})
 
-		It("Should test dumpKeyAssociation function signature", func() {
-			if !isCluster() {
-				Skip("Avoiding test that requires cluster/pod execution")
-			}
-			// Test that the function exists and can be called
-			// Note: This function likely requires pod execution which we can't fully test here
-			keyAssocInfo := KeyAssociationInfo{
-				KeyInfo:  &keyInfo,
-				KeyAssoc: KeyAssociation{},
-			}
-
-			// Just verify the function can be called without panicking
-			// The actual implementation requires pod command execution
-			Expect(func() {
-				_ = dumpKeyAssociation(keyAssocInfo)
-			}).ToNot(Panic())
-		})
 	})
 
 	Context("When testing key status file operations", func() {
@@ -239,37 +192,216 @@ var _ = Describe("TangServer controller key status functions", func() {
 			Expect(statusPath).To(Equal(expectedPath))
 		})
 
-		It("Should test writeStatusFile function signature", func() {
-			if !isCluster() {
-				Skip("Avoiding test that requires cluster/pod execution")
+	})
+
+	Context("When handling the key status file of a tang pod", func() {
+		const keyPath = "/var/db/tang"
+		var (
+			executor *fakePodExecutor
+			keyInfo  KeyObtainInfo
+		)
+		ctx := context.Background()
+
+		// hidePair hides a key pair, records its association and returns the thumbprints of its signing key
+		hidePair := func() (string, string, string, string) {
+			signing, exchange := executor.addKeyPair(keyPath)
+			for _, name := range []string{signing, exchange} {
+				Expect(moveKeyFile(ctx, keyInfo, name, "."+name)).To(Succeed())
 			}
-			// Test that the function exists and can be called
-			sha1 := "test-sha1"
-			sha256 := "test-sha256"
-			signing := "signing-key"
-			encryption := "encryption-key"
+			content, _ := executor.fileContent(keyPath + "/." + signing)
+			sha1, _ := fakeThumbprint(content, "S1")
+			sha256, _ := fakeThumbprint(content, "S256")
+			Expect(dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{{Sha1: sha1, Sha256: sha256, SigningKey: keyPath + "/." + signing, EncriptionKey: keyPath + "/." + exchange}}, nil, nil)).To(Succeed())
+			keyInfo.TangServer.Status.HiddenKeys = append(keyInfo.TangServer.Status.HiddenKeys,
+				daemonsv1alpha1.TangServerHiddenKeys{Sha1: sha1, Sha256: sha256, FileName: "." + signing})
+			return sha1, sha256, "." + signing, "." + exchange
+		}
 
-			// Create a proper keyInfo structure for the test
-			testKeyInfo := KeyObtainInfo{
+		BeforeEach(func() {
+			executor = newFakePodExecutor()
+			keyInfo = KeyObtainInfo{
 				PodName:   "test-pod",
 				Namespace: "test-namespace",
-				DbPath:    tempDir,
+				DbPath:    keyPath,
 				TangServer: &daemonsv1alpha1.TangServer{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "test-tang",
 						Namespace: "test-namespace",
 					},
 					Spec: daemonsv1alpha1.TangServerSpec{
-						KeyPath: tempDir,
+						KeyPath: keyPath,
 					},
 				},
+				Executor: executor,
+			}
+		})
+
+		It("Should record key associations by thumbprint", func() {
+			sha1, sha256, signing, exchange := hidePair()
+			content, found := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(found).To(BeTrue())
+			var keyStatusMap KeyAssociationMap
+			Expect(json.Unmarshal(content, &keyStatusMap)).To(Succeed())
+			Expect(keyStatusMap.KeyStatusSha1Map[sha1].SigningKey).To(Equal(keyPath + "/" + signing))
+			Expect(keyStatusMap.KeyStatusSha256Map[sha256].EncriptionKey).To(Equal(keyPath + "/" + exchange))
+			Expect(executor.entries(keyPath)).ToNot(ContainElement(KEY_STATUS_FILE_NAME + ".lock"))
+		})
+
+		It("Should read the key associations recorded", func() {
+			sha1, sha256, signing, exchange := hidePair()
+			keyStatusMap, err := readKeyStatus(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyStatusMap.KeyStatusSha1Map[sha1].SigningKey).To(Equal(keyPath + "/" + signing))
+			Expect(keyStatusMap.KeyStatusSha256Map[sha256].EncriptionKey).To(Equal(keyPath + "/" + exchange))
+		})
+
+		It("Should not delete files out of the key directory", func() {
+			for _, names := range [][]string{
+				{"/etc/passwd", ".exc.jwk"},
+				{".sig.jwk", "../exc.jwk"},
+				{".sig.jwk", "exc .jwk"},
+			} {
+				Expect(removeKeyFiles(ctx, keyInfo, names)).ToNot(Succeed())
+			}
+			Expect(executor.commands).To(BeEmpty())
+		})
+
+		It("Should fail reading a corrupted key status file", func() {
+			hidePair()
+			executor.addFile(keyPath+"/"+KEY_STATUS_FILE_NAME, []byte("not json"))
+			_, err := readKeyStatus(ctx, keyInfo)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should write the key status file atomically with schema version and checksum", func() {
+			hidePair()
+			Expect(executor.commands).To(ContainElements(
+				"tee "+keyPath+"/"+KEY_STATUS_FILE_NAME+".tmp",
+				"mv -f "+keyPath+"/"+KEY_STATUS_FILE_NAME+".tmp "+keyPath+"/"+KEY_STATUS_FILE_NAME))
+			Expect(executor.entries(keyPath)).ToNot(ContainElement(KEY_STATUS_FILE_NAME + ".tmp"))
+			content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			keyStatusMap, err := parseKeyStatus(content)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyStatusMap.SchemaVersion).To(Equal(KEY_STATUS_SCHEMA_VERSION))
+			Expect(keyStatusMap.Checksum).To(HavePrefix("sha256:"))
+		})
+
+		It("Should keep the previous key status file if it can not be replaced", func() {
+			hidePair()
+			previous, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			executor.failOn = []string{"mv -f"}
+			Expect(dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{{Sha1: "sha1", Sha256: "sha256", SigningKey: keyPath + "/.s.jwk", EncriptionKey: keyPath + "/.e.jwk"}}, nil, nil)).ToNot(Succeed())
+			content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(content).To(Equal(previous))
+			Expect(executor.entries(keyPath)).ToNot(ContainElement(KEY_STATUS_FILE_NAME + ".tmp"))
+		})
+
+		It("Should migrate key status files without schema version", func() {
+			executor.addFile(keyPath+"/"+KEY_STATUS_FILE_NAME, []byte(`{"sha1":{"old1":{"signing":"`+keyPath+
+				`/.old-sig.jwk","encryption":"`+keyPath+`/.old-exc.jwk"}},"sha256":{"old256":{"signing":"`+keyPath+
+				`/.old-sig.jwk","encryption":"`+keyPath+`/.old-exc.jwk"}}}`))
+			sha1, _, signing, _ := hidePair()
+			content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			keyStatusMap, err := parseKeyStatus(content)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyStatusMap.SchemaVersion).To(Equal(KEY_STATUS_SCHEMA_VERSION))
+			Expect(keyStatusMap.KeyStatusSha1Map).To(HaveKey("old1"))
+			Expect(keyStatusMap.KeyStatusSha256Map["old256"].SigningKey).To(Equal(keyPath + "/.old-sig.jwk"))
+			Expect(keyStatusMap.KeyStatusSha1Map[sha1].SigningKey).To(Equal(keyPath + "/" + signing))
+		})
+
+		It("Should detect modified key status files", func() {
+			hidePair()
+			content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			tampered := strings.Replace(string(content), keyPath+"/.", keyPath+"/.x", 1)
+			_, err := parseKeyStatus([]byte(tampered))
+			Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+			executor.addFile(keyPath+"/"+KEY_STATUS_FILE_NAME, []byte(tampered))
+			_, err = readKeyStatus(ctx, keyInfo)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should not overwrite key status files of a newer schema", func() {
+			newer := []byte(`{"schemaVersion":2,"keys":[]}`)
+			executor.addFile(keyPath+"/"+KEY_STATUS_FILE_NAME, newer)
+			err := dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{{Sha1: "sha1", Sha256: "sha256", SigningKey: keyPath + "/.s.jwk", EncriptionKey: keyPath + "/.e.jwk"}}, nil, nil)
+			Expect(errors.Is(err, errKeyStatusSchemaUnsupported)).To(BeTrue())
+			content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(content).To(Equal(newer))
+			Expect(executor.entries(keyPath)).ToNot(ContainElement(KEY_STATUS_FILE_NAME + ".lock"))
+		})
+
+		It("Should record and keep the lifecycle of the key pairs", func() {
+			keyInfo.inventory = &keyInventoryCache{}
+			signing, exchange := executor.addKeyPair(keyPath)
+			executor.addKeyPair(keyPath)
+			content, _ := executor.fileContent(keyPath + "/" + signing)
+			sha1, _ := fakeThumbprint(content, "S1")
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha1: sha1}}
+			for _, name := range []string{signing, exchange} {
+				Expect(moveKeyFile(ctx, keyInfo, name, "."+name)).To(Succeed())
+			}
+			readStatus := func() KeyAssociationMap {
+				inventory, err := readKeyInventory(ctx, keyInfo)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(writeKeyPairs(ctx, keyInfo, pairKeys(inventory))).To(Succeed())
+				content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+				keyStatusMap, err := parseKeyStatus(content)
+				Expect(err).ToNot(HaveOccurred())
+				return keyStatusMap
+			}
+			hidden := readStatus().KeyStatusSha1Map[sha1]
+			Expect(hidden.Created).ToNot(BeNil())
+			Expect(hidden.Hidden).ToNot(BeNil())
+			Expect(hidden.RotatedBy).To(Equal(KEY_HIDDEN_BY_SPEC))
+			Expect(hidden.Reason).ToNot(BeEmpty())
+			for _, assoc := range readStatus().KeyStatusSha1Map {
+				if assoc.SigningKey != hidden.SigningKey {
+					Expect(assoc.Hidden).To(BeNil())
+					Expect(assoc.RotatedBy).To(BeEmpty())
+				}
+			}
+
+			// The hiding metadata first recorded is kept
+			keyInfo.TangServer.Spec.HiddenKeys = nil
+			executor.commands = nil
+			Expect(readStatus().KeyStatusSha1Map[sha1]).To(Equal(hidden))
+			Expect(executor.commands).ToNot(ContainElement(HavePrefix("tee ")))
+		})
+
+		It("Should remove the associations of the key pairs no longer found", func() {
+			keyInfo.inventory = &keyInventoryCache{}
+			signing, exchange := executor.addKeyPair(keyPath)
+			executor.addKeyPair(keyPath)
+			content, _ := executor.fileContent(keyPath + "/" + signing)
+			sha1, sha256 := fakeThumbprint(content, "S1")
+			writeStatus := func() KeyAssociationMap {
+				inventory, err := readKeyInventory(ctx, keyInfo)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(writeKeyPairs(ctx, keyInfo, pairKeys(inventory))).To(Succeed())
+				content, _ := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+				keyStatusMap, err := parseKeyStatus(content)
+				Expect(err).ToNot(HaveOccurred())
+				return keyStatusMap
 			}
+			Expect(writeStatus().KeyStatusSha1Map).To(HaveLen(2))
+			_, _, err := keyInfo.podExec(ctx, nil, "rm", "-f", keyPath+"/"+signing, keyPath+"/"+exchange)
+			Expect(err).ToNot(HaveOccurred())
+			keyStatusMap := writeStatus()
+			Expect(keyStatusMap.KeyStatusSha1Map).To(HaveLen(1))
+			Expect(keyStatusMap.KeyStatusSha1Map).ToNot(HaveKey(sha1))
+			Expect(keyStatusMap.KeyStatusSha256Map).To(HaveLen(1))
+			Expect(keyStatusMap.KeyStatusSha256Map).ToNot(HaveKey(sha256))
+		})
 
-			// Note: This function likely requires pod execution which we can't fully test here
-			// Just verify the function can be called without panicking
-			Expect(func() {
-				_ = writeStatusFile(testKeyInfo, sha1, sha256, signing, encryption)
-			}).ToNot(Panic())
+		It("Should only attribute hidden keys to spec for the thumbprints listed", func() {
+			pair := KeyPair{Signing: KeyMetadata{Sha256: "signing-sha256"}}
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha256: "other-sha256"}}
+			rotatedBy, _ := hidingCause(keyInfo.TangServer, pair)
+			Expect(rotatedBy).To(Equal(KEY_HIDDEN_BY_EXTERNAL))
+			keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha256: "signing-sha256"}}
+			rotatedBy, _ = hidingCause(keyInfo.TangServer, pair)
+			Expect(rotatedBy).To(Equal(KEY_HIDDEN_BY_SPEC))
 		})
 	})
 })
@@ -0,0 +1,191 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Suffix of the name of the Lease that serialises the key directory mutations of a TangServer
+const KEY_LOCK_LEASE_SUFFIX = "-keys-lock"
+
+// Time the key lock is held beyond the key management timeout, so it never expires while keys are managed
+const KEY_LOCK_LEASE_MARGIN = 30 * time.Second
+
+// Default recheck of keys when the key lock is held by another holder
+const DEFAULT_RECONCILE_TIMER_KEYS_LOCKED = 5 // seconds
+
+// defaultLockIdentity identifies this operator process as key lock holder
+var defaultLockIdentity = func() string {
+	hostname, _ := os.Hostname()
+	return hostname + "_" + string(uuid.NewUUID())
+}()
+
+// getLockIdentity returns the key lock holder identity of the reconciler
+func (r *TangServerReconciler) getLockIdentity() string {
+	if r.LockIdentity != "" {
+		return r.LockIdentity
+	}
+	return defaultLockIdentity
+}
+
+// getKeyLockDuration returns the time the key lock is held if it is not released
+func (r *TangServerReconciler) getKeyLockDuration() time.Duration {
+	return r.getKeyManagementTimeout() + KEY_LOCK_LEASE_MARGIN
+}
+
+// keyLockLeaseName returns the name of the Lease used as key lock of the TangServer
+func keyLockLeaseName(cr *daemonsv1alpha1.TangServer) string {
+	return cr.Name + KEY_LOCK_LEASE_SUFFIX
+}
+
+// leaseHolder returns the holder of the Lease, empty if it is not held
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseSince returns the time the Lease holder acquired it, formatted for the condition messages
+func leaseSince(lease *coordinationv1.Lease) string {
+	if lease.Spec.AcquireTime == nil {
+		return "unknown time"
+	}
+	return lease.Spec.AcquireTime.UTC().Format(time.RFC3339)
+}
+
+// leaseExpired returns true if the Lease holder did not renew it within its duration
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if leaseHolder(lease) == "" || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// acquireKeyLock takes the key lock of the TangServer, returning true if it is held by this reconciler.
+// Locks not renewed by their holder within their duration are stale, and are recovered. Key management
+// is bounded by the key management timeout, shorter than the lock duration, so the lock is never renewed.
+// The lock is read from the API server, as a cached copy may miss the latest holder
+func (r *TangServerReconciler) acquireKeyLock(ctx context.Context, cr *daemonsv1alpha1.TangServer) (bool, error) {
+	l := log.FromContext(ctx)
+	identity := r.getLockIdentity()
+	seconds := int32(r.getKeyLockDuration().Seconds())
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{}
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: keyLockLeaseName(cr)}, lease)
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: keyLockLeaseName(cr), Namespace: cr.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if err := controllerutil.SetControllerReference(cr, lease, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, lease); err != nil {
+			if errors.IsAlreadyExists(err) {
+				l.Info("Key lock created concurrently by another holder")
+				setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionTrue, daemonsv1alpha1.ReasonKeyLockHeld,
+					"Key directory locked by another holder")
+				return false, nil
+			}
+			return false, err
+		}
+		setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionFalse, daemonsv1alpha1.ReasonKeyLockAvailable,
+			"Key directory lock available")
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	holder := leaseHolder(lease)
+	if holder != "" && holder != identity && !leaseExpired(lease, now.Time) {
+		l.Info("Key directory locked", "Holder", holder, "Since", leaseSince(lease))
+		setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionTrue, daemonsv1alpha1.ReasonKeyLockHeld,
+			fmt.Sprintf("Key directory locked by %s since %s", holder, leaseSince(lease)))
+		return false, nil
+	}
+	stale := holder != "" && holder != identity
+	staleSince := leaseSince(lease)
+	if holder != identity {
+		lease.Spec.AcquireTime = &now
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if err := r.Update(ctx, lease); err != nil {
+		if errors.IsConflict(err) {
+			l.Info("Key lock taken concurrently by another holder")
+			setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionTrue, daemonsv1alpha1.ReasonKeyLockHeld,
+				"Key directory locked by another holder")
+			return false, nil
+		}
+		return false, err
+	}
+	if stale {
+		message := fmt.Sprintf("Stale key directory lock of %s, held since %s, recovered", holder, staleSince)
+		l.Info("Stale key lock recovered", "Holder", holder, "Since", staleSince)
+		r.Recorder.Eventf(cr, nil, "Warning", "KeyLockRecovered", "KeyLockRecovered", "%s", message)
+		setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionFalse, daemonsv1alpha1.ReasonKeyLockRecovered, message)
+	} else {
+		setCondition(cr, daemonsv1alpha1.ConditionKeysLocked, metav1.ConditionFalse, daemonsv1alpha1.ReasonKeyLockAvailable,
+			"Key directory lock available")
+	}
+	return true, nil
+}
+
+// releaseKeyLock releases the key lock of the TangServer, if it is held by this reconciler.
+// A lock that could not be released expires after its duration
+func (r *TangServerReconciler) releaseKeyLock(ctx context.Context, cr *daemonsv1alpha1.TangServer) {
+	l := log.FromContext(ctx)
+	lease := &coordinationv1.Lease{}
+	if err := r.getAPIReader().Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: keyLockLeaseName(cr)}, lease); err != nil {
+		l.Error(err, "Unable to get key lock to release it")
+		return
+	}
+	if leaseHolder(lease) != r.getLockIdentity() {
+		return
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if err := r.Update(ctx, lease); err != nil {
+		l.Error(err, "Unable to release key lock, it will expire", "Duration", r.getKeyLockDuration())
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("TangServer controller key lock", func() {
+	var (
+		tangServer *daemonsv1alpha1.TangServer
+		reconciler *TangServerReconciler
+		recorder   *events.FakeRecorder
+		fakeClient client.Client
+	)
+	ctx := context.Background()
+	leaseName := types.NamespacedName{Namespace: "default", Name: "test-tang-lock" + KEY_LOCK_LEASE_SUFFIX}
+
+	// newReconciler returns a reconciler sharing the fake client, with the lock identity provided
+	newReconciler := func(identity string) *TangServerReconciler {
+		return &TangServerReconciler{
+			Client:       fakeClient,
+			Scheme:       scheme.Scheme,
+			Recorder:     recorder,
+			LockIdentity: identity,
+		}
+	}
+
+	// getLease returns the key lock of the TangServer
+	getLease := func() *coordinationv1.Lease {
+		lease := &coordinationv1.Lease{}
+		Expect(fakeClient.Get(ctx, leaseName, lease)).To(Succeed())
+		return lease
+	}
+
+	BeforeEach(func() {
+		scheme.Scheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+			&daemonsv1alpha1.TangServer{},
+			&daemonsv1alpha1.TangServerList{},
+		)
+		tangServer = &daemonsv1alpha1.TangServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-tang-lock", Namespace: "default", UID: "test-uid-lock"},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tangServer).Build()
+		recorder = events.NewFakeRecorder(100)
+		reconciler = newReconciler("operator-a")
+	})
+
+	It("Should create the key lock owned by the TangServer and release it", func() {
+		locked, err := reconciler.acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeTrue())
+		lease := getLease()
+		Expect(*lease.Spec.HolderIdentity).To(Equal("operator-a"))
+		Expect(*lease.Spec.LeaseDurationSeconds).To(Equal(int32((DEFAULT_KEY_MANAGEMENT_TIMEOUT + KEY_LOCK_LEASE_MARGIN).Seconds())))
+		Expect(lease.OwnerReferences).To(HaveLen(1))
+		Expect(lease.OwnerReferences[0].UID).To(Equal(tangServer.UID))
+		condition := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysLocked)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(daemonsv1alpha1.ReasonKeyLockAvailable))
+
+		reconciler.releaseKeyLock(ctx, tangServer)
+		Expect(getLease().Spec.HolderIdentity).To(BeNil())
+		locked, err = newReconciler("operator-b").acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeTrue())
+		Expect(*getLease().Spec.LeaseTransitions).To(Equal(int32(1)))
+	})
+
+	It("Should report the holder of a lock held by another holder and recheck keys", func() {
+		locked, err := newReconciler("operator-b").acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeTrue())
+
+		locked, err = reconciler.acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeFalse())
+		condition := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysLocked)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(daemonsv1alpha1.ReasonKeyLockHeld))
+		Expect(condition.Message).To(ContainSubstring("operator-b"))
+		Expect(condition.Message).To(ContainSubstring(getLease().Spec.AcquireTime.UTC().Format(time.RFC3339)))
+
+		// Releasing a lock held by another holder does nothing
+		reconciler.releaseKeyLock(ctx, tangServer)
+		Expect(*getLease().Spec.HolderIdentity).To(Equal("operator-b"))
+
+		result, requeue := reconciler.reconcilePeriodic(ctx, tangServer)
+		Expect(requeue).To(BeTrue())
+		Expect(result.RequeueAfter).To(Equal(time.Duration(DEFAULT_RECONCILE_TIMER_KEYS_LOCKED) * time.Second))
+	})
+
+	It("Should recover stale locks", func() {
+		holder := "crashed-operator"
+		seconds := int32(60)
+		acquired := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+		Expect(fakeClient.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName.Name, Namespace: leaseName.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &acquired,
+				RenewTime:            &acquired,
+			},
+		})).To(Succeed())
+
+		locked, err := reconciler.acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeTrue())
+		lease := getLease()
+		Expect(*lease.Spec.HolderIdentity).To(Equal("operator-a"))
+		Expect(lease.Spec.AcquireTime.After(acquired.Time)).To(BeTrue())
+		condition := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysLocked)
+		Expect(condition.Reason).To(Equal(daemonsv1alpha1.ReasonKeyLockRecovered))
+		Expect(condition.Message).To(ContainSubstring(holder))
+		Expect(recorder.Events).To(Receive(ContainSubstring("KeyLockRecovered")))
+	})
+
+	It("Should read the lock from the API server rather than the cache", func() {
+		locked, err := newReconciler("operator-b").acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeTrue())
+
+		// A cache not synchronised yet, missing the lock taken by the other holder
+		reconciler.Client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tangServer).Build()
+		reconciler.APIReader = fakeClient
+		locked, err = reconciler.acquireKeyLock(ctx, tangServer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(locked).To(BeFalse())
+		Expect(meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysLocked).Message).
+			To(ContainSubstring("operator-b"))
+	})
+
+	It("Should take again a lock held by itself", func() {
+		for i := 0; i < 2; i++ {
+			locked, err := reconciler.acquireKeyLock(ctx, tangServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(locked).To(BeTrue())
+		}
+		Expect(getLease().Spec.LeaseTransitions).To(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+	})
+})
@@ -0,0 +1,94 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// podExecTotal counts the commands executed in tang pods
+	podExecTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tangserver_pod_exec_total",
+		Help: "Number of commands executed in tang pods, by command and result",
+	}, []string{"command", "result"})
+	// podExecDuration measures the latency of the commands executed in tang pods
+	podExecDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tangserver_pod_exec_duration_seconds",
+		Help:    "Latency of the commands executed in tang pods, by command",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+	// reconcilePodExecs measures the commands executed in tang pods per reconciliation
+	reconcilePodExecs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tangserver_reconcile_pod_execs",
+		Help:    "Number of commands executed in tang pods per reconciliation",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100, 200},
+	})
+	// reconcilePodExecDuration measures the time spent executing commands in tang pods per reconciliation
+	reconcilePodExecDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tangserver_reconcile_pod_exec_duration_seconds",
+		Help:    "Time spent executing commands in tang pods per reconciliation",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(podExecTotal, podExecDuration, reconcilePodExecs, reconcilePodExecDuration)
+}
+
+// execStats accounts the commands executed in tang pods during a reconciliation, safe for concurrent use
+type execStats struct {
+	count    atomic.Int32
+	timeouts atomic.Int32
+	duration atomic.Int64
+}
+
+// record accounts a command executed in a pod, updating the per command metrics
+func (s *execStats) record(command []string, elapsed time.Duration, err error, timedOut bool) {
+	name := "unknown"
+	if len(command) > 0 {
+		name = path.Base(command[0])
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	podExecTotal.WithLabelValues(name, result).Inc()
+	podExecDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+	if s == nil {
+		return
+	}
+	s.count.Add(1)
+	s.duration.Add(int64(elapsed))
+	if timedOut {
+		s.timeouts.Add(1)
+	}
+}
+
+// observe updates the per reconciliation metrics with the commands accounted
+func (s *execStats) observe() {
+	if s == nil {
+		return
+	}
+	reconcilePodExecs.Observe(float64(s.count.Load()))
+	reconcilePodExecDuration.Observe(time.Duration(s.duration.Load()).Seconds())
+}
@@ -23,9 +23,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const DEFAULT_POD_RUNNING_PORT = 8080
+const DEFAULT_POD_RUNNING_PORT = daemonsv1alpha1.DefaultPodListenPort
 const DEFAULT_TANGSERVER_NAME = "tangserver"
-const DEFAULT_TANGSERVER_PVC_NAME = "tangserver-pvc"
+const DEFAULT_TANGSERVER_PVC_NAME = daemonsv1alpha1.DefaultPersistentVolumeClaim
 const DEFAULT_TANGSERVER_SECRET = "tangserversecret"
 
 // getPodListenPort function returns the internal port where tangserver will listen
@@ -16,10 +16,28 @@ limitations under the License.
 
 package controllers
 
-// deletePodFile function allows removing files indicated in the key association
-// from pod. Files must be specified with complete path
-func deletePodFile(keyinfo KeyObtainInfo, elem KeyAssociation) error {
-	command := "rm -v " + elem.SigningKey + " " + elem.EncriptionKey
-	_, _, e := podCommandExec(command, "", keyinfo.PodName, keyinfo.Namespace, nil)
-	return e
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Suffix of the temporary files written before being renamed to their final path
+const TEMPORARY_FILE_SUFFIX = ".tmp"
+
+// writePodFileAtomically writes the file content to a temporary file and renames it to the file path provided,
+// so that the file is never left partially written. The temporary file is removed if the rename fails
+func writePodFileAtomically(ctx context.Context, keyInfo KeyObtainInfo, filePath string, fileContent []byte) error {
+	tmpPath := filePath + TEMPORARY_FILE_SUFFIX
+	if err := writePodFile(ctx, keyInfo, tmpPath, fileContent); err != nil {
+		return err
+	}
+	if _, stde, err := keyInfo.podExec(ctx, nil, "mv", "-f", tmpPath, filePath); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to rename temporary file", "file", tmpPath, "stde", stde)
+		if _, _, e := keyInfo.podExec(ctx, nil, "rm", "-f", tmpPath); e != nil {
+			log.FromContext(ctx).Error(e, "Unable to remove temporary file", "file", tmpPath)
+		}
+		return err
+	}
+	return nil
 }
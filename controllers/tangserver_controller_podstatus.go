@@ -0,0 +1,141 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Waiting reason of the containers restarted with an increasing back-off after failing
+const CRASH_LOOP_BACK_OFF_REASON = "CrashLoopBackOff"
+
+// podContainerStatuses returns the statuses of the init and regular containers of a pod
+func podContainerStatuses(pod *corev1.Pod) []corev1.ContainerStatus {
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	return append(statuses, pod.Status.ContainerStatuses...)
+}
+
+// podRestarts returns the restarts of all the containers of a pod
+func podRestarts(pod *corev1.Pod) int32 {
+	restarts := int32(0)
+	for _, c := range podContainerStatuses(pod) {
+		restarts += c.RestartCount
+	}
+	return restarts
+}
+
+// isPodCrashLooping returns true if a container of the pod is waiting in CrashLoopBackOff
+func isPodCrashLooping(pod *corev1.Pod) bool {
+	for _, c := range podContainerStatuses(pod) {
+		if c.State.Waiting != nil && c.State.Waiting.Reason == CRASH_LOOP_BACK_OFF_REASON {
+			return true
+		}
+	}
+	return false
+}
+
+// isPodRunning returns true if the pod is running and not being deleted
+func isPodRunning(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil
+}
+
+// podImageID returns the image the tang container of the pod runs
+func podImageID(pod *corev1.Pod) string {
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.Name == DEFAULT_TANGSERVER_NAME {
+			return c.ImageID
+		}
+	}
+	return ""
+}
+
+// podStatuses returns the status of the pods provided, by name
+func podStatuses(pods []corev1.Pod) []daemonsv1alpha1.TangServerPodStatus {
+	statuses := make([]daemonsv1alpha1.TangServerPodStatus, 0, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		statuses = append(statuses, daemonsv1alpha1.TangServerPodStatus{
+			Name:         pod.Name,
+			NodeName:     pod.Spec.NodeName,
+			Phase:        string(pod.Status.Phase),
+			Ready:        isPodReady(pod),
+			Restarts:     podRestarts(pod),
+			CrashLooping: isPodCrashLooping(pod),
+			ImageID:      podImageID(pod),
+		})
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// updatePodStatus reports the status of each tang pod, the running and ready replicas derived from it, and the
+// crash looping pods through the PodsCrashLooping condition, with an event when they change
+func (r *TangServerReconciler) updatePodStatus(cr *daemonsv1alpha1.TangServer, pods []corev1.Pod) {
+	cr.Status.Pods = podStatuses(pods)
+	running, ready := int32(0), int32(0)
+	crashLooping := make([]string, 0)
+	for i := range pods {
+		if isPodRunning(&pods[i]) {
+			running++
+		}
+		if isPodReady(&pods[i]) {
+			ready++
+		}
+	}
+	for _, p := range cr.Status.Pods {
+		if p.CrashLooping {
+			crashLooping = append(crashLooping, p.Name)
+		}
+	}
+	cr.Status.Running = running
+	cr.Status.Ready = ready
+	if len(crashLooping) == 0 {
+		setCondition(cr, daemonsv1alpha1.ConditionPodsCrashLooping, metav1.ConditionFalse, daemonsv1alpha1.ReasonNoPodsCrashLooping,
+			fmt.Sprintf("%d/%d pods running", running, len(pods)))
+		return
+	}
+	message := "Pods crash looping: " + strings.Join(crashLooping, ", ")
+	if setCondition(cr, daemonsv1alpha1.ConditionPodsCrashLooping, metav1.ConditionTrue, daemonsv1alpha1.ReasonPodsCrashLooping, message) {
+		r.Recorder.Eventf(cr, nil, "Warning", "PodsCrashLooping", "PodsCrashLooping", "%s", message)
+	}
+}
+
+// setServedKeys reports in the status of each ready tang pod the active keys read from it
+func setServedKeys(cr *daemonsv1alpha1.TangServer) {
+	served := make(map[string][]string, len(cr.Status.ReplicaKeys))
+	for _, replica := range cr.Status.ReplicaKeys {
+		if replica.Error == "" {
+			served[replica.PodName] = replica.ActiveKeys
+		}
+	}
+	for i := range cr.Status.Pods {
+		p := &cr.Status.Pods[i]
+		p.ServedKeys = nil
+		if p.Ready {
+			p.ServedKeys = served[p.Name]
+		}
+	}
+}
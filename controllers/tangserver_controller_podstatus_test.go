@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller pod status", func() {
+	var (
+		tangServer *daemonsv1alpha1.TangServer
+		reconciler *TangServerReconciler
+		recorder   *events.FakeRecorder
+		pods       []corev1.Pod
+	)
+
+	// crashLooping returns a pod whose tang container is restarted with an increasing back-off
+	crashLooping := func(name string) corev1.Pod {
+		pod := readyPod(name, time.Now())
+		pod.Status.Conditions[0].Status = corev1.ConditionFalse
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:         DEFAULT_TANGSERVER_NAME,
+			RestartCount: 5,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: CRASH_LOOP_BACK_OFF_REASON}},
+		}}
+		return pod
+	}
+
+	BeforeEach(func() {
+		tangServer = &daemonsv1alpha1.TangServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-tangserver-pods", Namespace: "default"},
+			Spec:       daemonsv1alpha1.TangServerSpec{Replicas: 3},
+		}
+		recorder = events.NewFakeRecorder(100)
+		reconciler = &TangServerReconciler{Recorder: recorder}
+		ready := readyPod("tang-b", time.Now())
+		ready.Spec.NodeName = "node-1"
+		ready.Status.ContainerStatuses = []corev1.ContainerStatus{
+			{Name: DEFAULT_TANGSERVER_NAME, RestartCount: 1, ImageID: "quay.io/tang/tang@sha256:0123"},
+		}
+		pending := readyPod("tang-a", time.Now())
+		pending.Status.Phase = corev1.PodPending
+		pods = []corev1.Pod{ready, pending}
+	})
+
+	It("Should report each pod and derive running and ready replicas from them", func() {
+		reconciler.updatePodStatus(tangServer, pods)
+		Expect(tangServer.Status.Running).To(Equal(int32(1)))
+		Expect(tangServer.Status.Ready).To(Equal(int32(1)))
+		Expect(tangServer.Status.Pods).To(HaveLen(2))
+		Expect(tangServer.Status.Pods[0].Name).To(Equal("tang-a"))
+		Expect(tangServer.Status.Pods[0].Phase).To(Equal(string(corev1.PodPending)))
+		Expect(tangServer.Status.Pods[0].Ready).To(BeFalse())
+		Expect(tangServer.Status.Pods[1]).To(Equal(daemonsv1alpha1.TangServerPodStatus{
+			Name:     "tang-b",
+			NodeName: "node-1",
+			Phase:    string(corev1.PodRunning),
+			Ready:    true,
+			Restarts: 1,
+			ImageID:  "quay.io/tang/tang@sha256:0123",
+		}))
+		Expect(isConditionTrue(tangServer, daemonsv1alpha1.ConditionPodsCrashLooping)).To(BeFalse())
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("Should not count pods being deleted as running", func() {
+		now := metav1.Now()
+		pods[0].DeletionTimestamp = &now
+		reconciler.updatePodStatus(tangServer, pods)
+		Expect(tangServer.Status.Running).To(Equal(int32(0)))
+		Expect(tangServer.Status.Ready).To(Equal(int32(0)))
+	})
+
+	It("Should report crash looping pods through a condition and an event", func() {
+		pods = append(pods, crashLooping("tang-c"))
+		reconciler.updatePodStatus(tangServer, pods)
+		Expect(tangServer.Status.Pods[2].CrashLooping).To(BeTrue())
+		Expect(tangServer.Status.Pods[2].Restarts).To(Equal(int32(5)))
+		condition := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionPodsCrashLooping)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(Equal("Pods crash looping: tang-c"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("Pods crash looping: tang-c")))
+
+		// No new event while the same pods crash loop
+		reconciler.updatePodStatus(tangServer, pods)
+		Expect(recorder.Events).ToNot(Receive())
+
+		reconciler.updatePodStatus(tangServer, pods[:2])
+		Expect(isConditionTrue(tangServer, daemonsv1alpha1.ConditionPodsCrashLooping)).To(BeFalse())
+	})
+
+	It("Should report the keys served by ready pods", func() {
+		reconciler.updatePodStatus(tangServer, pods)
+		tangServer.Status.ReplicaKeys = []daemonsv1alpha1.TangServerReplicaKeys{
+			{PodName: "tang-a", ActiveKeys: []string{"stale"}},
+			{PodName: "tang-b", Authoritative: true, ActiveKeys: []string{"sha256-1", "sha256-2"}},
+		}
+		setServedKeys(tangServer)
+		Expect(tangServer.Status.Pods[0].ServedKeys).To(BeEmpty())
+		Expect(tangServer.Status.Pods[1].ServedKeys).To(ConsistOf("sha256-1", "sha256-2"))
+	})
+})
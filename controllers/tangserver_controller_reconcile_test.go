@@ -18,14 +18,18 @@ package controllers
 
 import (
 	"context"
+	"io"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -34,8 +38,17 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
+// hangingPodExecutor never completes a command before its context is done
+type hangingPodExecutor struct{}
+
+func (hangingPodExecutor) Exec(ctx context.Context, namespace, podName, containerName string, command []string, stdin io.Reader) (string, string, error) {
+	<-ctx.Done()
+	return "", "", ctx.Err()
+}
+
 var _ = Describe("TangServer controller reconciliation functions", func() {
 
 	Context("When testing reconcileDeployment function", func() {
@@ -98,13 +111,137 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 			}
 
 			// Test should complete without panicking and handle the scenario
-			result, err := reconciler.reconcileDeployment(emptyTangServer)
+			result, err := reconciler.reconcileDeployment(context.Background(), emptyTangServer)
 			// In this case with fake client, no error occurs but we test the path
 			Expect(err).To(BeNil())
 			// Check that a valid result is returned (could be empty or with Requeue set)
 			Expect(result).ToNot(BeNil())
 		})
 
+		It("Should revert replicas changed directly on the deployment", func() {
+			deployment := getDeployment(tangServer)
+			manualReplicas := int32(5)
+			deployment.Spec.Replicas = &manualReplicas
+			deployment.Status.Replicas = 5
+			deployment.Status.ReadyReplicas = 1
+			running := readyPod("test-tang-reconcile-running", time.Now())
+			running.Labels = deployment.Spec.Template.Labels
+			running.Status.Conditions[0].Status = corev1.ConditionFalse
+			ready := readyPod("test-tang-reconcile-ready", time.Now())
+			ready.Labels = deployment.Spec.Template.Labels
+			fakeClient = fake.NewClientBuilder().
+				WithScheme(testScheme).
+				WithObjects(tangServer, deployment, &running, &ready).
+				WithStatusSubresource(tangServer).
+				Build()
+			reconciler.Client = fakeClient
+
+			_, err := reconciler.reconcileDeployment(context.Background(), tangServer)
+			Expect(err).ToNot(HaveOccurred())
+
+			updated := &appsv1.Deployment{}
+			Expect(fakeClient.Get(context.Background(), types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, updated)).To(Succeed())
+			Expect(*updated.Spec.Replicas).To(Equal(int32(2)))
+			// Running and ready replicas come from the pods, not from the deployment
+			Expect(tangServer.Status.Running).To(Equal(int32(2)))
+			Expect(tangServer.Status.Ready).To(Equal(int32(1)))
+			Expect(tangServer.Status.Pods).To(HaveLen(2))
+			Expect(tangServer.Status.Selector).To(Equal("app=test-tang-reconcile"))
+		})
+
+		It("Should set Degraded when key management exceeds its budget", func() {
+			deployment := getDeployment(tangServer)
+			deployment.Status.Replicas = 2
+			deployment.Status.ReadyReplicas = 2
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-tang-reconcile-pod",
+					Namespace: deployment.Namespace,
+					Labels:    deployment.Spec.Template.Labels,
+				},
+			}
+			fakeClient = fake.NewClientBuilder().
+				WithScheme(testScheme).
+				WithObjects(tangServer, deployment, pod).
+				WithStatusSubresource(tangServer).
+				Build()
+			reconciler.Client = fakeClient
+			reconciler.Executor = newFakePodExecutor()
+			reconciler.KeyManagementTimeout = time.Nanosecond
+			tangServer.Status.ActiveKeys = []daemonsv1alpha1.TangServerActiveKeys{{Sha1: "test-sha1", FileName: "test-key.jwk"}}
+
+			_, err := reconciler.reconcileDeployment(context.Background(), tangServer)
+			Expect(err).ToNot(HaveOccurred())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonKeyManagementTimeout))
+			// Keys that could not be read keep their previous status
+			Expect(tangServer.Status.ActiveKeys).To(HaveLen(1))
+		})
+
+		It("Should hold the key lock within its Lease when key management and replica keys time out", func() {
+			deployment := getDeployment(tangServer)
+			deployment.Status.Replicas = 2
+			deployment.Status.ReadyReplicas = 2
+			first := readyPod("test-tang-reconcile-0", time.Now().Add(-time.Minute))
+			first.Labels = deployment.Spec.Template.Labels
+			second := readyPod("test-tang-reconcile-1", time.Now())
+			second.Labels = deployment.Spec.Template.Labels
+			var acquired, released time.Time
+			fakeClient = fake.NewClientBuilder().
+				WithScheme(testScheme).
+				WithObjects(tangServer, deployment, &first, &second).
+				WithStatusSubresource(tangServer).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						if _, ok := obj.(*coordinationv1.Lease); ok {
+							acquired = time.Now()
+						}
+						return c.Create(ctx, obj, opts...)
+					},
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if lease, ok := obj.(*coordinationv1.Lease); ok && leaseHolder(lease) == "" {
+							released = time.Now()
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				}).
+				Build()
+			reconciler.Client = fakeClient
+			reconciler.Executor = hangingPodExecutor{}
+			reconciler.KeyManagementTimeout = 300 * time.Millisecond
+
+			_, err := reconciler.reconcileDeployment(context.Background(), tangServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(acquired).ToNot(BeZero())
+			Expect(released).ToNot(BeZero())
+			held := released.Sub(acquired)
+			Expect(held).To(BeNumerically("<", reconciler.getKeyLockDuration()))
+			// The replica keys are compared within the budget left by the key management, not with a new one
+			Expect(held).To(BeNumerically("<", reconciler.KeyManagementTimeout*3/2))
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonKeyManagementTimeout))
+		})
+
+		It("Should set Degraded and not manage keys with an unsafe key path", func() {
+			executor := newFakePodExecutor()
+			reconciler.Executor = executor
+			tangServer.Spec.KeyPath = "/var/db/tang;rm -rf /"
+			reconciler.manageKeys(context.Background(), KeyObtainInfo{
+				PodName:    "test-pod",
+				Namespace:  tangServer.Namespace,
+				DbPath:     getDefaultKeyPath(tangServer),
+				TangServer: tangServer,
+				Executor:   executor,
+			})
+			Expect(executor.commands).To(BeEmpty())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonInvalidKeyPath))
+		})
+
 		// Note: Removed "Should detect when redeployment is needed" test
 		// This test was failing because it requires real cluster behavior for status updates
 		// that cannot be properly mocked with the fake client
@@ -153,7 +290,7 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 			if !isCluster() {
 				Skip("Avoiding test that requires cluster")
 			}
-			result, err := reconciler.reconcileService(tangServer)
+			result, err := reconciler.reconcileService(context.Background(), tangServer)
 			Expect(err).To(BeNil())
 			// Check that no requeue is requested
 			Expect(result.RequeueAfter).To(Equal(time.Duration(0)))
@@ -177,7 +314,7 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 			err := fakeClient.Create(context.Background(), service)
 			Expect(err).To(BeNil())
 
-			result, err := reconciler.reconcileService(tangServer)
+			result, err := reconciler.reconcileService(context.Background(), tangServer)
 			Expect(err).To(BeNil())
 			// Check that no requeue is requested
 			Expect(result.RequeueAfter).To(Equal(time.Duration(0)))
@@ -210,7 +347,7 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 			}
 
 			// Test should complete without panicking and handle the scenario
-			result, err := reconciler.reconcileService(emptyTangServer)
+			result, err := reconciler.reconcileService(context.Background(), emptyTangServer)
 			// In this case with fake client, no error occurs but we test the path
 			Expect(err).To(BeNil())
 			// Check that no requeue is requested
@@ -256,7 +393,7 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 		It("Should requeue when KeyRefreshInterval is set", func() {
 			tangServer.Spec.KeyRefreshInterval = 300 // 5 minutes
 
-			result, shouldRequeue := reconciler.reconcilePeriodic(tangServer)
+			result, shouldRequeue := reconciler.reconcilePeriodic(context.Background(), tangServer)
 			Expect(shouldRequeue).To(BeTrue())
 			Expect(result.RequeueAfter).To(Equal(300 * time.Second))
 		})
@@ -265,10 +402,14 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 			tangServer.Spec.KeyRefreshInterval = 0
 			tangServer.Status.ActiveKeys = []daemonsv1alpha1.TangServerActiveKeys{}
 
-			result, shouldRequeue := reconciler.reconcilePeriodic(tangServer)
+			result, shouldRequeue := reconciler.reconcilePeriodic(context.Background(), tangServer)
 			Expect(shouldRequeue).To(BeTrue())
 			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.ActiveKeysError))
 			Expect(result.RequeueAfter).To(Equal(time.Duration(DEFAULT_RECONCILE_TIMER_NO_ACTIVE_KEYS) * time.Second))
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysReady)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonNoActiveKeys))
 		})
 
 		It("Should not requeue when conditions are normal", func() {
@@ -282,9 +423,197 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 				},
 			}
 
-			result, shouldRequeue := reconciler.reconcilePeriodic(tangServer)
+			result, shouldRequeue := reconciler.reconcilePeriodic(context.Background(), tangServer)
 			Expect(shouldRequeue).To(BeFalse())
 			Expect(result).To(Equal(ctrl.Result{}))
+			Expect(tangServer.Status.TangServerError).To(Equal(daemonsv1alpha1.NoError))
+			Expect(meta.IsStatusConditionTrue(tangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysReady)).To(BeTrue())
+		})
+	})
+
+	Context("When testing handleHiddenKeys function", func() {
+		It("Should rotate active keys requested to be hidden", func() {
+			const keyPath = "/var/db/tang"
+			testScheme := scheme.Scheme
+			testScheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+				&daemonsv1alpha1.TangServer{},
+				&daemonsv1alpha1.TangServerList{},
+			)
+			tangServer := &daemonsv1alpha1.TangServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tang-hidden", Namespace: "default"},
+				Spec:       daemonsv1alpha1.TangServerSpec{KeyPath: keyPath},
+			}
+			executor := newFakePodExecutor()
+			reconciler := &TangServerReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(testScheme).WithObjects(tangServer).WithStatusSubresource(tangServer).Build(),
+				Scheme:   testScheme,
+				Recorder: events.NewFakeRecorder(100),
+				Executor: executor,
+			}
+			k := KeyObtainInfo{
+				PodName:    "test-pod",
+				Namespace:  "default",
+				DbPath:     keyPath,
+				TangServer: tangServer,
+				Executor:   executor,
+			}
+			executor.addKeyPair(keyPath)
+			inventory, err := readKeyInventory(context.Background(), k)
+			Expect(err).ToNot(HaveOccurred())
+			activeKeys := activeKeysFromInventory(context.Background(), k, inventory, ONLY_ADVERTISED)
+			Expect(activeKeys).To(HaveLen(1))
+			tangServer.Status.ActiveKeys = activeKeys
+			tangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha1: activeKeys[0].Sha1}}
+
+			Expect(reconciler.handleHiddenKeys(context.Background(), k)).To(BeTrue())
+			// The hidden key pair is replaced by a new one before being hidden
+			Expect(executor.entries(keyPath)).To(ContainElement("." + activeKeys[0].FileName))
+			inventory, err = readKeyInventory(context.Background(), k)
+			Expect(err).ToNot(HaveOccurred())
+			newKeys := activeKeysFromInventory(context.Background(), k, inventory, ONLY_ADVERTISED)
+			Expect(newKeys).To(HaveLen(1))
+			Expect(newKeys[0].Sha1).ToNot(Equal(activeKeys[0].Sha1))
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionRotationInProgress)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonKeyRotationSucceeded))
+		})
+
+		It("Should set Degraded when the rotation fails", func() {
+			executor := newFakePodExecutor()
+			executor.failOn = []string{"mv"}
+			tangServer := &daemonsv1alpha1.TangServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-tang-hidden-failure", Namespace: "default"},
+				Spec: daemonsv1alpha1.TangServerSpec{
+					HiddenKeys: []daemonsv1alpha1.TangServerHiddenKeys{{Sha1: "test-sha1"}},
+				},
+				Status: daemonsv1alpha1.TangServerStatus{
+					ActiveKeys: []daemonsv1alpha1.TangServerActiveKeys{{Sha1: "test-sha1", FileName: "test-key.jwk"}},
+				},
+			}
+			reconciler := &TangServerReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tangServer).Build(),
+				Scheme:   scheme.Scheme,
+				Recorder: events.NewFakeRecorder(100),
+				Executor: executor,
+			}
+			k := KeyObtainInfo{PodName: "test-pod", Namespace: "default", DbPath: "/var/db/tang", TangServer: tangServer, Executor: executor}
+
+			Expect(reconciler.handleHiddenKeys(context.Background(), k)).To(BeFalse())
+			cond := meta.FindStatusCondition(tangServer.Status.Conditions, daemonsv1alpha1.ConditionDegraded)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Reason).To(Equal(daemonsv1alpha1.ReasonKeyRotationFailed))
+		})
+	})
+
+	Context("When reconciling several TangServers concurrently", func() {
+		It("Should keep an independent retry counter per TangServer", func() {
+			retries := keyRetries{}
+			first := types.NamespacedName{Namespace: "default", Name: "first"}
+			second := types.NamespacedName{Namespace: "default", Name: "second"}
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					retries.increment(first)
+				}()
+				go func() {
+					defer wg.Done()
+					retries.increment(second)
+				}()
+			}
+			wg.Wait()
+			Expect(retries.get(first)).To(Equal(uint32(50)))
+			Expect(retries.get(second)).To(Equal(uint32(50)))
+			retries.reset(first)
+			Expect(retries.get(first)).To(Equal(uint32(0)))
+			Expect(retries.get(second)).To(Equal(uint32(50)))
+		})
+
+		It("Should not share retries between TangServers without active keys", func() {
+			testScheme := scheme.Scheme
+			testScheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+				&daemonsv1alpha1.TangServer{},
+				&daemonsv1alpha1.TangServerList{},
+			)
+			servers := []*daemonsv1alpha1.TangServer{}
+			builder := fake.NewClientBuilder().WithScheme(testScheme).WithStatusSubresource(&daemonsv1alpha1.TangServer{})
+			for _, name := range []string{"test-tang-concurrent-1", "test-tang-concurrent-2"} {
+				server := &daemonsv1alpha1.TangServer{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				}
+				servers = append(servers, server)
+				builder = builder.WithObjects(server)
+			}
+			reconciler := &TangServerReconciler{
+				Client:   builder.Build(),
+				Scheme:   testScheme,
+				Recorder: events.NewFakeRecorder(100),
+			}
+			var wg sync.WaitGroup
+			for i, server := range servers {
+				for j := 0; j <= i; j++ {
+					wg.Add(1)
+					go func(cr *daemonsv1alpha1.TangServer) {
+						defer wg.Done()
+						reconciler.reconcilePeriodic(context.Background(), cr.DeepCopy())
+					}(server)
+				}
+			}
+			wg.Wait()
+			Expect(reconciler.activeKeyRetries.get(client.ObjectKeyFromObject(servers[0]))).To(Equal(uint32(1)))
+			Expect(reconciler.activeKeyRetries.get(client.ObjectKeyFromObject(servers[1]))).To(Equal(uint32(2)))
+		})
+	})
+
+	Context("When the TangServer can not be read", func() {
+		var testScheme *runtime.Scheme
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test-tang-missing"}}
+
+		BeforeEach(func() {
+			testScheme = scheme.Scheme
+			testScheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+				&daemonsv1alpha1.TangServer{},
+				&daemonsv1alpha1.TangServerList{},
+			)
+		})
+
+		It("Should forget a deleted TangServer without reconciling it", func() {
+			reconciler := &TangServerReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(testScheme).Build(),
+				Scheme:   testScheme,
+				Recorder: events.NewFakeRecorder(100),
+			}
+			reconciler.activeKeyRetries.increment(req.NamespacedName)
+			result, err := reconciler.Reconcile(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{}))
+			Expect(reconciler.activeKeyRetries.get(req.NamespacedName)).To(Equal(uint32(0)))
+			deployments := &appsv1.DeploymentList{}
+			Expect(reconciler.List(context.Background(), deployments)).To(Succeed())
+			Expect(deployments.Items).To(BeEmpty())
+		})
+
+		It("Should return the error without reconciling when the TangServer can not be read", func() {
+			unavailable := errors.NewServiceUnavailable("test unavailable")
+			reconciler := &TangServerReconciler{
+				Client: fake.NewClientBuilder().WithScheme(testScheme).WithInterceptorFuncs(interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						if _, ok := obj.(*daemonsv1alpha1.TangServer); ok {
+							return unavailable
+						}
+						return c.Get(ctx, key, obj, opts...)
+					},
+				}).Build(),
+				Scheme:   testScheme,
+				Recorder: events.NewFakeRecorder(100),
+			}
+			_, err := reconciler.Reconcile(context.Background(), req)
+			Expect(err).To(Equal(unavailable))
+			deployments := &appsv1.DeploymentList{}
+			Expect(reconciler.List(context.Background(), deployments)).To(Succeed())
+			Expect(deployments.Items).To(BeEmpty())
 		})
 	})
 
@@ -311,7 +640,7 @@ var _ = Describe("TangServer controller reconciliation functions", func() {
 
 			// Test that the function exists and can be called
 			Expect(func() {
-				_ = reconciler.finalizeTangServer(tangServer)
+				_ = reconciler.finalizeTangServer(context.Background(), tangServer)
 			}).ToNot(Panic())
 		})
 	})
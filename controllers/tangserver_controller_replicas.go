@@ -0,0 +1,266 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Extension of the key files compared across replicas, other files of the key directory are operator metadata
+const KEY_FILE_EXTENSION = ".jwk"
+
+// isPodReady returns true if the pod is running and ready to serve
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// readyPods returns the ready pods provided, from the oldest to the newest, so that the authoritative replica,
+// the first one, only changes when it is no longer ready
+func readyPods(pods []corev1.Pod) []corev1.Pod {
+	ready := make([]corev1.Pod, 0, len(pods))
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			ready = append(ready, pods[i])
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		ti, tj := ready[i].CreationTimestamp, ready[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return ready[i].Name < ready[j].Name
+	})
+	return ready
+}
+
+// readReplicaKeyFiles returns the key files of the key directory of a replica, by name, without reading them
+func readReplicaKeyFiles(ctx context.Context, k KeyObtainInfo) (map[string]KeyFileInfo, error) {
+	stdo, _, err := k.podExec(ctx, nil, inventoryCommand(k.DbPath)...)
+	if err != nil {
+		return nil, err
+	}
+	files, err := parseInventory(stdo)
+	if err != nil {
+		return nil, err
+	}
+	keyFiles := make(map[string]KeyFileInfo, len(files))
+	for _, f := range files {
+		if isForbiddenPath(f.Name) || !isValidKeyFileName(f.Name) || !strings.HasSuffix(f.Name, KEY_FILE_EXTENSION) {
+			continue
+		}
+		keyFiles[f.Name] = f
+	}
+	return keyFiles, nil
+}
+
+// replicaKeyThumbprint returns the sha256 thumbprint of a key file of a replica. Files with the content of an
+// authoritative key file share its thumbprint, so that only unexpected files are read
+func replicaKeyThumbprint(ctx context.Context, k KeyObtainInfo, f KeyFileInfo, authoritative map[string]KeyMetadata) string {
+	if m, found := authoritative[f.Name]; found && m.File.Hash == f.Hash {
+		return m.Sha256
+	}
+	content, err := readKeyFile(ctx, k, k.DbPath+"/"+f.Name)
+	if err != nil {
+		return ""
+	}
+	jwk, err := parseJWK(content)
+	if err != nil {
+		return ""
+	}
+	return getSHA(ctx, SHA256, jwk, k.DbPath+"/"+f.Name)
+}
+
+// compareReplicaKeys returns the keys of a replica, compared with the key files of the authoritative replica
+func compareReplicaKeys(ctx context.Context, k KeyObtainInfo, authoritative map[string]KeyMetadata) daemonsv1alpha1.TangServerReplicaKeys {
+	replica := daemonsv1alpha1.TangServerReplicaKeys{PodName: k.PodName}
+	files, err := readReplicaKeyFiles(ctx, k)
+	if err != nil {
+		replica.Error = err.Error()
+		return replica
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := files[name]
+		if m, found := authoritative[name]; !found || m.File.Hash != f.Hash {
+			replica.UnexpectedKeyFiles = append(replica.UnexpectedKeyFiles, name)
+		}
+		sha256 := replicaKeyThumbprint(ctx, k, f, authoritative)
+		if sha256 == "" {
+			continue
+		}
+		if isHiddenKeyFile(name) {
+			replica.HiddenKeys = append(replica.HiddenKeys, sha256)
+		} else {
+			replica.ActiveKeys = append(replica.ActiveKeys, sha256)
+		}
+	}
+	for name := range authoritative {
+		if _, found := files[name]; !found {
+			replica.MissingKeyFiles = append(replica.MissingKeyFiles, name)
+		}
+	}
+	sort.Strings(replica.MissingKeyFiles)
+	return replica
+}
+
+// authoritativeReplicaKeys returns the keys of the authoritative replica, from its key inventory
+func authoritativeReplicaKeys(k KeyObtainInfo, inventory []KeyMetadata) (daemonsv1alpha1.TangServerReplicaKeys, map[string]KeyMetadata) {
+	replica := daemonsv1alpha1.TangServerReplicaKeys{PodName: k.PodName, Authoritative: true}
+	keyFiles := make(map[string]KeyMetadata, len(inventory))
+	for _, m := range inventory {
+		if !strings.HasSuffix(m.File.Name, KEY_FILE_EXTENSION) {
+			continue
+		}
+		keyFiles[m.File.Name] = m
+		if m.Sha256 == "" {
+			continue
+		}
+		if isHiddenKeyFile(m.File.Name) {
+			replica.HiddenKeys = append(replica.HiddenKeys, m.Sha256)
+		} else {
+			replica.ActiveKeys = append(replica.ActiveKeys, m.Sha256)
+		}
+	}
+	return replica, keyFiles
+}
+
+// copyKeyFile writes a key file of the authoritative replica to a replica, with its modification time, which
+// pairs the key files and reports when they were generated. It is written to a temporary file renamed without
+// overwriting, so that a key file created meanwhile in the replica is kept
+func copyKeyFile(ctx context.Context, to KeyObtainInfo, m KeyMetadata, content []byte) error {
+	filePath := to.DbPath + "/" + m.File.Name
+	tmpPath := filePath + TEMPORARY_FILE_SUFFIX
+	if err := writePodFile(ctx, to, tmpPath, content); err != nil {
+		return err
+	}
+	// The temporary file is left if the key file exists, or if a command fails
+	defer func() {
+		if _, _, err := to.podExec(ctx, nil, "rm", "-f", tmpPath); err != nil {
+			log.FromContext(ctx).Error(err, "Unable to remove temporary file", "file", tmpPath)
+		}
+	}()
+	modTime := fmt.Sprintf("@%d.%09d", m.File.ModTime.Unix(), m.File.ModTime.Nanosecond())
+	if _, stde, err := to.podExec(ctx, nil, "touch", "-m", "-d", modTime, tmpPath); err != nil {
+		return fmt.Errorf("unable to set modification time of %s: %w, %s", tmpPath, err, stde)
+	}
+	if _, stde, err := to.podExec(ctx, nil, "mv", "-n", tmpPath, filePath); err != nil {
+		return fmt.Errorf("unable to rename %s: %w, %s", tmpPath, err, stde)
+	}
+	return nil
+}
+
+// copyMissingKeyFiles copies the key files missing in a replica from the authoritative replica, whose key files
+// are provided by name. Existing files of the replica are never overwritten
+func copyMissingKeyFiles(ctx context.Context, from KeyObtainInfo, to KeyObtainInfo, keyFiles map[string]KeyMetadata, names []string) error {
+	for _, name := range names {
+		m, found := keyFiles[name]
+		if !found || !isValidKeyFileName(name) {
+			return fmt.Errorf("refusing to copy %q, not a key file in %s", name, from.DbPath)
+		}
+		content, err := readKeyFile(ctx, from, from.DbPath+"/"+name)
+		if err != nil {
+			return err
+		}
+		if err := copyKeyFile(ctx, to, m, content); err != nil {
+			return fmt.Errorf("unable to copy key file %s to pod %s: %w", name, to.PodName, err)
+		}
+	}
+	return nil
+}
+
+// isReplicaDiverged returns true if the key files of the replica differ from the authoritative replica
+func isReplicaDiverged(replica daemonsv1alpha1.TangServerReplicaKeys) bool {
+	return len(replica.MissingKeyFiles) > 0 || len(replica.UnexpectedKeyFiles) > 0
+}
+
+// reconcileReplicaKeys reports the keys of every ready replica in status, compared with the authoritative
+// replica the keys are managed on, and flags diverged replicas through the KeysDiverged condition. If
+// requested in spec, key files missing in a replica are copied from the authoritative replica
+func (r *TangServerReconciler) reconcileReplicaKeys(ctx context.Context, k KeyObtainInfo, replicas []corev1.Pod) {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		l.Error(err, "Unable to read keys, replica keys not compared", "podname", k.PodName, "namespace", k.Namespace)
+		return
+	}
+	authoritative, keyFiles := authoritativeReplicaKeys(k, inventory)
+	status := []daemonsv1alpha1.TangServerReplicaKeys{authoritative}
+	var diverged, unknown []string
+	for _, pod := range replicas {
+		if pod.Name == k.PodName {
+			continue
+		}
+		rk := k
+		rk.PodName = pod.Name
+		rk.inventory = nil
+		replica := compareReplicaKeys(ctx, rk, keyFiles)
+		if cr.Spec.RepairDivergedReplicas && replica.Error == "" && len(replica.MissingKeyFiles) > 0 {
+			l.Info("Copying missing key files to replica", "Replica", pod.Name, "Key Files", replica.MissingKeyFiles)
+			if err := copyMissingKeyFiles(ctx, k, rk, keyFiles, replica.MissingKeyFiles); err != nil {
+				l.Error(err, "Unable to copy missing key files to replica", "Replica", pod.Name)
+				r.Recorder.Eventf(cr, nil, "Warning", "ReplicaKeysRepair", "ReplicaKeysRepair",
+					"Missing key files NOT copied to replica %s from %s", pod.Name, k.PodName)
+			} else {
+				r.Recorder.Eventf(cr, nil, "Normal", "ReplicaKeysRepair", "ReplicaKeysRepair",
+					"Copied %d missing key files to replica %s from %s: %s", len(replica.MissingKeyFiles), pod.Name, k.PodName,
+					strings.Join(replica.MissingKeyFiles, ", "))
+			}
+			replica = compareReplicaKeys(ctx, rk, keyFiles)
+		}
+		if replica.Error != "" {
+			unknown = append(unknown, pod.Name)
+		} else if isReplicaDiverged(replica) {
+			diverged = append(diverged, pod.Name)
+		}
+		status = append(status, replica)
+	}
+	cr.Status.ReplicaKeys = status
+	switch {
+	case len(diverged) > 0:
+		message := fmt.Sprintf("Replicas with key files differing from %s: %s", k.PodName, strings.Join(diverged, ", "))
+		if setCondition(cr, daemonsv1alpha1.ConditionKeysDiverged, metav1.ConditionTrue, daemonsv1alpha1.ReasonReplicaKeysDiverged, message) {
+			r.Recorder.Eventf(cr, nil, "Warning", "ReplicaKeysDiverged", "ReplicaKeysDiverged", "%s", message)
+		}
+	case len(unknown) > 0:
+		setCondition(cr, daemonsv1alpha1.ConditionKeysDiverged, metav1.ConditionUnknown, daemonsv1alpha1.ReasonReplicaKeysUnknown,
+			"Unable to read the keys of replicas: "+strings.Join(unknown, ", "))
+	default:
+		setCondition(cr, daemonsv1alpha1.ConditionKeysDiverged, metav1.ConditionFalse, daemonsv1alpha1.ReasonReplicaKeysConsistent,
+			fmt.Sprintf("%d ready replicas with the key files of %s", len(status), k.PodName))
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+// fakeReplicaExecutor executes commands in the fake executor of each pod, as replicas on separate volumes
+type fakeReplicaExecutor map[string]*fakePodExecutor
+
+func (f fakeReplicaExecutor) Exec(ctx context.Context, namespace, podName, containerName string, command []string, stdin io.Reader) (string, string, error) {
+	executor, found := f[podName]
+	if !found {
+		return "", "", fmt.Errorf("pod %s not found", podName)
+	}
+	return executor.Exec(ctx, namespace, podName, containerName, command, stdin)
+}
+
+// readyPod returns a ready tang pod created at the time provided
+func readyPod(name string, created time.Time) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+var _ = Describe("TangServer controller replica keys", func() {
+	const keyPath = "/var/db/tang"
+	var (
+		primary, secondary *fakePodExecutor
+		keyInfo            KeyObtainInfo
+		reconciler         *TangServerReconciler
+		recorder           *events.FakeRecorder
+		pods               []corev1.Pod
+		signing, exchange  string
+	)
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		primary, secondary = newFakePodExecutor(), newFakePodExecutor()
+		executor := fakeReplicaExecutor{"tang-0": primary, "tang-1": secondary}
+		keyInfo, reconciler, recorder = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{}, executor)
+		keyInfo.PodName = "tang-0"
+		signing, exchange = primary.addKeyPair(keyPath)
+		for _, name := range []string{signing, exchange} {
+			content, _ := primary.fileContent(keyPath + "/" + name)
+			secondary.addFile(keyPath+"/"+name, content)
+		}
+		created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		pods = []corev1.Pod{readyPod("tang-1", created.Add(time.Minute)), readyPod("tang-0", created)}
+	})
+
+	It("Should manage keys on the oldest ready replica", func() {
+		notReady := readyPod("tang-2", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+		notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+		replicas := readyPods(append(pods, notReady))
+		Expect(replicas).To(HaveLen(2))
+		Expect(replicas[0].Name).To(Equal("tang-0"))
+	})
+
+	It("Should report the keys of every ready replica", func() {
+		reconciler.reconcileReplicaKeys(ctx, keyInfo, readyPods(pods))
+		replicas := keyInfo.TangServer.Status.ReplicaKeys
+		Expect(replicas).To(HaveLen(2))
+		Expect(replicas[0].PodName).To(Equal("tang-0"))
+		Expect(replicas[0].Authoritative).To(BeTrue())
+		Expect(replicas[0].ActiveKeys).To(ContainElement(strings.TrimSuffix(signing, ".jwk")))
+		Expect(replicas[1].ActiveKeys).To(ConsistOf(replicas[0].ActiveKeys))
+		condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysDiverged)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("Should flag replicas with diverged key files", func() {
+		newSigning, newExchange := primary.addKeyPair(keyPath)
+		secondary.addKeyPair(keyPath)
+		reconciler.reconcileReplicaKeys(ctx, keyInfo, readyPods(pods))
+		replica := keyInfo.TangServer.Status.ReplicaKeys[1]
+		Expect(replica.MissingKeyFiles).To(ConsistOf(newSigning, newExchange))
+		Expect(replica.UnexpectedKeyFiles).To(HaveLen(2))
+		Expect(replica.ActiveKeys).To(HaveLen(4))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionKeysDiverged)).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("tang-1")))
+		// Not repaired unless requested
+		Expect(secondary.entries(keyPath)).ToNot(ContainElement(newSigning))
+	})
+
+	It("Should copy missing key files from the authoritative replica when requested", func() {
+		keyInfo.TangServer.Spec.RepairDivergedReplicas = true
+		newSigning, newExchange := primary.addKeyPair(keyPath)
+		reconciler.reconcileReplicaKeys(ctx, keyInfo, readyPods(pods))
+		Expect(secondary.entries(keyPath)).To(ContainElements(newSigning, newExchange))
+		expected, _ := primary.fileContent(keyPath + "/" + newSigning)
+		copied, _ := secondary.fileContent(keyPath + "/" + newSigning)
+		Expect(copied).To(Equal(expected))
+		// The generation time is kept, so the copied key files are paired and reported as in the original replica
+		primary.mu.Lock()
+		secondary.mu.Lock()
+		for _, name := range []string{newSigning, newExchange} {
+			Expect(secondary.files[keyPath+"/"+name].modTime).To(Equal(primary.files[keyPath+"/"+name].modTime))
+		}
+		secondary.mu.Unlock()
+		primary.mu.Unlock()
+		Expect(secondary.entries(keyPath)).ToNot(ContainElement(HaveSuffix(TEMPORARY_FILE_SUFFIX)))
+		Expect(recorder.Events).To(Receive(ContainSubstring("Copied 2 missing key files to replica tang-1")))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionKeysDiverged)).To(BeFalse())
+		Expect(keyInfo.TangServer.Status.ReplicaKeys[1].MissingKeyFiles).To(BeEmpty())
+	})
+
+	It("Should never overwrite key files created in the replica during the copy", func() {
+		newSigning, _ := primary.addKeyPair(keyPath)
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		_, keyFiles := authoritativeReplicaKeys(keyInfo, inventory)
+		secondary.addFile(keyPath+"/"+newSigning, []byte("created meanwhile"))
+		replica := keyInfo
+		replica.PodName = "tang-1"
+		Expect(copyMissingKeyFiles(ctx, keyInfo, replica, keyFiles, []string{newSigning})).To(Succeed())
+		content, _ := secondary.fileContent(keyPath + "/" + newSigning)
+		Expect(string(content)).To(Equal("created meanwhile"))
+		Expect(secondary.entries(keyPath)).ToNot(ContainElement(HaveSuffix(TEMPORARY_FILE_SUFFIX)))
+	})
+
+	It("Should report replicas whose keys can not be read", func() {
+		secondary.failOn = []string{"find"}
+		reconciler.reconcileReplicaKeys(ctx, keyInfo, readyPods(pods))
+		Expect(keyInfo.TangServer.Status.ReplicaKeys[1].Error).ToNot(BeEmpty())
+		condition := meta.FindStatusCondition(keyInfo.TangServer.Status.Conditions, daemonsv1alpha1.ConditionKeysDiverged)
+		Expect(condition.Status).To(Equal(metav1.ConditionUnknown))
+	})
+})
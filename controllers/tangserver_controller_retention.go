@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// isHeldKeyPair returns true if the key pair is held in spec, so that the hidden key retention never purges it
+func isHeldKeyPair(cr *daemonsv1alpha1.TangServer, p KeyPair) bool {
+	for _, hk := range cr.Spec.HiddenKeys {
+		if hk.Hold && hiddenKeyMatches(hk, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenTime returns the time the key pair was hidden, as recorded in the key status file. Key files are renamed
+// to hide them, which updates their change time, used for the key pairs not recorded yet. The recorded time is
+// preferred, as the change time is also updated by later changes of the files, such as restoring a backup
+func hiddenTime(p KeyPair, recorded KeyAssociationSha256Map) time.Time {
+	if assoc, found := recorded[p.Signing.Sha256]; found && assoc.Hidden != nil {
+		return *assoc.Hidden
+	}
+	return p.Signing.File.ChangeTime
+}
+
+// hiddenKeyPurgeTimes returns the time each hidden key pair subject to the hidden key retention is purged, by
+// signing key sha256 thumbprint. Held key pairs and the most recently hidden ones, up to the minimum amount of
+// hidden key pairs, are never purged. Held key pairs count as kept
+func hiddenKeyPurgeTimes(cr *daemonsv1alpha1.TangServer, pairs []KeyPair, recorded KeyAssociationSha256Map) map[string]time.Time {
+	purges := make(map[string]time.Time)
+	if cr.Spec.HiddenKeyRetention == nil || cr.Spec.HiddenKeyRetention.Duration <= 0 {
+		return purges
+	}
+	hidden := make([]KeyPair, 0, len(pairs))
+	for _, p := range pairs {
+		if p.Hidden() {
+			hidden = append(hidden, p)
+		}
+	}
+	sort.SliceStable(hidden, func(i, j int) bool {
+		return hiddenTime(hidden[i], recorded).After(hiddenTime(hidden[j], recorded))
+	})
+	kept := uint32(0)
+	for _, p := range hidden {
+		if isHeldKeyPair(cr, p) || kept < cr.Spec.MinHiddenKeyPairs {
+			kept++
+			continue
+		}
+		purges[p.Signing.Sha256] = hiddenTime(p, recorded).Add(cr.Spec.HiddenKeyRetention.Duration)
+	}
+	return purges
+}
+
+// recordedKeyPairs returns the key pairs recorded in the key status file, by signing key sha256 thumbprint. None
+// are returned if it can not be read, so that the key files are relied on
+func recordedKeyPairs(ctx context.Context, k KeyObtainInfo) KeyAssociationSha256Map {
+	recorded, err := readKeyStatus(ctx, k)
+	if err != nil {
+		log.FromContext(ctx).Info("Key status file not read, using the change time of hidden key files", "Error", err.Error())
+		return nil
+	}
+	return recorded.KeyStatusSha256Map
+}
+
+// setPurgeTimes reports in the key pairs status the time each hidden key pair is purged
+func setPurgeTimes(status []daemonsv1alpha1.TangServerKeyPair, purges map[string]time.Time) {
+	for i := range status {
+		if purge, found := purges[status[i].Signing.Sha256]; found {
+			status[i].Purge = &metav1.Time{Time: purge}
+		}
+	}
+}
+
+// purgeHiddenKeys deletes the hidden key pairs whose retention expired, and removes them from the key status file
+func (r *TangServerReconciler) purgeHiddenKeys(ctx context.Context, k KeyObtainInfo) {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	if cr.Spec.HiddenKeyRetention == nil {
+		return
+	}
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		l.Error(err, "Unable to read keys, hidden keys not purged", "podname", k.PodName, "namespace", k.Namespace)
+		return
+	}
+	pairs := pairKeys(inventory).Pairs
+	recorded := recordedKeyPairs(ctx, k)
+	purges := hiddenKeyPurgeTimes(cr, pairs, recorded)
+	now := r.now()
+	for _, p := range pairs {
+		purge, found := purges[p.Signing.Sha256]
+		if !found || now.Before(purge) {
+			continue
+		}
+		keyFileName := p.Signing.File.Name
+		l.Info("Purging hidden key pair", "Key File", keyFileName, "Hidden", hiddenTime(p, recorded),
+			"Retention", cr.Spec.HiddenKeyRetention.Duration)
+		if err := deleteHiddenKeyPair(ctx, k, p); err != nil {
+			l.Error(err, "Unable to purge hidden key pair", "Key File", keyFileName)
+			r.Recorder.Eventf(cr, nil, "Warning", "HiddenKeyPurge", "HiddenKeyPurge", "Hidden key pair NOT purged, Key File: %s", keyFileName)
+			setDegraded(cr, daemonsv1alpha1.ReasonHiddenKeysDeletionFailed, fmt.Sprintf("Unable to purge hidden key file %s", keyFileName))
+			continue
+		}
+		r.Recorder.Eventf(cr, nil, "Normal", "HiddenKeyPurge", "HiddenKeyPurge",
+			"Hidden key pair purged after retention of %s, Key File: %s, Exchange Key File: %s, Hidden: %s",
+			cr.Spec.HiddenKeyRetention.Duration, keyFileName, p.Exchange.File.Name, hiddenTime(p, recorded).UTC().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller hidden key retention", func() {
+	const keyPath = "/var/db/tang"
+	const retention = 90 * 24 * time.Hour
+	var (
+		executor   *fakePodExecutor
+		keyInfo    KeyObtainInfo
+		reconciler *TangServerReconciler
+		recorder   *events.FakeRecorder
+		// hidden contains the signing key files of the hidden key pairs, from the oldest to the newest hidden
+		hidden []string
+		// hiddenAt contains the time each key pair was hidden
+		hiddenAt []time.Time
+		now      time.Time
+	)
+	ctx := context.Background()
+
+	// keyPairs returns the key pairs of the key directory
+	keyPairs := func() []KeyPair {
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		return pairKeys(inventory).Pairs
+	}
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		keyInfo, reconciler, recorder = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{
+			HiddenKeyRetention: &metav1.Duration{Duration: retention},
+		}, executor)
+		reconciler.Now = func() time.Time { return now }
+		executor.addKeyPair(keyPath)
+		hidden, hiddenAt = nil, nil
+		for i := 0; i < 3; i++ {
+			signing, exchange := executor.addKeyPair(keyPath)
+			executor.now = executor.now.Add(24 * time.Hour)
+			Expect(moveKeyFile(ctx, keyInfo, signing, "."+signing)).To(Succeed())
+			Expect(moveKeyFile(ctx, keyInfo, exchange, "."+exchange)).To(Succeed())
+			hidden = append(hidden, "."+signing)
+			hiddenAt = append(hiddenAt, executor.now)
+		}
+	})
+
+	It("Should schedule the purge of each hidden key pair", func() {
+		purges := hiddenKeyPurgeTimes(keyInfo.TangServer, keyPairs(), nil)
+		Expect(purges).To(HaveLen(3))
+		for _, p := range keyPairs() {
+			if p.Hidden() {
+				Expect(purges[p.Signing.Sha256]).To(Equal(p.Signing.File.ChangeTime.Add(retention)))
+			}
+		}
+		keyInfo.TangServer.Spec.HiddenKeyRetention = nil
+		Expect(hiddenKeyPurgeTimes(keyInfo.TangServer, keyPairs(), nil)).To(BeEmpty())
+	})
+
+	It("Should report the purge time of the hidden key pairs in status", func() {
+		reconciler.updateKeyPairs(ctx, keyInfo, func() []KeyMetadata {
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			return inventory
+		}())
+		for _, p := range keyInfo.TangServer.Status.KeyPairs {
+			if p.State == daemonsv1alpha1.KeyPairStateHidden {
+				Expect(p.Purge).ToNot(BeNil())
+				Expect(p.Purge.Time).To(Equal(p.Hidden.Add(retention)))
+			} else {
+				Expect(p.Purge).To(BeNil())
+			}
+		}
+		now = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		Expect(untilScheduledKeyChange(keyInfo.TangServer, now)).To(BeNumerically(">", retention))
+	})
+
+	It("Should purge hidden key pairs once their retention expires", func() {
+		now = hiddenAt[0].Add(retention)
+		reconciler.purgeHiddenKeys(ctx, keyInfo)
+		entries := executor.entries(keyPath)
+		Expect(entries).ToNot(ContainElement(hidden[0]))
+		Expect(entries).To(ContainElements(hidden[1], hidden[2]))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("HiddenKeyPurge"), ContainSubstring(hidden[0]))))
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("Should purge hidden key pairs from the hiding time recorded in the key status file", func() {
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writeKeyPairs(ctx, keyInfo, pairKeys(inventory))).To(Succeed())
+		// Restoring a backup of the key directory updates the change time of every key file
+		executor.mu.Lock()
+		for _, f := range executor.files {
+			f.changeTime = hiddenAt[2].Add(retention)
+		}
+		executor.mu.Unlock()
+		now = hiddenAt[0].Add(retention)
+		reconciler.purgeHiddenKeys(ctx, keyInfo)
+		entries := executor.entries(keyPath)
+		Expect(entries).ToNot(ContainElement(hidden[0]))
+		Expect(entries).To(ContainElements(hidden[1], hidden[2]))
+	})
+
+	It("Should keep the minimum amount of most recently hidden key pairs", func() {
+		keyInfo.TangServer.Spec.MinHiddenKeyPairs = 2
+		now = hiddenAt[2].Add(2 * retention)
+		reconciler.purgeHiddenKeys(ctx, keyInfo)
+		entries := executor.entries(keyPath)
+		Expect(entries).ToNot(ContainElement(hidden[0]))
+		Expect(entries).To(ContainElements(hidden[1], hidden[2]))
+	})
+
+	It("Should never purge held key pairs", func() {
+		keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{
+			{Sha256: strings.TrimSuffix(strings.TrimPrefix(hidden[0], "."), ".jwk"), Hold: true},
+		}
+		now = hiddenAt[2].Add(2 * retention)
+		reconciler.purgeHiddenKeys(ctx, keyInfo)
+		entries := executor.entries(keyPath)
+		Expect(entries).To(ContainElement(hidden[0]))
+		Expect(entries).ToNot(ContainElement(BeElementOf(hidden[1], hidden[2])))
+		// Active key pairs are never purged
+		Expect(keyPairs()).To(HaveLen(2))
+	})
+
+	It("Should report hidden key pairs that can not be purged", func() {
+		executor.failOn = []string{"rm "}
+		now = hiddenAt[0].Add(retention)
+		reconciler.purgeHiddenKeys(ctx, keyInfo)
+		Expect(executor.entries(keyPath)).To(ContainElement(hidden[0]))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("NOT purged")))
+	})
+})
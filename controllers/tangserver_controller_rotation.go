@@ -0,0 +1,198 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// advertisementCommand returns the command fetching the advertisement served by the tang pod
+func advertisementCommand(keyInfo KeyObtainInfo) []string {
+	return []string{"curl", "-sf", fmt.Sprintf("http://localhost:%d/adv", getPodListenPort(keyInfo.TangServer))}
+}
+
+// advertisedThumbprints returns the sha256 thumbprints of the keys of a tang advertisement, a JWS whose
+// payload is the JWK set advertised
+func advertisedThumbprints(adv []byte) (map[string]bool, error) {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.Unmarshal(adv, &jws); err != nil {
+		return nil, fmt.Errorf("unable to parse advertisement: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jws.Payload, "="))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode advertisement payload: %v", err)
+	}
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(payload, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to parse advertised keys: %v", err)
+	}
+	thumbprints := make(map[string]bool, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		thp, err := k.Thumbprint(SHA256)
+		if err != nil {
+			return nil, err
+		}
+		thumbprints[thp] = true
+	}
+	return thumbprints, nil
+}
+
+// verifyAdvertised checks that the tang pod advertises both keys of the key pair
+func verifyAdvertised(ctx context.Context, keyInfo KeyObtainInfo, pair KeyPair) error {
+	stdo, stde, err := keyInfo.podExec(ctx, nil, advertisementCommand(keyInfo)...)
+	if err != nil {
+		return fmt.Errorf("unable to fetch advertisement: %w, %s", err, stde)
+	}
+	advertised, err := advertisedThumbprints([]byte(stdo))
+	if err != nil {
+		return err
+	}
+	for _, k := range []KeyMetadata{pair.Signing, pair.Exchange} {
+		if !advertised[k.Sha256] {
+			return fmt.Errorf("key file %s not advertised", k.File.Name)
+		}
+	}
+	return nil
+}
+
+// removeKeyFiles removes the key files provided from the key directory
+func removeKeyFiles(ctx context.Context, keyInfo KeyObtainInfo, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	command := []string{"rm", "-f"}
+	for _, name := range names {
+		if !isValidKeyFileName(name) {
+			return fmt.Errorf("refusing to delete %q, not a key file in %s", name, keyInfo.DbPath)
+		}
+		command = append(command, keyInfo.DbPath+"/"+name)
+	}
+	_, _, err := keyInfo.podExec(ctx, nil, command...)
+	return err
+}
+
+// generateKeyPair creates a key pair and verifies that it can be read, paired and is advertised.
+// The key files created are removed if the key pair can not be verified
+func generateKeyPair(ctx context.Context, keyInfo KeyObtainInfo, before []KeyMetadata) (KeyPair, error) {
+	l := log.FromContext(ctx)
+	existing := make(map[string]bool, len(before))
+	for _, k := range before {
+		existing[k.File.Name] = true
+	}
+	err := createNewPairOfKeys(ctx, keyInfo)
+	var pair KeyPair
+	var created []string
+	if inventory, e := readKeyInventory(ctx, keyInfo); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		for _, k := range inventory {
+			if !existing[k.File.Name] {
+				created = append(created, k.File.Name)
+			}
+		}
+		found := false
+		for _, p := range pairKeys(inventory).Pairs {
+			if !existing[p.Signing.File.Name] && !existing[p.Exchange.File.Name] && !p.Hidden() {
+				pair, found = p, true
+				break
+			}
+		}
+		if err == nil && !found {
+			err = fmt.Errorf("no new key pair found after key generation, new key files: %v", created)
+		}
+	}
+	if err == nil {
+		err = verifyAdvertised(ctx, keyInfo, pair)
+	}
+	if err != nil {
+		l.Error(err, "Unable to generate key pair, removing new key files", "Key Files", created)
+		if e := removeKeyFiles(ctx, keyInfo, created); e != nil {
+			l.Error(e, "Unable to remove new key files", "Key Files", created)
+		}
+		return KeyPair{}, err
+	}
+	return pair, nil
+}
+
+// recordHiding records in the key status file the agent that hid the key pair of the journal provided and
+// the reason. Key pairs recorded before are not changed, as the first hiding recorded is kept
+func recordHiding(ctx context.Context, keyInfo KeyObtainInfo, j RotationJournal, reason string) error {
+	if j.Sha1 == "" {
+		// Keys that can not be paired are not recorded in the key status file
+		return nil
+	}
+	inventory, err := readKeyInventory(ctx, keyInfo)
+	if err != nil {
+		return err
+	}
+	for _, p := range pairKeys(inventory).Pairs {
+		if p.Signing.Sha1 == j.Sha1 && p.Hidden() {
+			assoc := p.association(keyInfo.DbPath)
+			created, hidden := p.Signing.File.ModTime, p.Signing.File.ChangeTime
+			assoc.Created, assoc.Hidden = &created, &hidden
+			assoc.RotatedBy, assoc.Reason = j.RotatedBy, reason
+			return dumpKeyAssociations(ctx, keyInfo, []KeyAssociation{assoc}, nil, nil)
+		}
+	}
+	return nil
+}
+
+// hideKeyPair hides the key pair of the signing key file provided without generating a replacement, and
+// records who hid it and why. The key files to keep are never hidden with it
+func hideKeyPair(ctx context.Context, keyInfo KeyObtainInfo, keyFileName string, keep []string, rotatedBy string, reason string) error {
+	inventory, err := readKeyInventory(ctx, keyInfo)
+	if err != nil {
+		return err
+	}
+	j := newRotationJournal(ctx, keyInfo, keyFileName, inventory, keep)
+	j.RotatedBy = rotatedBy
+	if err := executeRotation(ctx, keyInfo, j); err != nil {
+		return err
+	}
+	if err := recordHiding(ctx, keyInfo, j, reason); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to record hidden key pair", "Key File", keyFileName)
+	}
+	return nil
+}
+
+// rotateKeyPair replaces the key pair of the signing key file provided: a new key pair is generated and
+// verified first, and the old one is only hidden afterwards, so that the tang server always has active
+// keys. An operator restart after the generation leaves an additional active key pair, never a missing one
+func rotateKeyPair(ctx context.Context, keyInfo KeyObtainInfo, keyFileName string, rotatedBy string, reason string) (KeyPair, error) {
+	inventory, err := readKeyInventory(ctx, keyInfo)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	pair, err := generateKeyPair(ctx, keyInfo, inventory)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("unable to generate replacement key pair: %w", err)
+	}
+	keep := []string{pair.Signing.File.Name, pair.Exchange.File.Name}
+	return pair, hideKeyPair(ctx, keyInfo, keyFileName, keep, rotatedBy, reason)
+}
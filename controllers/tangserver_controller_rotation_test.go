@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+)
+
+var _ = Describe("TangServer controller key pair rotation", func() {
+	const keyPath = "/var/db/tang"
+	var (
+		executor          *fakePodExecutor
+		keyInfo           KeyObtainInfo
+		signing, exchange string
+	)
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		keyInfo, _, _ = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{}, executor)
+		signing, exchange = executor.addKeyPair(keyPath)
+	})
+
+	It("Should read the keys advertised", func() {
+		stdo, _, err := keyInfo.podExec(ctx, nil, advertisementCommand(keyInfo)...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(advertisementCommand(keyInfo)).To(ContainElement("http://localhost:8080/adv"))
+		advertised, err := advertisedThumbprints([]byte(stdo))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(advertised).To(HaveLen(2))
+		Expect(advertised).To(HaveKey(strings.TrimSuffix(signing, ".jwk")))
+
+		for _, adv := range []string{"not json", `{"payload":"!"}`, `{"payload":"` + base64.RawURLEncoding.EncodeToString([]byte("[]")) + `"}`} {
+			_, err := advertisedThumbprints([]byte(adv))
+			Expect(err).To(HaveOccurred(), adv)
+		}
+	})
+
+	It("Should generate and verify a new key pair before hiding the old one", func() {
+		executor.commands = nil
+		pair, err := rotateKeyPair(ctx, keyInfo, signing, KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON)
+		Expect(err).ToNot(HaveOccurred())
+		entries := executor.entries(keyPath)
+		Expect(entries).To(ContainElements("."+signing, "."+exchange, pair.Signing.File.Name, pair.Exchange.File.Name))
+		Expect(pair.Signing.JWK.IsSigningKey()).To(BeTrue())
+
+		generated, verified, hidden := -1, -1, -1
+		for i, command := range executor.commands {
+			switch {
+			case strings.HasPrefix(command, "/usr/libexec/tangd-keygen"):
+				generated = i
+			case strings.HasPrefix(command, "curl"):
+				verified = i
+			case command == "mv "+keyPath+"/"+signing+" "+keyPath+"/."+signing:
+				hidden = i
+			}
+		}
+		Expect(generated).To(BeNumerically(">=", 0))
+		Expect(verified).To(BeNumerically(">", generated))
+		Expect(hidden).To(BeNumerically(">", verified))
+	})
+
+	It("Should keep the new key pair when the old keys can not be paired", func() {
+		// An exchange key written long after the signing key is not paired with it
+		executor.mu.Lock()
+		executor.files[keyPath+"/"+exchange].modTime = executor.files[keyPath+"/"+signing].modTime.Add(5 * time.Second)
+		executor.mu.Unlock()
+		pair, err := rotateKeyPair(ctx, keyInfo, signing, KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(executor.entries(keyPath)).To(ConsistOf("."+signing, "."+exchange, pair.Signing.File.Name, pair.Exchange.File.Name))
+	})
+
+	It("Should keep the old key pair if no key pair is generated", func() {
+		executor.failOn = []string{"/usr/libexec/tangd-keygen"}
+		_, err := rotateKeyPair(ctx, keyInfo, signing, KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON)
+		Expect(err).To(HaveOccurred())
+		Expect(executor.entries(keyPath)).To(ConsistOf(signing, exchange))
+	})
+
+	It("Should remove the new key pair and keep the old one if it is not advertised", func() {
+		executor.failOn = []string{"curl"}
+		_, err := rotateKeyPair(ctx, keyInfo, signing, KEY_HIDDEN_BY_SPEC, KEY_HIDDEN_BY_SPEC_REASON)
+		Expect(err).To(HaveOccurred())
+		Expect(executor.entries(keyPath)).To(ConsistOf(signing, exchange))
+	})
+})
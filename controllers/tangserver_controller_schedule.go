@@ -0,0 +1,284 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Recheck of keys when a due key rotation or hidden key pair purge could not be completed
+const DEFAULT_RECONCILE_TIMER_SCHEDULED_KEYS_RETRY = 60 // seconds
+
+// Scheduled times checked for one within the maintenance window, so that schedules never matching the window end
+const KEY_ROTATION_SCHEDULE_SEARCH_LIMIT = 1000
+
+// keyRotationSchedule is the key rotation policy of a TangServer, with its cron schedule parsed
+type keyRotationSchedule struct {
+	policy   *daemonsv1alpha1.KeyRotationPolicy
+	schedule *daemonsv1alpha1.CronSchedule
+}
+
+// newKeyRotationSchedule parses the key rotation policy provided
+func newKeyRotationSchedule(policy *daemonsv1alpha1.KeyRotationPolicy) (*keyRotationSchedule, error) {
+	if policy.MaxKeyAge.Duration <= 0 {
+		return nil, fmt.Errorf("maximum key age must be greater than zero")
+	}
+	s := &keyRotationSchedule{policy: policy}
+	if policy.Schedule != "" {
+		schedule, err := daemonsv1alpha1.ParseCronSchedule(policy.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key rotation schedule %q: %w", policy.Schedule, err)
+		}
+		s.schedule = schedule
+	}
+	if w := policy.MaintenanceWindow; w != nil {
+		if _, err := w.StartOffset(); err != nil {
+			return nil, err
+		}
+		if w.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("maintenance window duration must be greater than zero")
+		}
+	}
+	return s, nil
+}
+
+// due returns the time a key pair generated at the time provided is rotated: the first scheduled time within
+// the maintenance window once the key pair is older than the maximum key age. It returns the zero time if the
+// schedule never matches the maintenance window
+func (s *keyRotationSchedule) due(generated time.Time) time.Time {
+	t := generated.UTC().Add(s.policy.MaxKeyAge.Duration)
+	for i := 0; i < KEY_ROTATION_SCHEDULE_SEARCH_LIMIT; i++ {
+		if s.schedule != nil {
+			// First scheduled time at or after t
+			if t = s.schedule.Next(t.Add(-time.Nanosecond)); t.IsZero() {
+				return t
+			}
+		}
+		open, err := s.nextOpen(t)
+		if err != nil || open.Equal(t) || s.schedule == nil {
+			return open
+		}
+		t = open
+	}
+	return time.Time{}
+}
+
+// nextOpen returns the time provided if the maintenance window is open then, or the time it opens next otherwise
+func (s *keyRotationSchedule) nextOpen(t time.Time) (time.Time, error) {
+	if s.policy.MaintenanceWindow == nil {
+		return t, nil
+	}
+	return s.policy.MaintenanceWindow.NextOpen(t)
+}
+
+// getRequiredActiveKeyPairs returns the amount of active key pairs required by the TangServer
+func getRequiredActiveKeyPairs(cr *daemonsv1alpha1.TangServer) uint32 {
+	if cr.Spec.RequiredActiveKeyPairs > 0 {
+		return cr.Spec.RequiredActiveKeyPairs
+	}
+	return daemonsv1alpha1.DefaultActiveKeyPairs
+}
+
+// now returns the current time of the reconciler
+func (r *TangServerReconciler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// setNextKeyRotation records the next rotation of the key rotation policy in status, none if zero
+func setNextKeyRotation(cr *daemonsv1alpha1.TangServer, next time.Time) {
+	if next.IsZero() {
+		cr.Status.NextKeyRotation = nil
+		return
+	}
+	cr.Status.NextKeyRotation = &metav1.Time{Time: next.UTC().Truncate(time.Second)}
+}
+
+// rotateAgedKeys rotates the active key pairs older than the maximum key age of the key rotation policy, when
+// its schedule and maintenance window allow it, and records the next rotation in status. Key ages are read from
+// the key files, and replacements generated by an interrupted rotation are reused, so that an operator restart
+// never rotates a key pair twice
+func (r *TangServerReconciler) rotateAgedKeys(ctx context.Context, k KeyObtainInfo) {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	if cr.Spec.KeyRotation == nil {
+		cr.Status.NextKeyRotation = nil
+		return
+	}
+	s, err := newKeyRotationSchedule(cr.Spec.KeyRotation)
+	if err != nil {
+		l.Error(err, "Invalid key rotation policy, keys not rotated")
+		setDegraded(cr, daemonsv1alpha1.ReasonInvalidKeyRotationPolicy, err.Error())
+		cr.Status.NextKeyRotation = nil
+		return
+	}
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		l.Error(err, "Unable to read keys, keys not rotated", "podname", k.PodName, "namespace", k.Namespace)
+		return
+	}
+	maxKeyAge := cr.Spec.KeyRotation.MaxKeyAge.Duration
+	now := r.now()
+	var aged, fresh []KeyPair
+	var oldest time.Time
+	for _, p := range pairKeys(inventory).Pairs {
+		if p.Hidden() {
+			continue
+		}
+		generated := p.Signing.File.ModTime
+		if oldest.IsZero() || generated.Before(oldest) {
+			oldest = generated
+		}
+		if now.Sub(generated) >= maxKeyAge {
+			aged = append(aged, p)
+		} else {
+			fresh = append(fresh, p)
+		}
+	}
+	if oldest.IsZero() {
+		l.Info("No active key pairs to rotate")
+		cr.Status.NextKeyRotation = nil
+		return
+	}
+	next := s.due(oldest)
+	if next.IsZero() {
+		l.Info("Key rotation schedule never matches the maintenance window", "Schedule", cr.Spec.KeyRotation.Schedule)
+	} else if !now.Before(next) {
+		// A rotation missed while the operator was down is performed on the next opening of the window
+		if next, err = s.nextOpen(now); err == nil && next.Equal(now) {
+			r.rotateKeyPairs(ctx, k, aged, fresh)
+			next = r.nextKeyRotation(ctx, k, s)
+		}
+	}
+	setNextKeyRotation(cr, next)
+	l.Info("Key rotation scheduled", "Next Key Rotation", cr.Status.NextKeyRotation)
+}
+
+// rotateKeyPairs hides the aged key pairs provided, generating replacements until the required active key
+// pairs are fresh
+func (r *TangServerReconciler) rotateKeyPairs(ctx context.Context, k KeyObtainInfo, aged []KeyPair, fresh []KeyPair) {
+	l := log.FromContext(ctx)
+	cr := k.TangServer
+	maxKeyAge := cr.Spec.KeyRotation.MaxKeyAge.Duration
+	required := int(getRequiredActiveKeyPairs(cr))
+	reason := fmt.Sprintf("Key pair older than maximum key age %s", maxKeyAge)
+	rotationFailed := false
+	for _, p := range aged {
+		keyFileName := p.Signing.File.Name
+		l.Info("Key pair older than maximum key age must be rotated", "Key File", keyFileName, "Generated", p.Signing.File.ModTime,
+			"Maximum Key Age", maxKeyAge)
+		r.startRotation(ctx, cr, keyFileName)
+		var err error
+		if len(fresh) >= required {
+			// Enough fresh key pairs, e.g. a replacement generated before an operator restart
+			if err = hideKeyPair(ctx, k, keyFileName, nil, KEY_HIDDEN_BY_ROTATION_POLICY, reason); err == nil {
+				r.Recorder.Eventf(cr, nil, "Normal", "KeyRotation", "KeyRotation",
+					"Key older than %s Rotated Correctly, Key File: %s", maxKeyAge, keyFileName)
+			}
+		} else {
+			var pair KeyPair
+			if pair, err = rotateKeyPair(ctx, k, keyFileName, KEY_HIDDEN_BY_ROTATION_POLICY, reason); err == nil {
+				fresh = append(fresh, pair)
+				r.Recorder.Eventf(cr, nil, "Normal", "KeyRotation", "KeyRotation",
+					"Key older than %s Rotated Correctly, Key File: %s, New Key File: %s", maxKeyAge, keyFileName, pair.Signing.File.Name)
+			}
+		}
+		if err != nil {
+			rotationFailed = true
+			l.Error(err, "Key older than maximum key age not rotated correctly", "Key File", keyFileName)
+			r.Recorder.Eventf(cr, nil, "Warning", "KeyRotation", "KeyRotation",
+				"Key older than %s NOT Rotated Correctly, Key File: %s", maxKeyAge, keyFileName)
+			setDegraded(cr, daemonsv1alpha1.ReasonKeyRotationFailed, fmt.Sprintf("Unable to rotate key file %s", keyFileName))
+		}
+	}
+	for !rotationFailed && len(fresh) < required {
+		inventory, err := readKeyInventory(ctx, k)
+		var pair KeyPair
+		if err == nil {
+			pair, err = generateKeyPair(ctx, k, inventory)
+		}
+		if err != nil {
+			rotationFailed = true
+			l.Error(err, "Unable to generate required fresh key pairs", "Fresh Key Pairs", len(fresh), "Required", required)
+			r.Recorder.Eventf(cr, nil, "Warning", "NewKeys", "NewKeys", "Unable to create new pair of keys")
+			break
+		}
+		fresh = append(fresh, pair)
+		r.Recorder.Eventf(cr, nil, "Normal", "NewKeys", "NewKeys", "Created active pair of keys, Key File: %s", pair.Signing.File.Name)
+	}
+	if rotationFailed {
+		setCondition(cr, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationFailed, "Last key rotation failed")
+	} else if len(aged) > 0 {
+		setCondition(cr, daemonsv1alpha1.ConditionRotationInProgress, metav1.ConditionFalse,
+			daemonsv1alpha1.ReasonKeyRotationSucceeded, "Last key rotation completed")
+		r.activeKeyRetries.reset(client.ObjectKeyFromObject(cr))
+	}
+}
+
+// nextKeyRotation returns the next rotation of the active key pairs after a rotation, the zero time if unknown
+func (r *TangServerReconciler) nextKeyRotation(ctx context.Context, k KeyObtainInfo, s *keyRotationSchedule) time.Time {
+	inventory, err := readKeyInventory(ctx, k)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Unable to read keys after key rotation", "podname", k.PodName, "namespace", k.Namespace)
+		return time.Time{}
+	}
+	var oldest time.Time
+	for _, p := range pairKeys(inventory).Pairs {
+		if !p.Hidden() && (oldest.IsZero() || p.Signing.File.ModTime.Before(oldest)) {
+			oldest = p.Signing.File.ModTime
+		}
+	}
+	if oldest.IsZero() {
+		return oldest
+	}
+	return s.due(oldest)
+}
+
+// untilScheduledKeyChange returns the time until the next key rotation or hidden key pair purge recorded in
+// status, zero if none is scheduled
+func untilScheduledKeyChange(cr *daemonsv1alpha1.TangServer, now time.Time) time.Duration {
+	scheduled := make([]*metav1.Time, 0, len(cr.Status.KeyPairs)+1)
+	scheduled = append(scheduled, cr.Status.NextKeyRotation)
+	for _, p := range cr.Status.KeyPairs {
+		scheduled = append(scheduled, p.Purge)
+	}
+	var until time.Duration
+	for _, t := range scheduled {
+		if t == nil {
+			continue
+		}
+		next := t.Sub(now)
+		if next <= 0 {
+			// The change is due but could not be completed
+			next = time.Duration(DEFAULT_RECONCILE_TIMER_SCHEDULED_KEYS_RETRY) * time.Second
+		}
+		if until == 0 || next < until {
+			until = next
+		}
+	}
+	return until
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+)
+
+var _ = Describe("TangServer controller key rotation schedule", func() {
+	const keyPath = "/var/db/tang"
+	const maxKeyAge = 30 * 24 * time.Hour
+	var (
+		executor          *fakePodExecutor
+		keyInfo           KeyObtainInfo
+		reconciler        *TangServerReconciler
+		recorder          *events.FakeRecorder
+		signing, exchange string
+		generated         time.Time
+		now               time.Time
+	)
+	ctx := context.Background()
+
+	// activePairs returns the active key pairs of the key directory
+	activePairs := func() []KeyPair {
+		inventory, err := readKeyInventory(ctx, keyInfo)
+		Expect(err).ToNot(HaveOccurred())
+		var pairs []KeyPair
+		for _, p := range pairKeys(inventory).Pairs {
+			if !p.Hidden() {
+				pairs = append(pairs, p)
+			}
+		}
+		return pairs
+	}
+
+	BeforeEach(func() {
+		executor = newFakePodExecutor()
+		generated = executor.now
+		keyInfo, reconciler, recorder = newKeyManagementFixture(daemonsv1alpha1.TangServerSpec{
+			KeyRotation: &daemonsv1alpha1.KeyRotationPolicy{MaxKeyAge: metav1.Duration{Duration: maxKeyAge}},
+		}, executor)
+		reconciler.Now = func() time.Time { return now }
+		signing, exchange = executor.addKeyPair(keyPath)
+	})
+
+	Context("When computing the next rotation", func() {
+		It("Should rotate once the key pair is older than the maximum key age", func() {
+			s, err := newKeyRotationSchedule(keyInfo.TangServer.Spec.KeyRotation)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.due(generated)).To(Equal(generated.Add(maxKeyAge)))
+		})
+
+		It("Should rotate at the first scheduled time within the maintenance window", func() {
+			policy := &daemonsv1alpha1.KeyRotationPolicy{
+				MaxKeyAge: metav1.Duration{Duration: maxKeyAge},
+				// Sundays at 02:00 and 06:00, and only the first one within the window
+				Schedule:          "0 2,6 * * 0",
+				MaintenanceWindow: &daemonsv1alpha1.MaintenanceWindow{Start: "01:00", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+			}
+			s, err := newKeyRotationSchedule(policy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.due(generated)).To(Equal(time.Date(2023, 2, 5, 2, 0, 0, 0, time.UTC)))
+
+			policy.Schedule = ""
+			s, err = newKeyRotationSchedule(policy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.due(generated)).To(Equal(time.Date(2023, 1, 31, 1, 0, 0, 0, time.UTC)))
+
+			policy.Schedule = "0 2 * * *"
+			policy.MaintenanceWindow.Start = "03:00"
+			s, err = newKeyRotationSchedule(policy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.due(generated).IsZero()).To(BeTrue())
+		})
+
+		It("Should reject invalid policies", func() {
+			for _, policy := range []*daemonsv1alpha1.KeyRotationPolicy{
+				{},
+				{MaxKeyAge: metav1.Duration{Duration: maxKeyAge}, Schedule: "every sunday"},
+				{MaxKeyAge: metav1.Duration{Duration: maxKeyAge}, MaintenanceWindow: &daemonsv1alpha1.MaintenanceWindow{Start: "01:00"}},
+			} {
+				_, err := newKeyRotationSchedule(policy)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("When rotating keys by age", func() {
+		It("Should only schedule the rotation of key pairs younger than the maximum key age", func() {
+			now = generated.Add(time.Hour)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(keyInfo.TangServer.Status.NextKeyRotation.Time).To(Equal(generated.Add(maxKeyAge)))
+			Expect(executor.entries(keyPath)).To(ConsistOf(signing, exchange))
+			Expect(untilScheduledKeyChange(keyInfo.TangServer, now)).To(Equal(maxKeyAge - time.Hour))
+		})
+
+		It("Should replace key pairs older than the maximum key age and schedule the next rotation", func() {
+			now = generated.Add(maxKeyAge + time.Minute)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			entries := executor.entries(keyPath)
+			Expect(entries).To(ContainElements("."+signing, "."+exchange))
+			pairs := activePairs()
+			Expect(pairs).To(HaveLen(1))
+			Expect(keyInfo.TangServer.Status.NextKeyRotation.Time).To(Equal(pairs[0].Signing.File.ModTime.Add(maxKeyAge).Truncate(time.Second)))
+			Expect(recorder.Events).To(Receive(ContainSubstring(pairs[0].Signing.File.Name)))
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionRotationInProgress)).To(BeFalse())
+
+			content, found := executor.fileContent(keyPath + "/" + KEY_STATUS_FILE_NAME)
+			Expect(found).To(BeTrue())
+			keyStatus, err := parseKeyStatus(content)
+			Expect(err).ToNot(HaveOccurred())
+			assoc := keyStatus.KeyStatusSha256Map[strings.TrimSuffix(signing, ".jwk")]
+			Expect(assoc.RotatedBy).To(Equal(KEY_HIDDEN_BY_ROTATION_POLICY))
+		})
+
+		It("Should not rotate twice after a restart", func() {
+			// The replacement was generated before the operator restarted, the old key pair is only hidden
+			executor.now = generated.Add(maxKeyAge)
+			executor.addKeyPair(keyPath)
+			now = executor.now
+			executor.commands = nil
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			for _, command := range executor.commands {
+				Expect(command).ToNot(HavePrefix("/usr/libexec/tangd-keygen"))
+			}
+			Expect(activePairs()).To(HaveLen(1))
+			Expect(executor.entries(keyPath)).To(ContainElements("."+signing, "."+exchange))
+
+			executor.commands = nil
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			for _, command := range executor.commands {
+				Expect(command).ToNot(HavePrefix("mv "))
+			}
+		})
+
+		It("Should generate the required active key pairs", func() {
+			keyInfo.TangServer.Spec.RequiredActiveKeyPairs = 3
+			now = generated.Add(maxKeyAge)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(activePairs()).To(HaveLen(3))
+		})
+
+		It("Should wait for the maintenance window", func() {
+			keyInfo.TangServer.Spec.KeyRotation.MaintenanceWindow = &daemonsv1alpha1.MaintenanceWindow{
+				Start: "22:00", Duration: metav1.Duration{Duration: time.Hour},
+			}
+			// Opened for the last time before the rotation was due, the operator was down
+			now = time.Date(2023, 2, 2, 10, 0, 0, 0, time.UTC)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ConsistOf(signing, exchange))
+			Expect(keyInfo.TangServer.Status.NextKeyRotation.Time).To(Equal(time.Date(2023, 2, 2, 22, 0, 0, 0, time.UTC)))
+
+			now = time.Date(2023, 2, 2, 22, 30, 0, 0, time.UTC)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ContainElements("."+signing, "."+exchange))
+		})
+
+		It("Should keep the key pair and retry if the replacement can not be generated", func() {
+			executor.failOn = []string{"/usr/libexec/tangd-keygen"}
+			now = generated.Add(maxKeyAge)
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(executor.entries(keyPath)).To(ConsistOf(signing, exchange))
+			Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionDegraded)).To(BeTrue())
+			Expect(untilScheduledKeyChange(keyInfo.TangServer, now)).To(Equal(time.Duration(DEFAULT_RECONCILE_TIMER_SCHEDULED_KEYS_RETRY) * time.Second))
+		})
+
+		It("Should not schedule rotations without a key rotation policy", func() {
+			keyInfo.TangServer.Status.NextKeyRotation = &metav1.Time{Time: generated}
+			keyInfo.TangServer.Spec.KeyRotation = nil
+			reconciler.rotateAgedKeys(ctx, keyInfo)
+			Expect(keyInfo.TangServer.Status.NextKeyRotation).To(BeNil())
+			Expect(untilScheduledKeyChange(keyInfo.TangServer, now)).To(BeZero())
+		})
+
+		It("Should resume interrupted rotations of the key rotation policy", func() {
+			inventory, err := readKeyInventory(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			j := newRotationJournal(ctx, keyInfo, signing, inventory, nil)
+			j.RotatedBy = KEY_HIDDEN_BY_ROTATION_POLICY
+			Expect(writeRotationJournal(ctx, keyInfo, j)).To(Succeed())
+			Expect(moveKeyFile(ctx, keyInfo, signing, "."+signing)).To(Succeed())
+			_, recovery, err := recoverRotation(ctx, keyInfo)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recovery).To(Equal(ROTATION_RESUMED))
+		})
+	})
+
+	It("Should not report key pairs hidden by the key rotation policy as relying on deletion by omission", func() {
+		now = generated.Add(maxKeyAge)
+		reconciler.rotateAgedKeys(ctx, keyInfo)
+		other := activePairs()[0].Signing
+		keyInfo.TangServer.Spec.HiddenKeys = []daemonsv1alpha1.TangServerHiddenKeys{{Sha256: other.Sha256}}
+		reconciler.UpdateKeys(ctx, keyInfo)
+		Expect(executor.entries(keyPath)).To(ContainElements("."+signing, "."+exchange))
+		Expect(isConditionTrue(keyInfo.TangServer, daemonsv1alpha1.ConditionHiddenKeysMigrationRequired)).To(BeFalse())
+	})
+})
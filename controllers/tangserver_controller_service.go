@@ -27,7 +27,7 @@ import (
 
 // constants to use
 const (
-	DEFAULT_SERVICE_PORT   = 7500
+	DEFAULT_SERVICE_PORT   = daemonsv1alpha1.DefaultServiceListenPort
 	DEFAULT_SERVICE_TYPE   = "Service"
 	DEFAULT_API_VERSION    = "v1"
 	DEFAULT_SERVICE_PREFIX = "service-"
@@ -66,14 +66,21 @@ func getClusterIP(tangserver *daemonsv1alpha1.TangServer) string {
 	return tangserver.Spec.ClusterIP
 }
 
+// getNodePort function returns the node port to request, or 0 to let the cluster allocate one
+func getNodePort(tangserver *daemonsv1alpha1.TangServer) int32 {
+	serviceType := getServiceType(tangserver)
+	if serviceType != corev1.ServiceTypeNodePort && serviceType != corev1.ServiceTypeLoadBalancer {
+		return 0
+	}
+	return tangserver.Spec.NodePort
+}
+
 // getService function returns correctly created service
 func getService(tangserver *daemonsv1alpha1.TangServer) *corev1.Service {
-	GetLogInstance().Info("getService")
 	labels := map[string]string{
 		"app": tangserver.Name,
 	}
 	servicePort := getServicePort(tangserver)
-	GetLogInstance().Info("Listening Port", "servicePort", servicePort)
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: DEFAULT_API_VERSION,
@@ -92,6 +99,7 @@ func getService(tangserver *daemonsv1alpha1.TangServer) *corev1.Service {
 					Name:       DEFAULT_SERVICE_PROTO,
 					Port:       servicePort,
 					TargetPort: intstr.FromInt(int(getPodListenPort(tangserver))),
+					NodePort:   getNodePort(tangserver),
 				},
 			},
 			ClusterIP: getClusterIP(tangserver),
@@ -25,7 +25,6 @@ import (
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 var _ = Describe("TangServer controller service", func() {
@@ -116,6 +115,21 @@ var _ = Describe("TangServer controller service", func() {
 			})
 		})
 
+		Context("getNodePort", func() {
+			It("should return node port for NodePort services", func() {
+				tangServer.Spec.ServiceType = "NodePort"
+				tangServer.Spec.NodePort = 30080
+				Expect(getNodePort(tangServer)).To(Equal(int32(30080)))
+				Expect(getService(tangServer).Spec.Ports[0].NodePort).To(Equal(int32(30080)))
+			})
+
+			It("should ignore node port for ClusterIP services", func() {
+				tangServer.Spec.ServiceType = "ClusterIP"
+				tangServer.Spec.NodePort = 30080
+				Expect(getNodePort(tangServer)).To(Equal(int32(0)))
+			})
+		})
+
 		Context("service configuration", func() {
 			It("should handle service configuration correctly", func() {
 				tangServer.Spec.ServiceListenPort = 9000
@@ -139,7 +153,6 @@ var _ = Describe("TangServer controller service", func() {
 				Spec: daemonsv1alpha1.TangServerSpec{},
 			}
 			Expect(k8sClient.Create(ctx, tangServer)).Should(Succeed())
-			SetLogInstance(log.FromContext(ctx))
 			service := getService(tangServer)
 			Expect(service, Not(nil))
 			Expect(service.TypeMeta.Kind, DEFAULT_SERVICE_TYPE)
@@ -161,7 +174,6 @@ var _ = Describe("TangServer controller service", func() {
 				},
 			}
 			Expect(k8sClient.Create(ctx, tangServer)).Should(Succeed())
-			SetLogInstance(log.FromContext(ctx))
 			service := getService(tangServer)
 			Expect(service, Not(nil))
 			Expect(service.TypeMeta.Kind, DEFAULT_SERVICE_TYPE)
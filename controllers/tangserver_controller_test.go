@@ -347,6 +347,11 @@ var _ = Describe("TangServer controller", func() {
 			Expect(mustRedeploy(deployment2, deployment1)).To(BeTrue())
 		})
 
+		It("Should detect when redeployment is needed to set the operator pod label", func() {
+			deployment2.Spec.Template.Labels = map[string]string{TANGSERVER_POD_LABEL: "test-tangserver"}
+			Expect(mustRedeploy(deployment2, deployment1)).To(BeTrue())
+		})
+
 		It("Should not require redeployment when resources are the same", func() {
 			Expect(mustRedeploy(deployment1, deployment2)).To(BeFalse())
 		})
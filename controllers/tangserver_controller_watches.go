@@ -0,0 +1,133 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// tangServerChanged filters out the TangServer updates performed by the controller on its status, which would
+// otherwise trigger a new reconciliation after each one
+var tangServerChanged = predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+
+// deploymentChanged filters the updates of the owned deployment to the ones changing its spec or its replicas
+var deploymentChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, okOld := e.ObjectOld.(*appsv1.Deployment)
+		updated, okNew := e.ObjectNew.(*appsv1.Deployment)
+		if !okOld || !okNew {
+			return true
+		}
+		return old.Generation != updated.Generation ||
+			old.Status.ObservedGeneration != updated.Status.ObservedGeneration ||
+			old.Status.Replicas != updated.Status.Replicas ||
+			old.Status.ReadyReplicas != updated.Status.ReadyReplicas ||
+			old.Status.AvailableReplicas != updated.Status.AvailableReplicas ||
+			old.Status.UpdatedReplicas != updated.Status.UpdatedReplicas
+	},
+}
+
+// serviceChanged filters the updates of the owned service to the ones changing its spec or its external address
+var serviceChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, okOld := e.ObjectOld.(*corev1.Service)
+		updated, okNew := e.ObjectNew.(*corev1.Service)
+		if !okOld || !okNew {
+			return true
+		}
+		return !reflect.DeepEqual(old.Spec, updated.Spec) ||
+			!reflect.DeepEqual(old.Status.LoadBalancer, updated.Status.LoadBalancer)
+	},
+}
+
+// podChanged filters the updates of the tang pods to the ones changing the status reported for them, such as
+// their readiness, their restarts or their deletion
+var podChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, okOld := e.ObjectOld.(*corev1.Pod)
+		updated, okNew := e.ObjectNew.(*corev1.Pod)
+		if !okOld || !okNew {
+			return true
+		}
+		return !reflect.DeepEqual(podStatuses([]corev1.Pod{*old}), podStatuses([]corev1.Pod{*updated})) ||
+			!reflect.DeepEqual(old.Labels, updated.Labels)
+	},
+}
+
+// TangServerPodSelector returns the selector of the tang pods, the only ones the manager has to cache
+func TangServerPodSelector() labels.Selector {
+	requirement, err := labels.NewRequirement(TANGSERVER_POD_LABEL, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*requirement)
+}
+
+// podToTangServer returns the reconciliation of the TangServer a tang pod belongs to, through its labels. Pods
+// labelled with the name of a missing TangServer are ignored
+func (r *TangServerReconciler) podToTangServer(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[TANGSERVER_POD_LABEL]
+	if name == "" {
+		return nil
+	}
+	key := types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}
+	if err := r.Get(ctx, key, &daemonsv1alpha1.TangServer{}); err != nil {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: key}}
+}
+
+// tangServersReferencing returns the reconciliations of the TangServers of the namespace of the object provided
+// which reference it, by the name returned for each of them
+func (r *TangServerReconciler) tangServersReferencing(ctx context.Context, obj client.Object, reference func(*daemonsv1alpha1.TangServer) string) []reconcile.Request {
+	tangServers := &daemonsv1alpha1.TangServerList{}
+	if err := r.List(ctx, tangServers, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to list TangServers referencing object", "Name", obj.GetName(), "Namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range tangServers.Items {
+		cr := &tangServers.Items[i]
+		if reference(cr) == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cr)})
+		}
+	}
+	return requests
+}
+
+// persistentVolumeClaimToTangServers returns the reconciliations of the TangServers using a persistent volume claim
+func (r *TangServerReconciler) persistentVolumeClaimToTangServers(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.tangServersReferencing(ctx, obj, getPersistentVolumeClaim)
+}
+
+// secretToTangServers returns the reconciliations of the TangServers using a secret to pull their image
+func (r *TangServerReconciler) secretToTangServers(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.tangServersReferencing(ctx, obj, getSecret)
+}
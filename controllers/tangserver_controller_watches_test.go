@@ -0,0 +1,139 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("TangServer controller watches", func() {
+	// updated returns whether the predicate lets the update of the object through
+	updated := func(p interface {
+		Update(event.UpdateEvent) bool
+	}, old, new client.Object) bool {
+		return p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new})
+	}
+
+	Context("When filtering updates", func() {
+		It("Should ignore TangServer status updates", func() {
+			old := &daemonsv1alpha1.TangServer{ObjectMeta: metav1.ObjectMeta{Name: "tang", Generation: 1}}
+			status := old.DeepCopy()
+			status.Status.Ready = 1
+			Expect(updated(tangServerChanged, old, status)).To(BeFalse())
+			spec := old.DeepCopy()
+			spec.Generation = 2
+			Expect(updated(tangServerChanged, old, spec)).To(BeTrue())
+		})
+
+		It("Should only act on deployment changes affecting replicas", func() {
+			old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "tang", Generation: 1}}
+			resync := old.DeepCopy()
+			resync.ResourceVersion = "2"
+			Expect(updated(deploymentChanged, old, resync)).To(BeFalse())
+			ready := old.DeepCopy()
+			ready.Status.ReadyReplicas = 1
+			Expect(updated(deploymentChanged, old, ready)).To(BeTrue())
+		})
+
+		It("Should act on the external address of the service", func() {
+			old := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "tang"}}
+			Expect(updated(serviceChanged, old, old.DeepCopy())).To(BeFalse())
+			assigned := old.DeepCopy()
+			assigned.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+			Expect(updated(serviceChanged, old, assigned)).To(BeTrue())
+		})
+
+		It("Should act on tang pod restarts and readiness only", func() {
+			old := readyPod("tang-0", time.Now())
+			annotated := old.DeepCopy()
+			annotated.Annotations = map[string]string{"test": "annotated"}
+			Expect(updated(podChanged, &old, annotated)).To(BeFalse())
+			restarted := old.DeepCopy()
+			restarted.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: DEFAULT_TANGSERVER_NAME, RestartCount: 1}}
+			Expect(updated(podChanged, &old, restarted)).To(BeTrue())
+			notReady := old.DeepCopy()
+			notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+			Expect(updated(podChanged, &old, notReady)).To(BeTrue())
+		})
+	})
+
+	Context("When mapping objects to TangServers", func() {
+		var reconciler *TangServerReconciler
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			scheme.Scheme.AddKnownTypes(daemonsv1alpha1.GroupVersion,
+				&daemonsv1alpha1.TangServer{},
+				&daemonsv1alpha1.TangServerList{},
+			)
+			withDefaults := &daemonsv1alpha1.TangServer{ObjectMeta: metav1.ObjectMeta{Name: "tang-defaults", Namespace: "default"}}
+			custom := &daemonsv1alpha1.TangServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "tang-custom", Namespace: "default"},
+				Spec:       daemonsv1alpha1.TangServerSpec{PersistentVolumeClaim: "custom-pvc", Secret: "custom-secret"},
+			}
+			reconciler = &TangServerReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(withDefaults, custom).Build(),
+				Scheme: scheme.Scheme,
+			}
+		})
+
+		It("Should map tang pods to their TangServer through their labels", func() {
+			pod := readyPod("tang-custom-abc", time.Now())
+			pod.Labels = map[string]string{"app": "tang-custom"}
+			Expect(reconciler.podToTangServer(ctx, &pod)).To(BeEmpty())
+			pod.Labels[TANGSERVER_POD_LABEL] = "tang-custom"
+			Expect(reconciler.podToTangServer(ctx, &pod)).To(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "tang-custom", Namespace: "default"}},
+			}))
+			pod.Labels[TANGSERVER_POD_LABEL] = "not-a-tangserver"
+			Expect(reconciler.podToTangServer(ctx, &pod)).To(BeEmpty())
+		})
+
+		It("Should only select the pods with the operator label", func() {
+			Expect(TangServerPodSelector().Matches(labels.Set{TANGSERVER_POD_LABEL: "tang-custom"})).To(BeTrue())
+			Expect(TangServerPodSelector().Matches(labels.Set{"app": "tang-custom"})).To(BeFalse())
+		})
+
+		It("Should map the referenced persistent volume claims and secrets to their TangServers", func() {
+			pvc := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "custom-pvc", Namespace: "default"}}
+			Expect(reconciler.persistentVolumeClaimToTangServers(ctx, pvc)).To(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "tang-custom", Namespace: "default"}},
+			}))
+			secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: DEFAULT_TANGSERVER_SECRET, Namespace: "default"}}
+			Expect(reconciler.secretToTangServers(ctx, secret)).To(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Name: "tang-defaults", Namespace: "default"}},
+			}))
+			secret.Namespace = "other"
+			Expect(reconciler.secretToTangServers(ctx, secret)).To(BeEmpty())
+		})
+	})
+})
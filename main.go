@@ -20,19 +20,24 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	daemonsv1alpha1 "github.com/openshift/nbde-tang-server/api/v1alpha1"
+	daemonsv1beta1 "github.com/openshift/nbde-tang-server/api/v1beta1"
 	"github.com/openshift/nbde-tang-server/controllers"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	webhook "sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -48,6 +53,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(daemonsv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(daemonsv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -55,11 +61,20 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
+	var execTimeout time.Duration
+	var keyManagementTimeout time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"Maximum number of TangServers that can be reconciled concurrently.")
+	flag.DurationVar(&execTimeout, "exec-timeout", controllers.DEFAULT_EXEC_TIMEOUT,
+		"Maximum time allowed for each command executed in the tang pods.")
+	flag.DurationVar(&keyManagementTimeout, "key-management-timeout", controllers.DEFAULT_KEY_MANAGEMENT_TIMEOUT,
+		"Maximum time allowed for the key management performed in a reconciliation.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -89,20 +104,46 @@ func main() {
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "e44fa0d3.redhat.com",
 		WebhookServer:          webhookServer,
+		// Only the tang pods are watched, so the other pods of the cluster are not cached
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}: {Label: controllers.TangServerPodSelector()},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	executor, err := controllers.NewSPDYPodExecutor(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create pod executor")
+		os.Exit(1)
+	}
 	if err = (&controllers.TangServerReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorder("nbde-tang-server-controller"),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorder("nbde-tang-server-controller"),
+		APIReader:               mgr.GetAPIReader(),
+		Executor:                executor,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ExecTimeout:             execTimeout,
+		KeyManagementTimeout:    keyManagementTimeout,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TangServer")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&daemonsv1alpha1.TangServer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TangServer")
+			os.Exit(1)
+		}
+		if err = (&daemonsv1beta1.TangServer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create conversion webhook", "webhook", "TangServer")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {